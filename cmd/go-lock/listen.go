@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stoex/go-lock/internal/config"
+	"net"
+	"os"
+)
+
+// listen opens the socket of l. A stale unix socket left behind by a previous run is removed first.
+func listen(l config.ListenerConfig) (net.Listener, error) {
+	if l.Network != "unix" {
+		return net.Listen(l.Network, l.Address)
+	}
+
+	if fi, err := os.Lstat(l.Address); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", l.Address)
+		}
+		if err := os.Remove(l.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	mode, err := l.FileMode()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return listenUnix(l.Address, os.FileMode(mode))
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnix creates the unix socket at address with mode, 0 keeps the default of the process umask.
+// The umask is set while the socket is created, so it never exists with wider permissions than mode.
+// The umask is process wide, nothing else creates files while the listeners are opened on startup.
+func listenUnix(address string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		return net.Listen("unix", address)
+	}
+
+	old := syscall.Umask(int(^mode & os.ModePerm))
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", address)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix creates the unix socket at address and sets its mode afterwards, windows has no umask
+func listenUnix(address string, mode os.FileMode) (net.Listener, error) {
+	lis, err := net.Listen("unix", address)
+
+	if err != nil || mode == 0 {
+		return lis, err
+	}
+
+	if err := os.Chmod(address, mode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+
+	return lis, nil
+}
@@ -2,20 +2,34 @@ package main
 
 import (
 	"context"
+	cryptotls "crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/stoex/go-lock/internal/audit"
+	"github.com/stoex/go-lock/internal/auth"
+	"github.com/stoex/go-lock/internal/certs"
 	"github.com/stoex/go-lock/internal/config"
-	pb "github.com/stoex/go-lock/internal/generated"
+	"github.com/stoex/go-lock/internal/gateway"
+	legacypb "github.com/stoex/go-lock/internal/generated"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
 	"github.com/stoex/go-lock/internal/logger"
+	"github.com/stoex/go-lock/internal/ratelimit"
 	"github.com/stoex/go-lock/internal/service"
+	"github.com/stoex/go-lock/pkg/redlock"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/testdata"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"gopkg.in/yaml.v2"
 	"log"
-	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -24,15 +38,266 @@ var (
 	// Version denotes the program version
 	Version string
 	// BuildDate denotes the build date
-	BuildDate     string
-	configuration = config.NewManager()
-	tls           = flag.Bool("tls", false, "Connection uses TLS if true, else plain TCP")
-	certFile      = flag.String("cert_file", "", "The TLS cert file")
-	keyFile       = flag.String("key_file", "", "The TLS key file")
-	port          = flag.Int("port", 10000, "The server port")
-	grpcServer    *grpc.Server
+	BuildDate   string
+	configFile  = flag.String("config", "", "The yaml config file, settings are overridden by the environment and flags")
+	tls         = flag.Bool("tls", false, "Connection uses TLS if true, else plain TCP")
+	certFile    = flag.String("cert_file", "", "The TLS cert file")
+	keyFile     = flag.String("key_file", "", "The TLS key file")
+	clientCA    = flag.String("client_ca_file", "", "The CA bundle client certificates are verified against, client certificates are not requested if empty")
+	requireCert = flag.Bool("require_client_cert", false, "Reject TLS connections without a valid client certificate")
+	bind        = flag.String("bind", "localhost", "The host the default listener binds to")
+	port        = flag.Int("port", 10000, "The server port")
+	listenSpecs = flag.String("listen", "", "Comma separated listeners replacing the default one, e.g. tcp://0.0.0.0:10000,tls://0.0.0.0:10443,https://0.0.0.0:8443,unix:///run/go-lock.sock?mode=0660")
+	policyFile  = flag.String("policy_file", "", "The authorization policy file, every caller is allowed all but admin operations if empty")
+	limitsFile  = flag.String("limits_file", "", "The rate limits and quotas file, no limits are enforced if empty")
+	logLevel    = flag.String("log_level", "info", "The minimum level of logged statements")
+	auditFile   = flag.String("audit_file", "", "The file audit records are appended to as JSON lines")
+	auditRedis  = flag.String("audit_redis", "", "The redis:// url of the instance audit records are streamed to")
+	auditStream = flag.String("audit_stream", audit.DefaultStream, "The redis stream key audit records are added to")
+	grpcServers []*grpc.Server
+	httpServer  *http.Server
 )
 
+// lockServiceNames are the service names reported by the health service
+var lockServiceNames = []string{"lock.v1.LockService", "lock.Lock"}
+
+// loadConfig loads the config file and overrides it with the flags given on the command line
+func loadConfig() (*config.Manager, error) {
+	return config.Load(*configFile, applyFlags)
+}
+
+// applyFlags overrides c with the flags given on the command line
+func applyFlags(c *config.Manager) error {
+	var listenErr error
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "bind":
+			c.Server.Address = *bind
+		case "listen":
+			c.Server.Listeners, listenErr = config.ParseListeners(strings.Split(*listenSpecs, ","))
+		case "tls":
+			c.TLS.Enabled = *tls
+		case "cert_file":
+			c.TLS.CertFile = *certFile
+		case "key_file":
+			c.TLS.KeyFile = *keyFile
+		case "client_ca_file":
+			c.TLS.ClientCAFile = *clientCA
+		case "require_client_cert":
+			c.TLS.RequireClientCert = *requireCert
+		case "port":
+			c.Server.Port = *port
+		case "policy_file":
+			c.Server.PolicyFile = *policyFile
+		case "limits_file":
+			c.Server.LimitsFile = *limitsFile
+		case "log_level":
+			c.Logging.Level = *logLevel
+		case "audit_file":
+			c.Audit.File = *auditFile
+		case "audit_redis":
+			c.Audit.Redis = *auditRedis
+		case "audit_stream":
+			c.Audit.Stream = *auditStream
+		}
+	})
+
+	if listenErr != nil {
+		return fmt.Errorf("invalid -listen :: %v", listenErr)
+	}
+
+	return nil
+}
+
+// loadPolicy returns the configured policy or the default one
+func loadPolicy(c *config.Manager) (*auth.Policy, error) {
+	if c.Server.PolicyFile == "" {
+		return auth.DefaultPolicy(), nil
+	}
+
+	return auth.LoadPolicy(c.Server.PolicyFile)
+}
+
+// loadLimits returns the configured limits, an empty config enforces none
+func loadLimits(c *config.Manager) (*ratelimit.Config, error) {
+	if c.Server.LimitsFile != "" {
+		return ratelimit.LoadConfig(c.Server.LimitsFile)
+	}
+	if c.Limits != nil {
+		data, err := yaml.Marshal(c.Limits)
+		if err != nil {
+			return nil, err
+		}
+		return ratelimit.ParseConfig(data)
+	}
+
+	return &ratelimit.Config{}, nil
+}
+
+// reload applies the settings which can change while serving: log level, policy, limits, audit sinks and the certificate.
+// Everything is loaded and validated before the first setting is applied, so nothing is changed if any of them is invalid.
+func reload(ctx context.Context, engine *auth.Engine, limiter *ratelimit.Limiter, certReloader *certs.Reloader, sinks *auditSinks) error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	policy, err := loadPolicy(c)
+	if err != nil {
+		return err
+	}
+
+	limits, err := loadLimits(c)
+	if err != nil {
+		return err
+	}
+
+	applyCert := func() {}
+	if certReloader != nil {
+		if applyCert, err = certReloader.Prepare(); err != nil {
+			return err
+		}
+	}
+
+	applyAudit, discardAudit, err := sinks.prepare(c.Audit)
+	if err != nil {
+		return err
+	}
+
+	// the level was validated by loadConfig
+	if err := logger.SetLevel(c.Logging.Level); err != nil {
+		discardAudit()
+		return err
+	}
+	applyCert()
+	applyAudit()
+	engine.SetPolicy(policy)
+	limiter.SetConfig(limits)
+
+	logger.Info(ctx, "-> reload ok")
+
+	return nil
+}
+
+// shutdown stops serving within the configured timeout. Health checks report NOT_SERVING and new acquires are
+// rejected at once, requests in flight may finish until the timeout passes and the servers are stopped forcefully.
+// Releasing the locks afterwards has a timeout of its own, so it also happens if the servers had to be stopped.
+func shutdown(c config.ShutdownConfig, svc *service.LockService, healthServer *health.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	logger.Info(ctx, fmt.Sprintf("<- shutdown :: timeout %s :: release locks %t", c.Timeout, c.ReleaseLocks))
+
+	healthServer.Shutdown()
+	svc.Drain()
+
+	stopped := make(chan struct{})
+
+	go func() {
+		var wg sync.WaitGroup
+		for _, s := range grpcServers {
+			wg.Add(1)
+			go func(s *grpc.Server) {
+				defer wg.Done()
+				s.GracefulStop()
+			}(s)
+		}
+		if httpServer != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = httpServer.Shutdown(ctx)
+			}()
+		}
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Warn(ctx, "-> shutdown :: timeout passed, stopping forcefully")
+		for _, s := range grpcServers {
+			s.Stop()
+		}
+		if httpServer != nil {
+			_ = httpServer.Close()
+		}
+	}
+
+	if c.ReleaseLocks {
+		releaseCtx, releaseCancel := context.WithTimeout(context.Background(), c.Timeout)
+		defer releaseCancel()
+
+		if err := svc.ReleaseAll(releaseCtx); err != nil {
+			logger.Error(ctx, fmt.Sprintf("-> shutdown fail :: %v", err))
+			return
+		}
+	}
+
+	logger.Info(ctx, "-> shutdown ok")
+}
+
+// newAuditSink returns the sink writing to the configured file and redis stream, records are discarded if neither is set
+func newAuditSink(c config.AuditConfig) (audit.Sink, error) {
+	var sinks []audit.Sink
+
+	if c.File != "" {
+		s, err := audit.NewFileSink(c.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if c.Redis != "" {
+		client, err := redlock.NewRedisClient(c.Redis)
+		if err != nil {
+			audit.MultiSink(sinks...).Close()
+			return nil, err
+		}
+		sinks = append(sinks, audit.ClosingSink(audit.NewRedisStreamSink(client, c.Stream, 0), client))
+	}
+
+	return audit.MultiSink(sinks...), nil
+}
+
+// auditSinks replaces the audit sink of the service when the audit settings change
+type auditSinks struct {
+	svc    *service.LockService
+	config config.AuditConfig
+	sink   audit.Sink
+}
+
+// prepare creates the sink of c unless c is in use already, the returned function installs it and closes the
+// previous sink. Discard closes the new sink if it is not installed.
+func (a *auditSinks) prepare(c config.AuditConfig) (apply func(), discard func(), err error) {
+	if c == a.config {
+		return func() {}, func() {}, nil
+	}
+
+	sink, err := newAuditSink(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	apply = func() {
+		a.svc.SetAuditSink(sink)
+		if err := a.sink.Close(); err != nil {
+			logger.Error(context.Background(), fmt.Sprintf("failed to close audit sink :: %v", err))
+		}
+		a.config, a.sink = c, sink
+	}
+
+	return apply, func() { sink.Close() }, nil
+}
+
+// Close closes the sink in use, no record is written afterwards
+func (a *auditSinks) Close() error {
+	return a.sink.Close()
+}
+
 func main() {
 	flag.Parse()
 
@@ -41,64 +306,180 @@ func main() {
 
 	logger.Info(ctx, fmt.Sprintf("go-lock :: version %s :: build date %s", Version, BuildDate))
 
+	configuration, err := loadConfig()
+
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	if err := logger.SetLevel(configuration.Logging.Level); err != nil {
+		log.Fatalf("failed to set log level: %v", err)
+	}
+
+	policy, err := loadPolicy(configuration)
+
+	if err != nil {
+		log.Fatalf("failed to load policy: %v", err)
+	}
+
+	limits, err := loadLimits(configuration)
+
+	if err != nil {
+		log.Fatalf("failed to load limits: %v", err)
+	}
+
+	engine := auth.NewEngine(policy)
+	limiter := ratelimit.NewLimiter(limits)
+
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer signal.Stop(interrupt)
 
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	auditSink, err := newAuditSink(configuration.Audit)
+
+	if err != nil {
+		log.Fatalf("failed to create audit sink: %v", err)
+	}
+
+	stores, err := service.NewStores(configuration.Redlock)
+
+	if err != nil {
+		log.Fatalf("failed to create lock backend: %v", err)
+	}
+
+	if err := service.CheckStores(ctx, configuration.Redlock, stores); err != nil {
+		log.Fatalf("failed to check lock backend: %v", err)
+	}
+
+	svc, err := service.NewLockServiceWithStores(stores)
+
+	if err != nil {
+		log.Fatalf("failed to create lock service: %v", err)
+	}
+
+	svc.Configure(configuration.Redlock)
+	svc.ConfigureTTL(configuration.TTL)
+	svc.SetAuditSink(auditSink)
+	sinks := &auditSinks{svc: svc, config: configuration.Audit, sink: auditSink}
+	svc.SetReleaseOnShutdown(configuration.Server.Shutdown.ReleaseLocks)
+
+	// the rate limiter is always installed so limits can be enabled by a reload
+	interceptors := []grpc.UnaryServerInterceptor{
+		auth.UnaryServerInterceptor(engine, auth.PeerResolver),
+		ratelimit.UnaryServerInterceptor(limiter),
+	}
+
+	// credentials are set per grpc server, so plain and TLS listeners are served by one server each
+	var plainServer, tlsServer *grpc.Server
+	var tlsConfig *cryptotls.Config
+	var certReloader *certs.Reloader
+
+	loadTLS := func() *cryptotls.Config {
+		if tlsConfig == nil {
+			c, reloader, err := certs.ServerConfig(configuration.TLS)
+			if err != nil {
+				log.Fatalf("failed to load TLS credentials: %v", err)
+			}
+			tlsConfig, certReloader = c, reloader
+		}
+		return tlsConfig
+	}
+
+	healthServer := health.NewServer()
+	for _, name := range lockServiceNames {
+		healthServer.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	legacyServer := service.NewLegacyServer(svc)
+
+	newServer := func(opts ...grpc.ServerOption) *grpc.Server {
+		s := grpc.NewServer(append(opts, grpc.ChainUnaryInterceptor(interceptors...))...)
+		pb.RegisterLockServiceServer(s, svc)
+		legacypb.RegisterLockServer(s, legacyServer)
+		healthpb.RegisterHealthServer(s, healthServer)
+		reflection.Register(s)
+		grpcServers = append(grpcServers, s)
+		return s
+	}
+
 	g, ctx := errgroup.WithContext(ctx)
 
-	g.Go(func() error {
-		lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", *port))
+	for _, l := range configuration.EffectiveListeners() {
+		lis, err := listen(l)
 
 		if err != nil {
-			log.Fatalf("failed to listen: %v", err)
+			log.Fatalf("failed to listen on %s: %v", l, err)
 		}
 
-		var opts []grpc.ServerOption
+		logger.Info(ctx, fmt.Sprintf("-> listening :: %s", l))
 
-		if *tls {
-			if *certFile == "" {
-				*certFile = testdata.Path("server1.pem")
-			}
-			if *keyFile == "" {
-				*keyFile = testdata.Path("server1.key")
+		if l.HTTP() {
+			if httpServer == nil {
+				httpServer = &http.Server{
+					Handler:           gateway.NewHandler(svc, interceptors...),
+					ReadHeaderTimeout: 10 * time.Second,
+				}
 			}
-			creds, err := credentials.NewServerTLSFromFile(*certFile, *keyFile)
-			if err != nil {
-				log.Fatalf("Failed to generate credentials %v", err)
+			if l.TLS {
+				lis = cryptotls.NewListener(lis, loadTLS())
 			}
-			opts = []grpc.ServerOption{grpc.Creds(creds)}
+			g.Go(func() error {
+				if err := httpServer.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+					return err
+				}
+				return nil
+			})
+			continue
 		}
 
-		grpcServer = grpc.NewServer(opts...)
-		svc, err := service.NewLockService(configuration.Redlock.Clients)
+		var srv *grpc.Server
 
-		if err != nil {
-			log.Fatalf("failed to create lock service: %v", err)
+		if l.TLS {
+			if tlsServer == nil {
+				tlsServer = newServer(grpc.Creds(credentials.NewTLS(loadTLS())))
+			}
+			srv = tlsServer
+		} else {
+			if plainServer == nil {
+				plainServer = newServer()
+			}
+			srv = plainServer
 		}
 
-		pb.RegisterLockServer(grpcServer, svc)
-		return grpcServer.Serve(lis)
-	})
+		g.Go(func() error {
+			return srv.Serve(lis)
+		})
+	}
 
-	select {
-	case <-interrupt:
-		logger.Info(ctx, "received shutdown signal")
-		break
-	case <-ctx.Done():
-		break
+loop:
+	for {
+		select {
+		case <-hangup:
+			logger.Info(ctx, "<- reload :: received hangup signal")
+			if err := reload(ctx, engine, limiter, certReloader, sinks); err != nil {
+				logger.Error(ctx, fmt.Sprintf("-> reload fail :: keeping previous settings :: %v", err))
+			}
+		case <-interrupt:
+			logger.Info(ctx, "received shutdown signal")
+			break loop
+		case <-ctx.Done():
+			break loop
+		}
 	}
 
 	cancel()
 
-	_, shutdownCancel := context.WithTimeout(ctx, 5*time.Second)
-	defer shutdownCancel()
+	shutdown(configuration.Server.Shutdown, svc, healthServer)
 
-	if grpcServer != nil {
-		grpcServer.GracefulStop()
+	// releasing the locks on shutdown was the last action recorded
+	if err := sinks.Close(); err != nil {
+		logger.Error(ctx, fmt.Sprintf("failed to close audit sink :: %v", err))
 	}
 
-	err := g.Wait()
+	err = g.Wait()
 
 	if err != nil {
 		logger.Error(ctx, fmt.Sprintf("server returning an error: %v", err.Error()))
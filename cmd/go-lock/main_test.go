@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/stoex/go-lock/internal/audit"
+	"github.com/stoex/go-lock/internal/config"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/internal/ratelimit"
+	"github.com/stoex/go-lock/internal/service"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/test/bufconn"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingServer keeps CheckLock requests in flight until release is closed
+type blockingServer struct {
+	pb.LockServiceServer
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingServer) CheckLock(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	close(s.entered)
+	<-s.release
+	return s.LockServiceServer.CheckLock(ctx, req)
+}
+
+func TestShutdown_ReleaseAfterTimeout(t *testing.T) {
+	ctx := context.Background()
+	store := redlock.NewMemoryStore()
+
+	svc, err := service.NewLockServiceWithStores([]redlock.Store{store})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1})
+	svc.SetReleaseOnShutdown(true)
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "invoice/42", LockId: "worker-1", Ttl: 30})
+	if err != nil {
+		t.Fatalf("could not acquire lock: %s", err.Error())
+	}
+
+	blocking := &blockingServer{LockServiceServer: svc, entered: make(chan struct{}), release: make(chan struct{})}
+	defer close(blocking.release)
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterLockServiceServer(s, blocking)
+	go s.Serve(lis)
+
+	grpcServers = []*grpc.Server{s}
+	defer func() { grpcServers = nil }()
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	defer conn.Close()
+
+	go pb.NewLockServiceClient(conn).CheckLock(ctx, &pb.LockRequest{ResourceId: "invoice/42"})
+	<-blocking.entered
+
+	shutdown(config.ShutdownConfig{Timeout: 100 * time.Millisecond, ReleaseLocks: true}, svc, health.NewServer())
+
+	_, _, ok, _ := store.Get("invoice/42")
+	assert.False(t, ok, "locks should be released although the graceful stop timed out")
+}
+
+func TestLoadLimits(t *testing.T) {
+	c := &config.Manager{}
+	assert.NoError(t, yaml.Unmarshal([]byte("per_caller: {rate: 10, burst: 20}\n"), &c.Limits))
+
+	limits, err := loadLimits(c)
+	if err != nil {
+		t.Fatalf("could not load limits: %s", err.Error())
+	}
+	assert.Equal(t, 20, limits.PerCaller.Burst)
+
+	assert.NoError(t, yaml.Unmarshal([]byte("per_caller: {rate: 1}\n"), &c.Limits))
+	_, err = loadLimits(c)
+	assert.Error(t, err, "inline limits should be validated")
+
+	assert.NoError(t, yaml.Unmarshal([]byte("per_caler: {rate: 1, burst: 1}\n"), &c.Limits))
+	_, err = loadLimits(c)
+	assert.Error(t, err, "unknown inline limits fields should be reported")
+
+	limits, err = loadLimits(&config.Manager{})
+	if err != nil {
+		t.Fatalf("could not load limits: %s", err.Error())
+	}
+	assert.Equal(t, &ratelimit.Config{}, limits, "no limits should enforce none")
+}
+
+func TestAuditSinks_Prepare(t *testing.T) {
+	ctx := context.Background()
+
+	svc, err := service.NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1})
+
+	initial := config.AuditConfig{Stream: audit.DefaultStream}
+	sinks := &auditSinks{svc: svc, config: initial, sink: audit.NopSink()}
+	svc.SetAuditSink(sinks.sink)
+
+	apply, _, err := sinks.prepare(initial)
+	assert.NoError(t, err)
+	apply()
+	assert.Equal(t, audit.NopSink(), sinks.sink, "unchanged settings should keep the sink")
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	_, discard, err := sinks.prepare(config.AuditConfig{File: path, Stream: audit.DefaultStream})
+	assert.NoError(t, err)
+	discard()
+
+	_, _, err = sinks.prepare(config.AuditConfig{Redis: "http://audit:6379", Stream: audit.DefaultStream})
+	assert.Error(t, err, "invalid sinks should be reported")
+
+	apply, _, err = sinks.prepare(config.AuditConfig{File: path, Stream: audit.DefaultStream})
+	assert.NoError(t, err)
+	apply()
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "invoice/42", LockId: "worker-1", Ttl: 30})
+	assert.NoError(t, err)
+
+	apply, _, err = sinks.prepare(initial)
+	assert.NoError(t, err)
+	apply()
+
+	_, err = svc.DeleteLock(ctx, &pb.LockRequest{ResourceId: "invoice/42", LockId: "worker-1"})
+	assert.NoError(t, err)
+	assert.NoError(t, sinks.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(data, []byte("\n")), "records should only be written to the sink in use")
+}
+
+// createFile creates an empty file and returns its permissions
+func createFile(t *testing.T, path string) os.FileMode {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("could not create file: %s", err.Error())
+	}
+	f.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("could not stat file: %s", err.Error())
+	}
+	return fi.Mode().Perm()
+}
+
+func TestListen_UnixMode(t *testing.T) {
+	dir := t.TempDir()
+	before := createFile(t, filepath.Join(dir, "before"))
+
+	for mode, want := range map[string]os.FileMode{"0600": 0600, "0660": 0660} {
+		path := filepath.Join(dir, mode+".sock")
+
+		lis, err := listen(config.ListenerConfig{Network: "unix", Address: path, Mode: mode})
+		if err != nil {
+			t.Fatalf("could not listen on %s: %s", path, err.Error())
+		}
+		defer lis.Close()
+
+		fi, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, want, fi.Mode().Perm(), "the socket should have the configured mode")
+	}
+
+	assert.Equal(t, before, createFile(t, filepath.Join(dir, "after")), "the umask of the process should be restored")
+}
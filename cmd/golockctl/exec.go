@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stoex/go-lock/pkg/client"
+	"google.golang.org/grpc"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// terminateGrace is the time a command may take to exit after SIGTERM before it is killed
+const terminateGrace = 10 * time.Second
+
+// runExec runs a command while holding a lock and returns the exit code of golockctl, which is the one of the
+// command unless the lock could not be acquired or was lost
+func runExec(ctx context.Context, conn *grpc.ClientConn, args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	lockID := fs.String("lock_id", "", "The lock id (owner token), a random one is generated if empty")
+	ttl := fs.Duration("ttl", client.DefaultTTL, "The ttl of the lock, it is refreshed every third of it")
+	retries := fs.Int("retries", client.DefaultRetries, "How often acquiring the lock is retried")
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "golockctl: exec expects a resource and a command")
+		return 2
+	}
+
+	resource, command := fs.Arg(0), fs.Args()[1:]
+
+	acquireCtx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	h, err := client.New(conn).Acquire(acquireCtx, resource, client.WithLockID(*lockID), client.WithTTL(*ttl), client.WithRetries(*retries))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "golockctl: failed to acquire lock :: resource %s :: %v\n", resource, err)
+		return 1
+	}
+
+	defer func() {
+		releaseCtx, cancel := context.WithTimeout(ctx, *timeout)
+		defer cancel()
+		if err := h.Release(releaseCtx); err != nil {
+			fmt.Fprintf(os.Stderr, "golockctl: failed to release lock :: resource %s :: %v\n", resource, err)
+		}
+	}()
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "GOLOCK_RESOURCE="+resource, "GOLOCK_LOCK_ID="+h.LockID())
+
+	// signals are forwarded to the command, the lock is released once it exited. They are caught before
+	// the command starts so golockctl is not terminated while the command keeps running.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "golockctl: failed to start command :: %v\n", err)
+		return 1
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	for {
+		select {
+		case sig := <-signals:
+			_ = cmd.Process.Signal(sig)
+		case <-h.Lost():
+			fmt.Fprintf(os.Stderr, "golockctl: lock lost :: resource %s :: terminating command\n", resource)
+			terminate(cmd, done)
+			return 1
+		case err := <-done:
+			return exitCode(err)
+		}
+	}
+}
+
+// terminate sends SIGTERM to the command and kills it if it did not exit within terminateGrace
+func terminate(cmd *exec.Cmd, done chan error) {
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(terminateGrace):
+		_ = cmd.Process.Kill()
+		<-done
+	}
+}
+
+// exitCode returns the exit code of a command which exited with err
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+
+	return 1
+}
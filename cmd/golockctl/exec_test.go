@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"github.com/stoex/go-lock/internal/config"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/internal/service"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const testResourceID = "invoice/42"
+
+// newTestConn starts an in-process lock server backed by a memory store
+func newTestConn(t *testing.T, opts ...grpc.ServerOption) (*grpc.ClientConn, *service.LockService) {
+	svc, err := service.NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1})
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer(opts...)
+	pb.RegisterLockServiceServer(s, svc)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, svc
+}
+
+// startExec runs exec with a shell script which touches a file once its traps are set up and then waits
+// until it is signalled. It returns the exit code channel after the script is ready.
+func startExec(t *testing.T, conn *grpc.ClientConn, flags []string, script string) <-chan int {
+	dir, err := ioutil.TempDir("", "golockctl")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	ready := filepath.Join(dir, "ready")
+
+	args := append(flags, testResourceID, "sh", "-c", script+`; touch "$0"; while :; do sleep 0.01; done`, ready)
+
+	code := make(chan int, 1)
+	go func() {
+		code <- runExec(context.Background(), conn, args)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(ready); err == nil {
+			return code
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("command did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitExit returns the exit code sent on code or fails the test after a timeout
+func waitExit(t *testing.T, code <-chan int) int {
+	select {
+	case c := <-code:
+		return c
+	case <-time.After(10 * time.Second):
+		t.Fatalf("exec did not return")
+		return 0
+	}
+}
+
+func TestRunExec_ExitCode(t *testing.T) {
+	conn, svc := newTestConn(t)
+	ctx := context.Background()
+
+	assert.Equal(t, 0, runExec(ctx, conn, []string{testResourceID, "true"}))
+	assert.Equal(t, 3, runExec(ctx, conn, []string{testResourceID, "sh", "-c", "exit 3"}), "the exit code of the command should be kept")
+	assert.Equal(t, 0, runExec(ctx, conn, []string{testResourceID, "sh", "-c", `test "$GOLOCK_RESOURCE" = invoice/42 && test -n "$GOLOCK_LOCK_ID"`}),
+		"the lock should be passed in the environment")
+	assert.Equal(t, 1, runExec(ctx, conn, []string{testResourceID, "golockctl-does-not-exist"}), "commands which do not start should fail")
+	assert.Equal(t, 2, runExec(ctx, conn, []string{testResourceID}), "a missing command should be a usage error")
+
+	_, err := svc.CheckLock(ctx, &pb.LockRequest{ResourceId: testResourceID})
+	assert.Error(t, err, "the lock should be released after every command")
+}
+
+func TestRunExec_LockHeld(t *testing.T) {
+	conn, svc := newTestConn(t)
+	ctx := context.Background()
+
+	_, err := svc.GetLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: "worker-1", Ttl: 30})
+	if err != nil {
+		t.Fatalf("could not acquire lock: %s", err.Error())
+	}
+
+	assert.Equal(t, 1, runExec(ctx, conn, []string{"-retries", "0", testResourceID, "true"}), "a held lock should fail before running the command")
+}
+
+func TestRunExec_ForwardSignal(t *testing.T) {
+	conn, svc := newTestConn(t)
+
+	code := startExec(t, conn, nil, `trap "exit 42" TERM`)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("could not signal: %s", err.Error())
+	}
+
+	assert.Equal(t, 42, waitExit(t, code), "the signal should be forwarded to the command")
+
+	_, err := svc.CheckLock(context.Background(), &pb.LockRequest{ResourceId: testResourceID})
+	assert.Error(t, err, "the lock should be released once the command exited")
+}
+
+func TestRunExec_LockLost(t *testing.T) {
+	conn, svc := newTestConn(t)
+	ctx := context.Background()
+
+	code := startExec(t, conn, []string{"-ttl", "1s"}, `trap "exit 0" TERM`)
+
+	_, err := svc.ForceRelease(ctx, &pb.LockRequest{ResourceId: testResourceID})
+	if err != nil {
+		t.Fatalf("could not release lock: %s", err.Error())
+	}
+
+	assert.Equal(t, 1, waitExit(t, code), "a lost lock should fail although the command exited cleanly")
+}
+
+func TestRunExec_RefreshMetadata(t *testing.T) {
+	var mu sync.Mutex
+	var refreshes []string
+	record := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasSuffix(info.FullMethod, "/RefreshLock") {
+			md, _ := metadata.FromIncomingContext(ctx)
+			mu.Lock()
+			refreshes = append(refreshes, strings.Join(md.Get("correlation-id"), ","))
+			mu.Unlock()
+		}
+		return handler(ctx, req)
+	}
+	conn, _ := newTestConn(t, grpc.UnaryInterceptor(record))
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("correlation-id", "deploy-42"))
+
+	assert.Equal(t, 0, runExec(ctx, conn, []string{"-ttl", "1s", testResourceID, "sleep", "1"}))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.NotEmpty(t, refreshes, "the lock should be refreshed while the command runs") {
+		for _, id := range refreshes {
+			assert.Equal(t, "deploy-42", id, "refreshes should carry the metadata of the command line")
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// Version denotes the program version
+	Version string
+	// BuildDate denotes the build date
+	BuildDate  string
+	addr       = flag.String("addr", "localhost:10000", "The server address, host:port or unix:///path")
+	useTLS     = flag.Bool("tls", false, "Connect with TLS")
+	caFile     = flag.String("ca_file", "", "The CA bundle the server certificate is verified against, defaults to the system roots")
+	certFile   = flag.String("cert_file", "", "The client certificate identifying the caller to the authorization policy")
+	keyFile    = flag.String("key_file", "", "The key of the client certificate")
+	serverName = flag.String("server_name", "", "The host name verified in the server certificate, defaults to the host of -addr")
+	timeout    = flag.Duration("timeout", 10*time.Second, "The time a single request may take")
+	output     = flag.String("output", "table", "The output format, table or json")
+	mdPairs    = flag.String("metadata", "", "Comma separated key=value pairs sent as metadata with every request, e.g. correlation-id=deploy-42")
+)
+
+const usage = `Usage: golockctl [flags] <command> [arguments]
+
+Commands:
+  get [-lock_id id] [-ttl 30s] [-takeover] <resource>   acquire a lock, a random lock id is generated if none is given
+      [-no_expiry]                                     acquire a lock which never expires, if the server allows it
+      [-retry strategy -retry_delay d -retry_max_delay d -retry_deadline d]
+                                                       retry as given instead of the server default
+      [-try_once] [-max_retries n] [-max_wait d]         fail fast instead of running all retries of the server
+      [-max_lease_age d]                               cap the time the lock can be kept by refreshing
+  refresh -lock_id id [-ttl 30s] <resource>            refresh a lock
+      [-mode reset|extend|to_max_lease_age]            reset the ttl, add -ttl to it or keep the lock until its max lease age
+  release -lock_id id <resource>                       release a lock
+  release -force <resource>                            release a lock regardless of its owner
+  check <resource>                                     show the owner and remaining ttl of a lock
+  list [prefix]                                        list the locks on resources starting with prefix
+  exec [-lock_id id] [-ttl 30s] [-retries n] <resource> <command> [args...]
+                                                       run command while holding the lock, it is refreshed until
+                                                       the command exits and released afterwards. The command is
+                                                       terminated if the lock is lost.
+
+Flags:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *output != "table" && *output != "json" {
+		fatal(fmt.Errorf("invalid -output %q, expected table or json", *output))
+	}
+
+	conn, err := dial()
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, err := outgoingContext(context.Background())
+	if err != nil {
+		fatal(err)
+	}
+
+	lock := pb.NewLockServiceClient(conn)
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	switch command {
+	case "get":
+		err = runGet(ctx, lock, args)
+	case "refresh":
+		err = runRefresh(ctx, lock, args)
+	case "release":
+		err = runRelease(ctx, lock, args)
+	case "check":
+		err = runCheck(ctx, lock, args)
+	case "list":
+		err = runList(ctx, lock, args)
+	case "exec":
+		os.Exit(runExec(ctx, conn, args))
+	case "version":
+		fmt.Printf("golockctl :: version %s :: build date %s\n", Version, BuildDate)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// fatal prints err, showing the grpc code of status errors, and exits
+func fatal(err error) {
+	if st, ok := status.FromError(err); ok {
+		fmt.Fprintf(os.Stderr, "golockctl: %s: %s\n", st.Code(), st.Message())
+	} else {
+		fmt.Fprintf(os.Stderr, "golockctl: %v\n", err)
+	}
+	os.Exit(1)
+}
+
+// dial connects to the server given by -addr with the TLS settings of the flags
+func dial() (*grpc.ClientConn, error) {
+	if !*useTLS {
+		return grpc.Dial(*addr, grpc.WithInsecure())
+	}
+
+	c := &tls.Config{ServerName: *serverName}
+
+	if *caFile != "" {
+		pem, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file :: %v", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to read ca file :: no certificates in %s", *caFile)
+		}
+	}
+
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate :: %v", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return grpc.Dial(*addr, grpc.WithTransportCredentials(credentials.NewTLS(c)))
+}
+
+// outgoingContext returns ctx carrying the -metadata pairs
+func outgoingContext(ctx context.Context) (context.Context, error) {
+	if *mdPairs == "" {
+		return ctx, nil
+	}
+
+	md := metadata.MD{}
+
+	for _, pair := range strings.Split(*mdPairs, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid -metadata pair %q, expected key=value", pair)
+		}
+		md.Append(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
+
+// resourceArg returns the single resource argument of a command
+func resourceArg(fs *flag.FlagSet) (string, error) {
+	if fs.NArg() != 1 || fs.Arg(0) == "" {
+		return "", fmt.Errorf("%s expects exactly one resource", fs.Name())
+	}
+
+	return fs.Arg(0), nil
+}
+
+// ttlSeconds returns ttl rounded up to full seconds as expected by the lock service
+func ttlSeconds(ttl time.Duration) uint32 {
+	return uint32((ttl + time.Second - 1) / time.Second)
+}
+
+func runGet(ctx context.Context, lock pb.LockServiceClient, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	lockID := fs.String("lock_id", "", "The lock id (owner token), a random one is generated if empty")
+	ttl := fs.Duration("ttl", 30*time.Second, "The ttl of the lock, rounded up to full seconds")
+	takeover := fs.Bool("takeover", false, "Transfer the lock to lock_id regardless of its owner (admin only)")
+	noExpiry := fs.Bool("no_expiry", false, "Acquire a lock which never expires instead of using -ttl (admin only)")
+	retry := fs.String("retry", "", "The retry strategy, fixed, exponential or decorrelated_jitter, the server default if empty")
+	retryDelay := fs.Duration("retry_delay", 0, "The base delay of the retry strategy")
+	retryMaxDelay := fs.Duration("retry_max_delay", 0, "The max delay of a single retry")
+	retryDeadline := fs.Duration("retry_deadline", 0, "The time after which retrying stops")
+	tryOnce := fs.Bool("try_once", false, "Attempt to acquire the lock once without retrying")
+	maxRetries := fs.Uint("max_retries", 0, "Limit the retries after the first attempt, 0 keeps the server setting")
+	maxWait := fs.Duration("max_wait", 0, "Stop retrying once this passed since the first attempt")
+	maxLeaseAge := fs.Duration("max_lease_age", 0, "Cap the time the lock can be kept by refreshing, rounded up to full seconds")
+	_ = fs.Parse(args)
+
+	resource, err := resourceArg(fs)
+	if err != nil {
+		return err
+	}
+
+	if *lockID == "" {
+		if *lockID, err = redlock.NewToken(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	req := &pb.LockRequest{
+		ResourceId:  resource,
+		LockId:      *lockID,
+		Ttl:         ttlSeconds(*ttl),
+		TryOnce:     *tryOnce,
+		MaxRetries:  uint32(*maxRetries),
+		MaxWaitMs:   uint32(*maxWait / time.Millisecond),
+		NoExpiry:    *noExpiry,
+		MaxLeaseAge: ttlSeconds(*maxLeaseAge),
+	}
+
+	if *retry != "" || *retryDeadline > 0 {
+		strategy, ok := pb.RetryPolicy_Strategy_value[strings.ToUpper(*retry)]
+		if !ok && *retry != "" {
+			return fmt.Errorf("invalid -retry %q, expected fixed, exponential or decorrelated_jitter", *retry)
+		}
+		req.Retry = &pb.RetryPolicy{
+			Strategy:    pb.RetryPolicy_Strategy(strategy),
+			BaseDelayMs: uint32(*retryDelay / time.Millisecond),
+			MaxDelayMs:  uint32(*retryMaxDelay / time.Millisecond),
+			DeadlineMs:  uint32(*retryDeadline / time.Millisecond),
+		}
+	}
+
+	var res *pb.LockResponse
+	if *takeover {
+		res, err = lock.TakeOver(ctx, req)
+	} else {
+		res, err = lock.GetLock(ctx, req)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return printLock(res)
+}
+
+func runRefresh(ctx context.Context, lock pb.LockServiceClient, args []string) error {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	lockID := fs.String("lock_id", "", "The lock id (owner token) the lock was acquired with")
+	ttl := fs.Duration("ttl", 30*time.Second, "The new ttl of the lock, or the time added with -mode extend, rounded up to full seconds")
+	mode := fs.String("mode", "reset", "The refresh mode, reset, extend or to_max_lease_age")
+	_ = fs.Parse(args)
+
+	resource, err := resourceArg(fs)
+	if err != nil {
+		return err
+	}
+
+	if *lockID == "" {
+		return fmt.Errorf("refresh requires -lock_id")
+	}
+
+	refreshMode, ok := pb.LockRequest_RefreshMode_value[strings.ToUpper(*mode)]
+	if !ok {
+		return fmt.Errorf("invalid -mode %q, expected reset, extend or to_max_lease_age", *mode)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	res, err := lock.RefreshLock(ctx, &pb.LockRequest{
+		ResourceId:  resource,
+		LockId:      *lockID,
+		Ttl:         ttlSeconds(*ttl),
+		RefreshMode: pb.LockRequest_RefreshMode(refreshMode),
+	})
+	if err != nil {
+		return err
+	}
+
+	return printLock(res)
+}
+
+func runRelease(ctx context.Context, lock pb.LockServiceClient, args []string) error {
+	fs := flag.NewFlagSet("release", flag.ExitOnError)
+	lockID := fs.String("lock_id", "", "The lock id (owner token) the lock was acquired with")
+	force := fs.Bool("force", false, "Release the lock regardless of its owner (admin only)")
+	_ = fs.Parse(args)
+
+	resource, err := resourceArg(fs)
+	if err != nil {
+		return err
+	}
+
+	if *lockID == "" && !*force {
+		return fmt.Errorf("release requires -lock_id or -force")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	req := &pb.LockRequest{ResourceId: resource, LockId: *lockID}
+
+	if *force {
+		_, err = lock.ForceRelease(ctx, req)
+	} else {
+		_, err = lock.DeleteLock(ctx, req)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return printLock(&pb.LockResponse{Status: pb.ResponseStatus_OK, ResourceId: resource, LockId: *lockID})
+}
+
+func runCheck(ctx context.Context, lock pb.LockServiceClient, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	resource, err := resourceArg(fs)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	res, err := lock.CheckLock(ctx, &pb.LockRequest{ResourceId: resource})
+	if err != nil {
+		return err
+	}
+
+	return printLock(res)
+}
+
+func runList(ctx context.Context, lock pb.LockServiceClient, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return fmt.Errorf("list expects at most one prefix")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	res, err := lock.ListLocks(ctx, &pb.ListRequest{Prefix: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+
+	return printLocks(res.Locks)
+}
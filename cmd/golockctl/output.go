@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"os"
+	"text/tabwriter"
+)
+
+// lockView is a lock as printed by golockctl, the json names match the HTTP gateway
+type lockView struct {
+	Resource string `json:"resource_id"`
+	LockID   string `json:"lock_id"`
+	TTL      uint32 `json:"ttl"`
+}
+
+func newLockView(res *pb.LockResponse) lockView {
+	return lockView{Resource: res.ResourceId, LockID: res.LockId, TTL: res.Ttl}
+}
+
+// printLock prints a single lock in the -output format
+func printLock(res *pb.LockResponse) error {
+	if *output == "json" {
+		return printJSON(newLockView(res))
+	}
+
+	return printTable([]lockView{newLockView(res)})
+}
+
+// printLocks prints a list of locks in the -output format
+func printLocks(locks []*pb.LockResponse) error {
+	views := make([]lockView, len(locks))
+	for i, l := range locks {
+		views[i] = newLockView(l)
+	}
+
+	if *output == "json" {
+		return printJSON(views)
+	}
+
+	return printTable(views)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+func printTable(views []lockView) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "RESOURCE\tLOCK ID\tTTL")
+	for _, v := range views {
+		fmt.Fprintf(w, "%s\t%s\t%ds\n", v.Resource, v.LockID, v.TTL)
+	}
+
+	return w.Flush()
+}
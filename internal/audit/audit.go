@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"context"
+	"github.com/stoex/go-lock/internal/auth"
+	"github.com/stoex/go-lock/internal/logger"
+	"io"
+	"time"
+)
+
+// Actions recorded for lock state transitions
+const (
+	ActionAcquire      = "acquire"
+	ActionRefresh      = "refresh"
+	ActionRelease      = "release"
+	ActionForceRelease = "force-release"
+	ActionTakeOver     = "takeover"
+)
+
+// Outcomes of a recorded action
+const (
+	OutcomeOK   = "ok"
+	OutcomeFail = "fail"
+)
+
+// Record describes a single lock state transition.
+// TTL is the granted ttl in seconds, the requested one if the action failed.
+type Record struct {
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	Resource      string    `json:"resource"`
+	LockID        string    `json:"lock_id,omitempty"`
+	TTL           uint32    `json:"ttl,omitempty"`
+	Principal     string    `json:"principal"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Outcome       string    `json:"outcome"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Sink persists audit records. Auditing is best-effort, a failed write is logged by the caller
+// but does not fail the audited action.
+type Sink interface {
+	Write(r *Record) error
+	Close() error
+}
+
+// NewRecord returns a record for the given action, filling in time, caller identity,
+// correlation id and outcome from ctx and err
+func NewRecord(ctx context.Context, action string, resource string, lockID string, ttl uint32, err error) *Record {
+	r := &Record{
+		Time:          time.Now().UTC(),
+		Action:        action,
+		Resource:      resource,
+		LockID:        lockID,
+		TTL:           ttl,
+		Principal:     auth.FromContext(ctx),
+		CorrelationID: logger.CorrelationID(ctx),
+		Outcome:       OutcomeOK,
+	}
+
+	if err != nil {
+		r.Outcome = OutcomeFail
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+type nopSink struct{}
+
+// NopSink returns a sink discarding every record
+func NopSink() Sink {
+	return nopSink{}
+}
+
+func (nopSink) Write(*Record) error {
+	return nil
+}
+
+func (nopSink) Close() error {
+	return nil
+}
+
+type multiSink []Sink
+
+// MultiSink returns a sink writing every record to all given sinks
+func MultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) Write(r *Record) error {
+	var err error
+	for _, s := range m {
+		if e := s.Write(r); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (m multiSink) Close() error {
+	var err error
+	for _, s := range m {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type closingSink struct {
+	Sink
+	closer io.Closer
+}
+
+// ClosingSink returns a sink writing to s which also closes c once closed, e.g. the redis client of a RedisStreamSink
+func ClosingSink(s Sink, c io.Closer) Sink {
+	return closingSink{Sink: s, closer: c}
+}
+
+func (s closingSink) Close() error {
+	err := s.Sink.Close()
+	if e := s.closer.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/alicebob/miniredis"
+	"github.com/elliotchance/redismock"
+	"github.com/go-redis/redis"
+	"github.com/stoex/go-lock/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestContext() context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("correlation-id", "abc-123"))
+	return auth.NewContext(ctx, "billing")
+}
+
+func TestNewRecord(t *testing.T) {
+	r := NewRecord(newTestContext(), ActionAcquire, "invoice/1", "id", 10, nil)
+
+	assert.Equal(t, "billing", r.Principal)
+	assert.Equal(t, "abc-123", r.CorrelationID)
+	assert.Equal(t, OutcomeOK, r.Outcome)
+	assert.Empty(t, r.Error)
+
+	r = NewRecord(context.Background(), ActionRelease, "invoice/1", "id", 0, errors.New("boom"))
+
+	assert.Equal(t, auth.Anonymous, r.Principal)
+	assert.Equal(t, OutcomeFail, r.Outcome)
+	assert.Equal(t, "boom", r.Error)
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("could not create file sink: %s", err.Error())
+	}
+
+	ctx := newTestContext()
+	assert.NoError(t, sink.Write(NewRecord(ctx, ActionAcquire, "invoice/1", "id", 10, nil)))
+	assert.NoError(t, sink.Write(NewRecord(ctx, ActionRelease, "invoice/1", "id", 0, nil)))
+	assert.NoError(t, sink.Close())
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open audit log: %s", err.Error())
+	}
+	defer f.Close()
+
+	var actions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &r), "every line should hold a json record")
+		assert.Equal(t, "abc-123", r.CorrelationID)
+		actions = append(actions, r.Action)
+	}
+
+	assert.Equal(t, []string{ActionAcquire, ActionRelease}, actions)
+}
+
+func TestRedisStreamSink(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("could not start miniredis: %s", err.Error())
+	}
+	defer mr.Close()
+
+	client := redismock.NewNiceMock(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	client.On("XAdd", mock.MatchedBy(func(a *redis.XAddArgs) bool {
+		return a.Stream == DefaultStream && a.Values["action"] == ActionForceRelease && a.Values["resource"] == "invoice/1"
+	})).Return(redis.NewStringResult("1-0", nil))
+
+	sink := NewRedisStreamSink(client, "", 0)
+	err = sink.Write(NewRecord(newTestContext(), ActionForceRelease, "invoice/1", "", 0, nil))
+
+	assert.NoError(t, err)
+	client.AssertExpectations(t)
+}
+
+func TestClosingSink(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("could not start miniredis: %s", err.Error())
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	sink := ClosingSink(NewRedisStreamSink(client, "", 0), client)
+
+	assert.NoError(t, client.Ping().Err())
+	assert.NoError(t, sink.Close())
+	assert.Error(t, client.Ping().Err(), "the redis client should be closed with the sink")
+}
@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileSink appends records as JSON lines to a file
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewFileSink opens (or creates) the file at path for appending records
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Write appends a record and syncs the file so records survive a crash
+func (s *FileSink) Write(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enc.Encode(r); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+// Close closes the underlying file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/go-redis/redis"
+)
+
+// DefaultStream is the redis stream key records are added to
+const DefaultStream = "go-lock:audit"
+
+// RedisStreamSink adds records to a redis stream
+type RedisStreamSink struct {
+	client redis.Cmdable
+	stream string
+	maxLen int64
+}
+
+// NewRedisStreamSink returns a sink adding records to stream.
+// If maxLen is greater than 0 the stream is approximately trimmed to that length.
+func NewRedisStreamSink(client redis.Cmdable, stream string, maxLen int64) *RedisStreamSink {
+	if stream == "" {
+		stream = DefaultStream
+	}
+
+	return &RedisStreamSink{client: client, stream: stream, maxLen: maxLen}
+}
+
+// Write adds a record as a single entry holding the json encoded record
+func (s *RedisStreamSink) Write(r *Record) error {
+	data, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	return s.client.XAdd(&redis.XAddArgs{
+		Stream:       s.stream,
+		MaxLenApprox: s.maxLen,
+		Values: map[string]interface{}{
+			"action":   r.Action,
+			"resource": r.Resource,
+			"record":   string(data),
+		},
+	}).Err()
+}
+
+// Close is a no-op, the redis client is owned by the caller
+func (s *RedisStreamSink) Close() error {
+	return nil
+}
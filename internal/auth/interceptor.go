@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/stoex/go-lock/internal/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// methodOperations maps the full grpc method names of the lock services to policy operations
+var methodOperations = map[string]Operation{
+	"/lock.v1.LockService/GetLock":      OpGet,
+	"/lock.v1.LockService/RefreshLock":  OpRefresh,
+	"/lock.v1.LockService/DeleteLock":   OpDelete,
+	"/lock.v1.LockService/CheckLock":    OpCheck,
+	"/lock.v1.LockService/ForceRelease": OpForceRelease,
+	"/lock.v1.LockService/TakeOver":     OpTakeOver,
+	"/lock.v1.LockService/ListLocks":    OpList,
+
+	// the unversioned API served alongside lock.v1
+	"/lock.Lock/GetLock":      OpGet,
+	"/lock.Lock/RefreshLock":  OpRefresh,
+	"/lock.Lock/DeleteLock":   OpDelete,
+	"/lock.Lock/CheckLock":    OpCheck,
+	"/lock.Lock/ForceRelease": OpForceRelease,
+	"/lock.Lock/TakeOver":     OpTakeOver,
+	"/lock.Lock/ListLocks":    OpList,
+}
+
+type resourceRequest interface {
+	GetResourceId() string
+}
+
+// prefixRequest is a request on all resources starting with a prefix, the policy is checked against the prefix.
+// The handler has to check every resource it returns with Allowed, rules below the prefix may deny some of them.
+type prefixRequest interface {
+	GetPrefix() string
+}
+
+// noExpiryRequest asks for a lock which never expires
+type noExpiryRequest interface {
+	GetNoExpiry() bool
+}
+
+type engineKey struct{}
+
+// Allowed reports whether the principal of ctx may perform op on resource according to the engine
+// UnaryServerInterceptor checked the request with. Requests which were not checked are allowed.
+func Allowed(ctx context.Context, resource string, op Operation) bool {
+	engine, ok := ctx.Value(engineKey{}).(*Engine)
+	if !ok {
+		return true
+	}
+
+	return engine.Allowed(FromContext(ctx), resource, op)
+}
+
+// UnaryServerInterceptor identifies the caller with resolve, stores the principal in the
+// request context and rejects calls the policy engine does not allow.
+// Methods unknown to the policy are passed through unchecked.
+func UnaryServerInterceptor(engine *Engine, resolve Resolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		principal := resolve(ctx)
+		ctx = NewContext(ctx, principal)
+
+		op, ok := methodOperations[info.FullMethod]
+
+		if !ok || engine == nil {
+			return handler(ctx, req)
+		}
+
+		ctx = context.WithValue(ctx, engineKey{}, engine)
+
+		resource := ""
+		switch r := req.(type) {
+		case resourceRequest:
+			resource = r.GetResourceId()
+		case prefixRequest:
+			resource = r.GetPrefix()
+		}
+
+		ops := []Operation{op}
+		if r, ok := req.(noExpiryRequest); ok && r.GetNoExpiry() {
+			ops = append(ops, OpNoExpiry)
+		}
+
+		for _, op := range ops {
+			if !engine.Allowed(principal, resource, op) {
+				logger.Warn(ctx, fmt.Sprintf("-> denied :: principal %s :: operation %s :: resource %s", principal, op, resource))
+				return nil, status.Errorf(codes.PermissionDenied, "%s may not %s %s", principal, op, resource)
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
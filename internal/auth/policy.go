@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Operation names an action a principal can perform on a resource
+type Operation string
+
+const (
+	// OpGet acquires a lock
+	OpGet Operation = "get"
+	// OpRefresh extends an existing lock
+	OpRefresh Operation = "refresh"
+	// OpDelete releases a lock
+	OpDelete Operation = "delete"
+	// OpCheck reads the state of a lock
+	OpCheck Operation = "check"
+	// OpList lists existing locks
+	OpList Operation = "list"
+	// OpForceRelease removes a lock regardless of its owner
+	OpForceRelease Operation = "force-release"
+	// OpTakeOver transfers a lock to a new owner
+	OpTakeOver Operation = "takeover"
+	// OpNoExpiry acquires a lock which never expires, it is checked in addition to OpGet
+	OpNoExpiry Operation = "no-expiry"
+)
+
+// adminOperations are never granted by the default effect or matched by wildcards, they require an allow rule naming them
+var adminOperations = map[Operation]bool{
+	OpForceRelease: true,
+	OpTakeOver:     true,
+	OpNoExpiry:     true,
+}
+
+// Effect decides whether a matching rule grants or denies access
+type Effect string
+
+const (
+	// Allow grants access
+	Allow Effect = "allow"
+	// Deny refuses access
+	Deny Effect = "deny"
+)
+
+const groupPrefix = "group:"
+
+// Rule matches principals, resources and operations.
+// Every field supports the `*` wildcard, principals may reference a group with `group:<name>`.
+// Wildcards in operations never match the admin operations, which have to be listed by name.
+type Rule struct {
+	Effect     Effect      `yaml:"effect"`
+	Principals []string    `yaml:"principals"`
+	Resources  []string    `yaml:"resources"`
+	Operations []Operation `yaml:"operations"`
+}
+
+// Policy holds an ordered list of rules. The first matching rule wins,
+// if no rule matches the default effect applies.
+type Policy struct {
+	Default Effect              `yaml:"default"`
+	Groups  map[string][]string `yaml:"groups"`
+	Rules   []Rule              `yaml:"rules"`
+}
+
+// DefaultPolicy allows every operation except the admin operations
+func DefaultPolicy() *Policy {
+	return &Policy{Default: Allow}
+}
+
+// Engine evaluates requests against a policy
+type Engine struct {
+	mu     sync.RWMutex
+	policy *Policy
+}
+
+// LoadPolicy reads and validates a policy from a yaml file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses and validates a yaml encoded policy
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := Policy{Default: Deny}
+
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	if p.Default != Allow && p.Default != Deny {
+		return fmt.Errorf("invalid policy :: unknown default effect %q", p.Default)
+	}
+
+	for i, r := range p.Rules {
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("invalid policy :: rule %d :: unknown effect %q", i, r.Effect)
+		}
+		if len(r.Principals) == 0 || len(r.Resources) == 0 || len(r.Operations) == 0 {
+			return fmt.Errorf("invalid policy :: rule %d :: principals, resources and operations are required", i)
+		}
+		for _, pr := range r.Principals {
+			if strings.HasPrefix(pr, groupPrefix) {
+				if _, ok := p.Groups[strings.TrimPrefix(pr, groupPrefix)]; !ok {
+					return fmt.Errorf("invalid policy :: rule %d :: unknown group %q", i, pr)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewEngine returns a pointer to an Engine evaluating the given policy
+func NewEngine(p *Policy) *Engine {
+	return &Engine{policy: p}
+}
+
+// SetPolicy replaces the evaluated policy, requests in flight keep using the previous one
+func (e *Engine) SetPolicy(p *Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.policy = p
+}
+
+// Allowed reports whether the principal may perform op on resource.
+// Admin operations are only allowed by a rule listing them by name.
+func (e *Engine) Allowed(principal string, resource string, op Operation) bool {
+	e.mu.RLock()
+	p := e.policy
+	e.mu.RUnlock()
+
+	for _, r := range p.Rules {
+		if r.matches(p, principal, resource, op) {
+			return r.Effect == Allow
+		}
+	}
+
+	return p.Default == Allow && !adminOperations[op]
+}
+
+func (r *Rule) matches(p *Policy, principal string, resource string, op Operation) bool {
+	return r.matchesOperation(op) && r.matchesResource(resource) && r.matchesPrincipal(p, principal)
+}
+
+func (r *Rule) matchesOperation(op Operation) bool {
+	for _, o := range r.Operations {
+		if adminOperations[op] && o != op {
+			continue
+		}
+		if match(string(o), string(op)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) matchesResource(resource string) bool {
+	for _, res := range r.Resources {
+		if match(res, resource) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) matchesPrincipal(p *Policy, principal string) bool {
+	for _, pr := range r.Principals {
+		if strings.HasPrefix(pr, groupPrefix) {
+			for _, member := range p.Groups[strings.TrimPrefix(pr, groupPrefix)] {
+				if match(member, principal) {
+					return true
+				}
+			}
+			continue
+		}
+		if match(pr, principal) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match reports whether s matches pattern, where `*` matches any sequence of characters
+func match(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
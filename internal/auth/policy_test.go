@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+const testPolicy = `
+default: allow
+groups:
+  admins: [alice, "ops-*"]
+rules:
+  - effect: allow
+    principals: [billing]
+    resources: ["invoice/*"]
+    operations: ["*"]
+  - effect: allow
+    principals: [billing]
+    resources: ["invoice/*"]
+    operations: [no-expiry]
+  - effect: allow
+    principals: ["group:admins"]
+    resources: ["invoice/*"]
+    operations: [force-release]
+  - effect: deny
+    principals: ["*"]
+    resources: ["invoice/*"]
+    operations: [get, refresh, delete]
+  - effect: allow
+    principals: ["group:admins"]
+    resources: ["*"]
+    operations: [list]
+  - effect: deny
+    principals: ["*"]
+    resources: ["*"]
+    operations: [list]
+`
+
+type testRequest struct {
+	resource string
+}
+
+func (r *testRequest) GetResourceId() string {
+	return r.resource
+}
+
+type testNoExpiryRequest struct {
+	testRequest
+	noExpiry bool
+}
+
+func (r *testNoExpiryRequest) GetNoExpiry() bool {
+	return r.noExpiry
+}
+
+type testListRequest struct {
+	prefix string
+}
+
+func (r *testListRequest) GetPrefix() string {
+	return r.prefix
+}
+
+func newTestEngine(t *testing.T) *Engine {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("could not parse policy: %s", err.Error())
+	}
+	return NewEngine(p)
+}
+
+func TestEngine_Allowed(t *testing.T) {
+	e := newTestEngine(t)
+
+	assert.True(t, e.Allowed("billing", "invoice/42", OpGet), "billing should lock invoices")
+	assert.False(t, e.Allowed("shipping", "invoice/42", OpGet), "shipping should not lock invoices")
+	assert.True(t, e.Allowed("shipping", "invoice/42", OpCheck), "shipping should check invoices")
+	assert.True(t, e.Allowed("shipping", "parcel/42", OpGet), "default effect should apply")
+	assert.True(t, e.Allowed("alice", "", OpList), "admins should list")
+	assert.True(t, e.Allowed("ops-bob", "", OpList), "group members should match wildcards")
+	assert.False(t, e.Allowed("billing", "", OpList), "non admins should not list")
+	assert.False(t, e.Allowed("billing", "invoice/42", OpForceRelease), "wildcard rules should not grant admin operations")
+	assert.False(t, e.Allowed("billing", "invoice/42", OpTakeOver), "wildcard rules should not grant admin operations")
+	assert.True(t, e.Allowed("alice", "invoice/42", OpForceRelease), "rules naming admin operations should grant them")
+	assert.False(t, e.Allowed("alice", "parcel/42", OpForceRelease), "default effect should not grant admin operations")
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	_, err := ParsePolicy([]byte("default: maybe"))
+	assert.Error(t, err, "unknown default effect should be rejected")
+
+	_, err = ParsePolicy([]byte("rules:\n  - effect: allow\n    principals: [a]\n"))
+	assert.Error(t, err, "incomplete rules should be rejected")
+
+	_, err = ParsePolicy([]byte("rules:\n  - effect: allow\n    principals: [\"group:nope\"]\n    resources: [a]\n    operations: [get]\n"))
+	assert.Error(t, err, "unknown groups should be rejected")
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, match("*", ""))
+	assert.True(t, match("invoice/*", "invoice/a/b"))
+	assert.True(t, match("*/a/*", "x/a/y"))
+	assert.False(t, match("invoice/*", "invoices"))
+	assert.False(t, match("a*b*b", "ab"))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(newTestEngine(t), MetadataResolver("principal"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/lock.Lock/GetLock"}
+
+	var seen string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = FromContext(ctx)
+		return req, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("principal", "billing"))
+	_, err := interceptor(ctx, &testRequest{"invoice/1"}, info, handler)
+	assert.NoError(t, err, "billing should be allowed")
+	assert.Equal(t, "billing", seen, "principal should be passed to the handler")
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("principal", "shipping"))
+	_, err = interceptor(ctx, &testRequest{"invoice/1"}, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "shipping should be denied")
+}
+
+func TestUnaryServerInterceptor_List(t *testing.T) {
+	interceptor := UnaryServerInterceptor(newTestEngine(t), MetadataResolver("principal"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/lock.v1.LockService/ListLocks"}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("principal", "alice"))
+	_, err := interceptor(ctx, &testListRequest{"invoice/"}, info, handler)
+	assert.NoError(t, err, "admins should list")
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("principal", "billing"))
+	_, err = interceptor(ctx, &testListRequest{""}, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "non admins should not list")
+}
+
+func TestUnaryServerInterceptor_NoExpiry(t *testing.T) {
+	interceptor := UnaryServerInterceptor(newTestEngine(t), MetadataResolver("principal"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/lock.v1.LockService/GetLock"}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("principal", "alice"))
+	_, err := interceptor(ctx, &testNoExpiryRequest{testRequest{"parcel/1"}, false}, info, handler)
+	assert.NoError(t, err, "locks with a ttl should only need get")
+
+	_, err = interceptor(ctx, &testNoExpiryRequest{testRequest{"parcel/1"}, true}, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "default effect should not grant locks without expiry")
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("principal", "billing"))
+	_, err = interceptor(ctx, &testNoExpiryRequest{testRequest{"invoice/1"}, true}, info, handler)
+	assert.NoError(t, err, "rules naming no-expiry should grant locks without expiry")
+}
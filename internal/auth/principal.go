@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Anonymous is the principal used for callers that could not be identified
+const Anonymous = "anonymous"
+
+type principalKey struct{}
+
+// Resolver extracts the principal (caller identity) from a request context
+type Resolver func(ctx context.Context) string
+
+// NewContext returns a copy of ctx carrying the given principal
+func NewContext(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal stored in ctx by NewContext.
+// If no principal is present Anonymous is returned.
+func FromContext(ctx context.Context) string {
+	if p, ok := ctx.Value(principalKey{}).(string); ok && p != "" {
+		return p
+	}
+
+	return Anonymous
+}
+
+// PeerResolver identifies the caller by the common name of its verified TLS client certificate
+func PeerResolver(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+
+	if !ok || p.AuthInfo == nil {
+		return Anonymous
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+
+	if !ok {
+		return Anonymous
+	}
+
+	return commonName(info.State)
+}
+
+// MetadataResolver identifies the caller by the value of the given metadata key.
+// The header is not verified in any way, so this should only be used behind a trusted proxy.
+func MetadataResolver(key string) Resolver {
+	return func(ctx context.Context) string {
+		md, ok := metadata.FromIncomingContext(ctx)
+
+		if !ok {
+			return Anonymous
+		}
+
+		if values := md.Get(key); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+
+		return Anonymous
+	}
+}
+
+func commonName(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return Anonymous
+	}
+
+	if cn := state.VerifiedChains[0][0].Subject.CommonName; cn != "" {
+		return cn
+	}
+
+	return Anonymous
+}
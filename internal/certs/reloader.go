@@ -0,0 +1,162 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/stoex/go-lock/internal/config"
+	"github.com/stoex/go-lock/internal/logger"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCheckInterval is the minimum time between two checks of the certificate files for changes
+const DefaultCheckInterval = 10 * time.Second
+
+// Reloader serves a certificate key pair and loads it again once the files changed,
+// so rotated certificates are picked up without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewReloader returns a pointer to a Reloader for the given files, which have to hold a valid key pair
+func NewReloader(certFile string, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, interval: DefaultCheckInterval, now: time.Now}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload loads the key pair from its files, the current certificate is kept on failure
+func (r *Reloader) Reload() error {
+	apply, err := r.Prepare()
+	if err != nil {
+		return err
+	}
+
+	apply()
+
+	return nil
+}
+
+// Prepare loads the key pair from its files and returns a function serving it.
+// The current certificate is served until apply is called, so a reload can be validated as a whole first.
+func (r *Reloader) Prepare() (apply func(), err error) {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate :: %s", err.Error())
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.cert = &cert
+		r.modTime = modTime
+		r.checked = r.now()
+	}, nil
+}
+
+// GetCertificate returns the current certificate and reloads it if the files changed, see tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	due := r.now().Sub(r.checked) >= r.interval
+	if due {
+		r.checked = r.now()
+	}
+	current := r.cert
+	loaded := r.modTime
+	r.mu.Unlock()
+
+	if !due {
+		return current, nil
+	}
+
+	if modTime, err := r.filesModTime(); err != nil || !modTime.After(loaded) {
+		return current, nil
+	}
+
+	ctx := context.Background()
+
+	if err := r.Reload(); err != nil {
+		logger.Warn(ctx, fmt.Sprintf("-> certificate reload fail :: keeping previous certificate :: %v", err))
+		return current, nil
+	}
+
+	logger.Info(ctx, fmt.Sprintf("-> certificate reload ok :: %s", r.certFile))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cert, nil
+}
+
+// filesModTime returns the latest modification time of the certificate and key files
+func (r *Reloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// ServerConfig returns the TLS settings of the server described by c and the Reloader serving its certificate
+func ServerConfig(c config.TLSConfig) (*tls.Config, *Reloader, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, nil, fmt.Errorf("cert_file and key_file are required for TLS")
+	}
+
+	r, err := NewReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tc := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+
+	if c.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA bundle :: %s", err.Error())
+		}
+		tc.ClientCAs = x509.NewCertPool()
+		if !tc.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, nil, fmt.Errorf("failed to parse client CA bundle :: file %s", c.ClientCAFile)
+		}
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			tc.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tc, r, nil
+}
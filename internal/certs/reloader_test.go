@@ -0,0 +1,146 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stoex/go-lock/internal/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a self-signed certificate for name and its key to dir
+func writeKeyPair(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %s", err.Error())
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not marshal key: %s", err.Error())
+	}
+
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, c *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatalf("could not parse certificate: %s", err.Error())
+	}
+	return leaf.Subject.CommonName
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestReloader_GetCertificate(t *testing.T) {
+	dir := tempDir(t)
+	certFile, keyFile := writeKeyPair(t, dir, "first")
+
+	r, err := NewReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	c, err := r.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, c))
+
+	writeKeyPair(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	c, _ = r.GetCertificate(nil)
+	assert.Equal(t, "first", commonName(t, c), "files should not be checked before the interval passed")
+
+	now = now.Add(DefaultCheckInterval)
+	c, _ = r.GetCertificate(nil)
+	assert.Equal(t, "second", commonName(t, c), "rotated files should be loaded")
+
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	evenLater := later.Add(time.Minute)
+	os.Chtimes(keyFile, evenLater, evenLater)
+	now = now.Add(DefaultCheckInterval)
+	c, err = r.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", commonName(t, c), "invalid files should keep the previous certificate")
+}
+
+func TestReloader_Prepare(t *testing.T) {
+	dir := tempDir(t)
+	certFile, keyFile := writeKeyPair(t, dir, "first")
+
+	r, err := NewReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	writeKeyPair(t, dir, "second")
+	apply, err := r.Prepare()
+	assert.NoError(t, err)
+
+	c, _ := r.GetCertificate(nil)
+	assert.Equal(t, "first", commonName(t, c), "prepared certificates should not be served before they are applied")
+
+	apply()
+	c, _ = r.GetCertificate(nil)
+	assert.Equal(t, "second", commonName(t, c), "applied certificates should be served")
+
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	_, err = r.Prepare()
+	assert.Error(t, err, "invalid files should not be prepared")
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := tempDir(t)
+	certFile, keyFile := writeKeyPair(t, dir, "server")
+
+	_, _, err := ServerConfig(config.TLSConfig{Enabled: true})
+	assert.Error(t, err, "TLS without certificate should fail closed")
+
+	tc, _, err := ServerConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, tc.ClientAuth, "client certificates should not be requested without CA")
+
+	tc, _, err = ServerConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile})
+	assert.NoError(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, tc.ClientAuth)
+
+	tc, _, err = ServerConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile, RequireClientCert: true})
+	assert.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tc.ClientAuth)
+
+	_, _, err = ServerConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile})
+	assert.Error(t, err, "invalid CA bundles should be rejected")
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// Protocols served on a listener
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+)
+
+// ListenerConfig describes a single socket the server accepts connections on
+type ListenerConfig struct {
+	// Network is tcp or unix
+	Network string `yaml:"network"`
+	// Address is host:port for tcp or the socket path for unix
+	Address string `yaml:"address"`
+	// TLS serves the listener with the certificate of the tls section
+	TLS bool `yaml:"tls"`
+	// Mode is the octal file mode of a unix socket, e.g. "0660"
+	Mode string `yaml:"mode"`
+	// Protocol is grpc (default) or http for the JSON gateway
+	Protocol string `yaml:"protocol"`
+}
+
+// ParseListener parses a listener given as URL:
+//
+//	tcp://host:port    plain tcp
+//	tls://host:port    tcp with TLS
+//	http://host:port   JSON gateway over plain tcp
+//	https://host:port  JSON gateway over tcp with TLS
+//	unix:///path?mode=0660[&protocol=http]
+func ParseListener(spec string) (ListenerConfig, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return ListenerConfig{}, err
+	}
+
+	switch u.Scheme {
+	case "tcp", "tls":
+		return ListenerConfig{Network: "tcp", Address: u.Host, TLS: u.Scheme == "tls"}, nil
+	case "http", "https":
+		return ListenerConfig{Network: "tcp", Address: u.Host, TLS: u.Scheme == "https", Protocol: ProtocolHTTP}, nil
+	case "unix":
+		q := u.Query()
+		return ListenerConfig{Network: "unix", Address: u.Path, Mode: q.Get("mode"), Protocol: q.Get("protocol")}, nil
+	}
+
+	return ListenerConfig{}, fmt.Errorf("unknown listener scheme %q", u.Scheme)
+}
+
+// ParseListeners parses every spec with ParseListener
+func ParseListeners(specs []string) ([]ListenerConfig, error) {
+	listeners := make([]ListenerConfig, len(specs))
+
+	for i, spec := range specs {
+		l, err := ParseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+		listeners[i] = l
+	}
+
+	return listeners, nil
+}
+
+// FileMode returns the permissions of a unix socket, 0 keeps the default of the process umask
+func (l ListenerConfig) FileMode() (uint32, error) {
+	if l.Mode == "" {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(l.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q", l.Mode)
+	}
+
+	return uint32(mode), nil
+}
+
+// HTTP reports whether the listener serves the JSON gateway instead of grpc
+func (l ListenerConfig) HTTP() bool {
+	return l.Protocol == ProtocolHTTP
+}
+
+// String returns the listener in the form accepted by ParseListener
+func (l ListenerConfig) String() string {
+	switch {
+	case l.Network == "unix" && l.HTTP():
+		return "unix://" + l.Address + "?protocol=http"
+	case l.Network == "unix":
+		return "unix://" + l.Address
+	case l.TLS && l.HTTP():
+		return "https://" + l.Address
+	case l.HTTP():
+		return "http://" + l.Address
+	case l.TLS:
+		return "tls://" + l.Address
+	}
+
+	return "tcp://" + l.Address
+}
+
+func (l ListenerConfig) validate() error {
+	if l.Protocol != "" && l.Protocol != ProtocolGRPC && l.Protocol != ProtocolHTTP {
+		return fmt.Errorf("unknown protocol %q, expected grpc or http", l.Protocol)
+	}
+
+	switch l.Network {
+	case "tcp":
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return fmt.Errorf("invalid tcp address %q", l.Address)
+		}
+		if l.Mode != "" {
+			return fmt.Errorf("mode is only supported for unix sockets")
+		}
+	case "unix":
+		if l.Address == "" {
+			return fmt.Errorf("unix socket path is required")
+		}
+		if _, err := l.FileMode(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown network %q, expected tcp or unix", l.Network)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseListener(t *testing.T) {
+	l, err := ParseListener("tls://0.0.0.0:10443")
+	assert.NoError(t, err)
+	assert.Equal(t, ListenerConfig{Network: "tcp", Address: "0.0.0.0:10443", TLS: true}, l)
+
+	l, err = ParseListener("unix:///run/go-lock.sock?mode=0660")
+	assert.NoError(t, err)
+	assert.Equal(t, ListenerConfig{Network: "unix", Address: "/run/go-lock.sock", Mode: "0660"}, l)
+	mode, err := l.FileMode()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0660), mode)
+
+	l, err = ParseListener("https://0.0.0.0:8443")
+	assert.NoError(t, err)
+	assert.Equal(t, ListenerConfig{Network: "tcp", Address: "0.0.0.0:8443", TLS: true, Protocol: ProtocolHTTP}, l)
+	assert.Equal(t, "https://0.0.0.0:8443", l.String())
+
+	l, err = ParseListener("unix:///run/go-lock-http.sock?protocol=http")
+	assert.NoError(t, err)
+	assert.True(t, l.HTTP(), "unix sockets should serve the gateway with protocol=http")
+
+	_, err = ParseListener("udp://0.0.0.0:10000")
+	assert.Error(t, err, "unknown schemes should be rejected")
+
+	assert.Error(t, ListenerConfig{Network: "unix", Address: "/run/a.sock", Mode: "rw"}.validate(), "modes should be octal")
+	assert.Error(t, ListenerConfig{Network: "tcp", Address: "10000"}.validate(), "tcp addresses need a port")
+	assert.Error(t, ListenerConfig{Network: "tcp", Address: ":8080", Protocol: "ws"}.validate(), "unknown protocols should be rejected")
+}
+
+func TestManager_EffectiveListeners(t *testing.T) {
+	c, err := Load(writeConfig(t, "server:\n  address: 0.0.0.0\n  port: 11000\ntls:\n  enabled: true\n  cert_file: a.pem\n  key_file: a.key\nredlock:\n  backend: memory\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []ListenerConfig{{Network: "tcp", Address: "0.0.0.0:11000", TLS: true}}, c.EffectiveListeners(), "the default listener should use address and port")
+
+	c, err = Load(writeConfig(t, `
+server:
+  listeners:
+    - {network: tcp, address: "0.0.0.0:10000"}
+    - {network: unix, address: /run/go-lock.sock, mode: "0660"}
+redlock:
+  backend: memory
+`))
+	assert.NoError(t, err)
+	assert.Len(t, c.EffectiveListeners(), 2, "listeners should replace the default one")
+
+	_, err = Load(writeConfig(t, "server:\n  listeners:\n    - {network: unix}\nredlock:\n  backend: memory\n"))
+	assert.Error(t, err, "unix listeners need a path")
+}
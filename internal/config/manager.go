@@ -1,11 +1,18 @@
 package config
 
 import (
+	"fmt"
 	"github.com/joho/godotenv"
+	"github.com/stoex/go-lock/internal/audit"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -14,23 +21,351 @@ func init() {
 	}
 }
 
-// RedlockConfig holds a slice with the addresses for all redis instances
+// ServerConfig holds the listeners and the files of the request policies
+type ServerConfig struct {
+	// Address is the host the default listener binds to
+	Address string `yaml:"address"`
+	// Port is the tcp port of the default listener
+	Port int `yaml:"port"`
+	// Listeners replace the default listener on Address and Port if set
+	Listeners []ListenerConfig `yaml:"listeners"`
+	// PolicyFile is the authorization policy, see auth.LoadPolicy
+	PolicyFile string `yaml:"policy_file"`
+	// LimitsFile holds rate limits and quotas, it can not be combined with Manager.Limits
+	LimitsFile string `yaml:"limits_file"`
+	// Shutdown configures how the server stops
+	Shutdown ShutdownConfig `yaml:"shutdown"`
+}
+
+// ShutdownConfig holds the bounds of a graceful shutdown
+type ShutdownConfig struct {
+	// Timeout is the time requests in flight may take before the server stops forcefully
+	Timeout time.Duration `yaml:"timeout"`
+	// ReleaseLocks releases the unexpired locks acquired through this server once the servers stopped,
+	// taking up to another Timeout
+	ReleaseLocks bool `yaml:"release_locks"`
+}
+
+// TLSConfig holds the server certificate and the verification of client certificates
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile is a PEM bundle of the authorities client certificates are verified against
+	ClientCAFile string `yaml:"client_ca_file"`
+	// RequireClientCert rejects connections without a valid client certificate
+	RequireClientCert bool `yaml:"require_client_cert"`
+}
+
+// RedlockConfig holds the lock backend, the addresses of its nodes and the redlock tuning
 type RedlockConfig struct {
-	Clients []string
+	// Backend is one of redis, etcd, postgres or memory
+	Backend string `yaml:"backend"`
+	// Clients holds the URLs of all redis nodes, see redlock.NewRedisNode
+	Clients []string `yaml:"clients"`
+	// RedisDefaults holds the TLS and credential settings of nodes not overriding them in their URL
+	RedisDefaults RedisNodeConfig `yaml:"redis"`
+	// EtcdEndpoints holds the endpoints of a single etcd cluster
+	EtcdEndpoints []string `yaml:"etcd_endpoints"`
+	// EtcdPrefix is the namespace of the lock keys in the etcd keyspace
+	EtcdPrefix string `yaml:"etcd_prefix"`
+	// PostgresDSN is the connection string of the postgres database
+	PostgresDSN string `yaml:"postgres_dsn"`
+	// RetryCount is the number of attempts to acquire or refresh a lock, 0 keeps the default
+	RetryCount int `yaml:"retry_count"`
+	// RetryDelay is the max delay between attempts in milliseconds, 0 keeps the default.
+	// It is the base delay of the fixed, exponential and decorrelated_jitter strategies.
+	RetryDelay int `yaml:"retry_delay"`
+	// RetryStrategy is one of uniform, fixed, exponential or decorrelated_jitter, empty keeps uniform
+	RetryStrategy string `yaml:"retry_strategy"`
+	// RetryMaxDelay caps a single delay of the exponential strategies in milliseconds, 0 keeps the default
+	RetryMaxDelay int `yaml:"retry_max_delay"`
+	// RetryDeadline stops retrying once it passed since the first attempt in milliseconds, 0 disables it
+	RetryDeadline int `yaml:"retry_deadline"`
+	// DriftFactor is the clock drift between nodes relative to the ttl, 0 keeps the default
+	DriftFactor float64 `yaml:"drift_factor"`
+	// Strict refuses to start unless every node is reachable
+	Strict bool `yaml:"strict"`
+}
+
+// AuditConfig holds the sinks audit records are written to, records are discarded if none is set
+type AuditConfig struct {
+	// File is the file records are appended to as JSON lines
+	File string `yaml:"file"`
+	// Redis is the redis:// url of the instance records are streamed to
+	Redis string `yaml:"redis"`
+	// Stream is the redis stream key records are added to
+	Stream string `yaml:"stream"`
+}
+
+// LoggingConfig holds the logger settings
+type LoggingConfig struct {
+	// Level is the minimum level of logged statements
+	Level string `yaml:"level"`
 }
 
 // Manager represents a struct holding all application config info
 type Manager struct {
-	Redlock RedlockConfig
+	Server  ServerConfig  `yaml:"server"`
+	TLS     TLSConfig     `yaml:"tls"`
+	Redlock RedlockConfig `yaml:"redlock"`
+	TTL     TTLConfig     `yaml:"ttl"`
+	Audit   AuditConfig   `yaml:"audit"`
+	Logging LoggingConfig `yaml:"logging"`
+	// Limits is the inline rate limits section, it is parsed and validated by ratelimit.ParseConfig
+	Limits yaml.MapSlice `yaml:"limits"`
+}
+
+// backends are the lock backends known to the service
+var backends = map[string]bool{"redis": true, "etcd": true, "postgres": true, "memory": true}
+
+// retryStrategies are the retry strategies known to the service
+var retryStrategies = map[string]bool{"": true, "uniform": true, "fixed": true, "exponential": true, "decorrelated_jitter": true}
+
+// Load returns the config of the yaml file at path overridden by the environment and then by overrides.
+// Without a path only the environment is used. The result is validated once all overrides are applied.
+func Load(path string, overrides ...func(*Manager) error) (*Manager, error) {
+	m := defaultManager()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, m); err != nil {
+			return nil, fmt.Errorf("invalid config :: %s :: %v", path, err)
+		}
+	}
+
+	if err := m.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	for _, override := range overrides {
+		if err := override(m); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
 
-// NewManager return a pointer to the new Manager instance
-func NewManager() *Manager {
+func defaultManager() *Manager {
 	return &Manager{
+		Server: ServerConfig{
+			Address:  "localhost",
+			Port:     10000,
+			Shutdown: ShutdownConfig{Timeout: 10 * time.Second},
+		},
 		Redlock: RedlockConfig{
-			Clients: getEnvAsSlice("REDIS_CLIENTS", []string{""}, ","),
+			Backend:       "redis",
+			EtcdEndpoints: []string{"localhost:2379"},
+			EtcdPrefix:    "go-lock/",
+			PostgresDSN:   "postgres://localhost:5432/golock?sslmode=disable",
 		},
+		TTL:     TTLConfig{TTLBounds: TTLBounds{Max: DefaultMaxTTL, Default: DefaultTTL}},
+		Audit:   AuditConfig{Stream: audit.DefaultStream},
+		Logging: LoggingConfig{Level: "info"},
+	}
+}
+
+// applyEnv overrides every setting whose environment variable is set, variables which can not be parsed are
+// reported together
+func (m *Manager) applyEnv() error {
+	var errs envErrors
+
+	m.Server.Address = getEnv("BIND_ADDRESS", m.Server.Address)
+	m.Server.Port = errs.int(getEnvAsInt("PORT", m.Server.Port))
+	if specs := getEnvAsSlice("LISTEN", nil, ","); specs != nil {
+		listeners, err := ParseListeners(specs)
+		if err != nil {
+			errs.add(fmt.Errorf("LISTEN :: %v", err))
+		} else {
+			m.Server.Listeners = listeners
+		}
+	}
+	m.Server.PolicyFile = getEnv("POLICY_FILE", m.Server.PolicyFile)
+	m.Server.LimitsFile = getEnv("LIMITS_FILE", m.Server.LimitsFile)
+	m.Server.Shutdown.Timeout = errs.duration(getEnvAsDuration("SHUTDOWN_TIMEOUT", m.Server.Shutdown.Timeout))
+	m.Server.Shutdown.ReleaseLocks = errs.bool(getEnvAsBool("SHUTDOWN_RELEASE_LOCKS", m.Server.Shutdown.ReleaseLocks))
+
+	m.TLS.Enabled = errs.bool(getEnvAsBool("TLS_ENABLED", m.TLS.Enabled))
+	m.TLS.CertFile = getEnv("TLS_CERT_FILE", m.TLS.CertFile)
+	m.TLS.KeyFile = getEnv("TLS_KEY_FILE", m.TLS.KeyFile)
+	m.TLS.ClientCAFile = getEnv("TLS_CLIENT_CA_FILE", m.TLS.ClientCAFile)
+	m.TLS.RequireClientCert = errs.bool(getEnvAsBool("TLS_REQUIRE_CLIENT_CERT", m.TLS.RequireClientCert))
+
+	r := &m.Redlock
+	r.Backend = getEnv("LOCK_BACKEND", r.Backend)
+	r.Clients = getEnvAsURLs("REDIS_CLIENTS", r.Clients)
+	r.RedisDefaults.Username = getEnv("REDIS_USERNAME", r.RedisDefaults.Username)
+	r.RedisDefaults.PasswordFile = getEnv("REDIS_PASSWORD_FILE", r.RedisDefaults.PasswordFile)
+	r.RedisDefaults.CAFile = getEnv("REDIS_TLS_CA_FILE", r.RedisDefaults.CAFile)
+	r.RedisDefaults.CertFile = getEnv("REDIS_TLS_CERT_FILE", r.RedisDefaults.CertFile)
+	r.RedisDefaults.KeyFile = getEnv("REDIS_TLS_KEY_FILE", r.RedisDefaults.KeyFile)
+	r.RedisDefaults.ServerName = getEnv("REDIS_TLS_SERVER_NAME", r.RedisDefaults.ServerName)
+	r.EtcdEndpoints = getEnvAsSlice("ETCD_ENDPOINTS", r.EtcdEndpoints, ",")
+	r.EtcdPrefix = getEnv("ETCD_PREFIX", r.EtcdPrefix)
+	r.PostgresDSN = getEnv("POSTGRES_DSN", r.PostgresDSN)
+	r.RetryCount = errs.int(getEnvAsInt("REDLOCK_RETRY_COUNT", r.RetryCount))
+	r.RetryDelay = errs.int(getEnvAsInt("REDLOCK_RETRY_DELAY", r.RetryDelay))
+	r.RetryStrategy = getEnv("REDLOCK_RETRY_STRATEGY", r.RetryStrategy)
+	r.RetryMaxDelay = errs.int(getEnvAsInt("REDLOCK_RETRY_MAX_DELAY", r.RetryMaxDelay))
+	r.RetryDeadline = errs.int(getEnvAsInt("REDLOCK_RETRY_DEADLINE", r.RetryDeadline))
+	r.DriftFactor = errs.float(getEnvAsFloat("REDLOCK_DRIFT_FACTOR", r.DriftFactor))
+	r.Strict = errs.bool(getEnvAsBool("REDLOCK_STRICT", r.Strict))
+
+	m.TTL.Min = errs.duration(getEnvAsDuration("TTL_MIN", m.TTL.Min))
+	m.TTL.Max = errs.duration(getEnvAsDuration("TTL_MAX", m.TTL.Max))
+	m.TTL.Default = errs.duration(getEnvAsDuration("TTL_DEFAULT", m.TTL.Default))
+	m.TTL.AllowNoExpiry = errs.bool(getEnvAsBool("TTL_ALLOW_NO_EXPIRY", m.TTL.AllowNoExpiry))
+
+	m.Audit.File = getEnv("AUDIT_FILE", m.Audit.File)
+	m.Audit.Redis = getEnv("AUDIT_REDIS", m.Audit.Redis)
+	m.Audit.Stream = getEnv("AUDIT_STREAM", m.Audit.Stream)
+
+	m.Logging.Level = getEnv("LOG_LEVEL", m.Logging.Level)
+
+	return errs.err()
+}
+
+// validatePolicyTLS checks that callers can be identified for the policy, the policy only knows
+// the common names of verified client certificates
+func (m *Manager) validatePolicyTLS() error {
+	if !m.TLS.RequireClientCert {
+		return fmt.Errorf("invalid config :: server :: policy_file needs tls.require_client_cert, callers are identified by their client certificate")
+	}
+
+	for _, l := range m.EffectiveListeners() {
+		if l.TLS {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid config :: server :: policy_file needs a TLS listener, callers are identified by their client certificate")
+}
+
+// Validate checks all settings which can be checked without connecting anywhere
+func (m *Manager) Validate() error {
+	if m.Server.Port < 1 || m.Server.Port > 65535 {
+		return fmt.Errorf("invalid config :: server :: port %d out of range", m.Server.Port)
+	}
+	for i, l := range m.Server.Listeners {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("invalid config :: server :: listener %d :: %v", i+1, err)
+		}
+	}
+	if m.Server.Shutdown.Timeout <= 0 {
+		return fmt.Errorf("invalid config :: server :: shutdown timeout must be positive")
+	}
+	if m.Limits != nil && m.Server.LimitsFile != "" {
+		return fmt.Errorf("invalid config :: server :: limits_file can not be combined with limits")
+	}
+	if (m.TLS.CertFile == "") != (m.TLS.KeyFile == "") {
+		return fmt.Errorf("invalid config :: tls :: cert_file and key_file must be set together")
+	}
+	for _, l := range m.EffectiveListeners() {
+		if l.TLS && m.TLS.CertFile == "" {
+			return fmt.Errorf("invalid config :: tls :: cert_file and key_file are required for TLS listener %s", l)
+		}
+	}
+	if m.TLS.RequireClientCert && m.TLS.ClientCAFile == "" {
+		return fmt.Errorf("invalid config :: tls :: require_client_cert needs a client_ca_file")
+	}
+	if m.Server.PolicyFile != "" {
+		if err := m.validatePolicyTLS(); err != nil {
+			return err
+		}
+	}
+
+	r := m.Redlock
+	if !backends[r.Backend] {
+		return fmt.Errorf("invalid config :: redlock :: unknown backend %q", r.Backend)
+	}
+	if r.RetryCount < 0 || r.RetryDelay < 0 || r.RetryMaxDelay < 0 || r.RetryDeadline < 0 {
+		return fmt.Errorf("invalid config :: redlock :: retry count, delays and deadline must not be negative")
+	}
+	if !retryStrategies[r.RetryStrategy] {
+		return fmt.Errorf("invalid config :: redlock :: unknown retry strategy %q", r.RetryStrategy)
+	}
+	if r.DriftFactor < 0 || r.DriftFactor >= 1 {
+		return fmt.Errorf("invalid config :: redlock :: drift factor must be in [0, 1)")
+	}
+	if r.Backend == "etcd" && r.EtcdPrefix == "" {
+		return fmt.Errorf("invalid config :: redlock :: etcd_prefix is required, lock keys must not share the root of the etcd keyspace")
 	}
+	if r.Backend == "redis" {
+		if err := r.validateClients(); err != nil {
+			return err
+		}
+	}
+
+	if err := m.TTL.validate(); err != nil {
+		return err
+	}
+
+	if m.Audit.Redis != "" && m.Audit.Stream == "" {
+		return fmt.Errorf("invalid config :: audit :: stream is required to stream records to redis")
+	}
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(m.Logging.Level)); err != nil {
+		return fmt.Errorf("invalid config :: logging :: %v", err)
+	}
+
+	return nil
+}
+
+// EffectiveListeners returns the configured listeners or the default tcp listener on Address and Port
+func (m *Manager) EffectiveListeners() []ListenerConfig {
+	if len(m.Server.Listeners) > 0 {
+		return m.Server.Listeners
+	}
+
+	return []ListenerConfig{{
+		Network: "tcp",
+		Address: net.JoinHostPort(m.Server.Address, strconv.Itoa(m.Server.Port)),
+		TLS:     m.TLS.Enabled,
+	}}
+}
+
+// validateClients checks that redis nodes are configured and their URLs parse
+func (c RedlockConfig) validateClients() error {
+	if len(c.Clients) == 0 {
+		return fmt.Errorf("invalid config :: redlock :: no redis nodes configured, set REDIS_CLIENTS or redlock.clients")
+	}
+
+	nodes, err := c.RedisNodes()
+	if err != nil {
+		return fmt.Errorf("invalid config :: redlock :: %v", err)
+	}
+
+	for i, n := range nodes {
+		if err := n.validate(); err != nil {
+			return fmt.Errorf("invalid config :: redlock :: redis node %d :: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// RedisNodes returns the connection settings of every entry of Clients
+func (c RedlockConfig) RedisNodes() ([]RedisNodeConfig, error) {
+	nodes := make([]RedisNodeConfig, len(c.Clients))
+
+	for i, addr := range c.Clients {
+		node, err := ParseRedisNode(addr, c.RedisDefaults)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = node
+	}
+
+	return nodes, nil
 }
 
 // Simple helper function to read an environment or return a default value
@@ -42,24 +377,102 @@ func getEnv(key string, defaultVal string) string {
 	return defaultVal
 }
 
-// Simple helper function to read an environment variable into integer or return a default value
-func getEnvAsInt(name string, defaultVal int) int {
+// Simple helper function to read an environment variable into integer or return a default value if it is not set
+func getEnvAsInt(name string, defaultVal int) (int, error) {
 	valueStr := getEnv(name, "")
-	if value, err := strconv.Atoi(valueStr); err == nil {
-		return value
+	if valueStr == "" {
+		return defaultVal, nil
 	}
 
-	return defaultVal
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return defaultVal, fmt.Errorf("%s :: %q is not an integer", name, valueStr)
+	}
+
+	return value, nil
 }
 
-// Helper to read an environment variable into a bool or return default value
-func getEnvAsBool(name string, defaultVal bool) bool {
+// Helper to read an environment variable into a bool or return default value if it is not set
+func getEnvAsBool(name string, defaultVal bool) (bool, error) {
 	valStr := getEnv(name, "")
-	if val, err := strconv.ParseBool(valStr); err == nil {
-		return val
+	if valStr == "" {
+		return defaultVal, nil
 	}
 
-	return defaultVal
+	val, err := strconv.ParseBool(valStr)
+	if err != nil {
+		return defaultVal, fmt.Errorf("%s :: %q is not a bool", name, valStr)
+	}
+
+	return val, nil
+}
+
+// Helper to read an environment variable into a float or return default value if it is not set
+func getEnvAsFloat(name string, defaultVal float64) (float64, error) {
+	valStr := getEnv(name, "")
+	if valStr == "" {
+		return defaultVal, nil
+	}
+
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil {
+		return defaultVal, fmt.Errorf("%s :: %q is not a number", name, valStr)
+	}
+
+	return val, nil
+}
+
+// Helper to read an environment variable into a duration or return default value if it is not set
+func getEnvAsDuration(name string, defaultVal time.Duration) (time.Duration, error) {
+	valStr := getEnv(name, "")
+	if valStr == "" {
+		return defaultVal, nil
+	}
+
+	val, err := time.ParseDuration(valStr)
+	if err != nil {
+		return defaultVal, fmt.Errorf("%s :: %q is not a duration", name, valStr)
+	}
+
+	return val, nil
+}
+
+// envErrors collects the errors of the environment variables which could not be parsed
+type envErrors []string
+
+func (e *envErrors) add(err error) {
+	if err != nil {
+		*e = append(*e, err.Error())
+	}
+}
+
+func (e *envErrors) int(val int, err error) int {
+	e.add(err)
+	return val
+}
+
+func (e *envErrors) bool(val bool, err error) bool {
+	e.add(err)
+	return val
+}
+
+func (e *envErrors) float(val float64, err error) float64 {
+	e.add(err)
+	return val
+}
+
+func (e *envErrors) duration(val time.Duration, err error) time.Duration {
+	e.add(err)
+	return val
+}
+
+// err returns a single error naming every invalid variable or nil
+func (e envErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("invalid config :: %s", strings.Join(e, ", "))
 }
 
 // Helper to read an environment variable into a string slice or return default value
@@ -74,3 +487,25 @@ func getEnvAsSlice(name string, defaultVal []string, sep string) []string {
 
 	return val
 }
+
+// Helper to read a comma separated list of URLs into a string slice or return default value unchanged.
+// URLs may hold several comma separated hosts themselves, a part without a scheme belongs to the previous URL.
+func getEnvAsURLs(name string, defaultVal []string) []string {
+	parts := getEnvAsSlice(name, nil, ",")
+
+	if parts == nil {
+		return defaultVal
+	}
+
+	var val []string
+
+	for _, part := range parts {
+		if len(val) > 0 && !strings.Contains(part, "://") {
+			val[len(val)-1] += "," + part
+			continue
+		}
+		val = append(val, part)
+	}
+
+	return val
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `
+server:
+  port: 11000
+  policy_file: /etc/go-lock/policy.yaml
+tls:
+  enabled: true
+  cert_file: /etc/go-lock/server.pem
+  key_file: /etc/go-lock/server.key
+  client_ca_file: /etc/go-lock/clients.pem
+  require_client_cert: true
+redlock:
+  clients: [redis://redis-1:6379, redis://redis-2:6379, redis://redis-3:6379]
+  redis:
+    username: go-lock
+    password_file: /run/secrets/redis
+  retry_count: 5
+  retry_delay: 100
+  retry_strategy: exponential
+  retry_deadline: 2000
+  drift_factor: 0.02
+logging:
+  level: warn
+limits:
+  per_caller: {rate: 10, burst: 20}
+`
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("could not write config: %s", err.Error())
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	c, err := Load(writeConfig(t, testConfig))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 11000, c.Server.Port)
+	assert.True(t, c.TLS.Enabled)
+	assert.Equal(t, []string{"redis://redis-1:6379", "redis://redis-2:6379", "redis://redis-3:6379"}, c.Redlock.Clients)
+	assert.Equal(t, "go-lock", c.Redlock.RedisDefaults.Username)
+	assert.Equal(t, "redis", c.Redlock.Backend, "unset settings should keep their defaults")
+	assert.Equal(t, 5, c.Redlock.RetryCount)
+	assert.Equal(t, 100, c.Redlock.RetryDelay)
+	assert.Equal(t, "exponential", c.Redlock.RetryStrategy)
+	assert.Equal(t, 2000, c.Redlock.RetryDeadline)
+	assert.Equal(t, 0.02, c.Redlock.DriftFactor)
+	assert.Equal(t, "warn", c.Logging.Level)
+	assert.NotEmpty(t, c.Limits, "the limits section should be kept for ratelimit.ParseConfig")
+	assert.Equal(t, DefaultMaxTTL, c.TTL.Max, "the ttl should be bounded by default")
+}
+
+func TestLoad_EnvOverride(t *testing.T) {
+	os.Setenv("PORT", "12000")
+	os.Setenv("REDIS_CLIENTS", "redis://other:6379")
+	os.Setenv("REDLOCK_DRIFT_FACTOR", "0.05")
+	defer os.Unsetenv("PORT")
+	defer os.Unsetenv("REDIS_CLIENTS")
+	defer os.Unsetenv("REDLOCK_DRIFT_FACTOR")
+
+	c, err := Load(writeConfig(t, testConfig))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 12000, c.Server.Port, "the environment should override the file")
+	assert.Equal(t, []string{"redis://other:6379"}, c.Redlock.Clients)
+	assert.Equal(t, 0.05, c.Redlock.DriftFactor)
+	assert.Equal(t, 5, c.Redlock.RetryCount, "settings without variable should keep the file value")
+}
+
+func TestLoad_InvalidEnv(t *testing.T) {
+	os.Setenv("PORT", "abc")
+	os.Setenv("TTL_MAX", "soon")
+	defer os.Unsetenv("PORT")
+	defer os.Unsetenv("TTL_MAX")
+
+	_, err := Load(writeConfig(t, testConfig))
+	if assert.Error(t, err, "invalid variables should not fall back to the default") {
+		assert.Contains(t, err.Error(), "PORT")
+		assert.Contains(t, err.Error(), "TTL_MAX", "every invalid variable should be reported")
+	}
+}
+
+func TestLoad_Audit(t *testing.T) {
+	os.Setenv("AUDIT_STREAM", "audit:locks")
+	defer os.Unsetenv("AUDIT_STREAM")
+
+	c, err := Load(writeConfig(t, "redlock:\n  backend: memory\naudit:\n  file: /var/log/go-lock/audit.log\n  redis: redis://audit:6379\n"))
+	if err != nil {
+		t.Fatalf("could not load config: %s", err.Error())
+	}
+
+	assert.Equal(t, "/var/log/go-lock/audit.log", c.Audit.File)
+	assert.Equal(t, "redis://audit:6379", c.Audit.Redis)
+	assert.Equal(t, "audit:locks", c.Audit.Stream, "the environment should override the stream")
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown field":   "server:\n  prot: 1\n",
+		"port":            "server:\n  port: 70000\n",
+		"backend":         "redlock:\n  backend: zookeeper\n",
+		"drift factor":    "redlock:\n  drift_factor: 1.5\n",
+		"retry count":     "redlock:\n  retry_count: -1\n",
+		"retry strategy":  "redlock:\n  retry_strategy: linear\n",
+		"log level":       "logging:\n  level: loud\n",
+		"etcd prefix":     "redlock:\n  backend: etcd\n  etcd_prefix: \"\"\n",
+		"audit stream":    "redlock:\n  backend: memory\naudit:\n  redis: redis://audit:6379\n  stream: \"\"\n",
+		"tls key pair":    "tls:\n  cert_file: a.pem\n",
+		"limits and file": "server:\n  limits_file: limits.yaml\nlimits:\n  max_locks_per_caller: 1\n",
+	} {
+		_, err := Load(writeConfig(t, content))
+		assert.Error(t, err, name)
+	}
+
+	_, err := Load("does-not-exist.yaml")
+	assert.Error(t, err, "missing files should be reported")
+}
+
+func TestLoad_Overrides(t *testing.T) {
+	os.Setenv("PORT", "12000")
+	defer os.Unsetenv("PORT")
+
+	c, err := Load(writeConfig(t, testConfig), func(m *Manager) error {
+		m.Server.Port = 13000
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not load config: %s", err.Error())
+	}
+	assert.Equal(t, 13000, c.Server.Port, "overrides should be applied after the environment")
+
+	_, err = Load(writeConfig(t, testConfig), func(m *Manager) error {
+		m.Server.Port = 70000
+		return nil
+	})
+	assert.Error(t, err, "overrides should be validated")
+
+	_, err = Load(writeConfig(t, testConfig), func(*Manager) error {
+		return errors.New("invalid flag")
+	})
+	assert.Error(t, err, "override errors should be reported")
+}
+
+func TestLoad_RedisClients(t *testing.T) {
+	_, err := Load(writeConfig(t, "redlock:\n  backend: redis\n"))
+	assert.Error(t, err, "the redis backend needs nodes")
+
+	_, err = Load(writeConfig(t, "redlock:\n  clients: [\"redis://a:6379\", \"\"]\n"))
+	assert.Error(t, err, "empty node URLs should be rejected")
+
+	_, err = Load(writeConfig(t, "redlock:\n  clients: [\"a:6379\"]\n"))
+	assert.Error(t, err, "node URLs need a scheme")
+
+	_, err = Load(writeConfig(t, "redlock:\n  clients: [\"redis://a:6379\", \"b:6379\"]\n"))
+	assert.Error(t, err, "entries of the config file should not be joined to the previous URL")
+
+	_, err = Load(writeConfig(t, "redlock:\n  backend: memory\n"))
+	assert.NoError(t, err, "other backends do not need redis nodes")
+}
+
+func TestLoad_TLS(t *testing.T) {
+	_, err := Load(writeConfig(t, "tls:\n  enabled: true\nredlock:\n  backend: memory\n"))
+	assert.Error(t, err, "TLS without certificate should be rejected")
+
+	_, err = Load(writeConfig(t, "tls:\n  require_client_cert: true\nredlock:\n  backend: memory\n"))
+	assert.Error(t, err, "required client certificates need a CA")
+}
+
+func TestLoad_PolicyTLS(t *testing.T) {
+	const tls = "tls:\n  enabled: true\n  cert_file: a.pem\n  key_file: a.key\n  client_ca_file: ca.pem\n"
+
+	_, err := Load(writeConfig(t, "server:\n  policy_file: policy.yaml\nredlock:\n  backend: memory\n"))
+	assert.Error(t, err, "a policy without client certificates should be rejected")
+
+	_, err = Load(writeConfig(t, "server:\n  policy_file: policy.yaml\n"+tls+"redlock:\n  backend: memory\n"))
+	assert.Error(t, err, "a policy with optional client certificates should be rejected")
+
+	_, err = Load(writeConfig(t, "server:\n  policy_file: policy.yaml\n"+tls+"  require_client_cert: true\nredlock:\n  backend: memory\n"))
+	assert.NoError(t, err, "a policy with required client certificates should be accepted")
+}
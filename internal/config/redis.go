@@ -0,0 +1,155 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// RedisNodeConfig holds the address, TLS and credential settings of a single redis node
+type RedisNodeConfig struct {
+	// URL is the address of the node without settings, see redlock.NewRedisNode
+	URL string `yaml:"-"`
+	// Username is the ACL user to authenticate as
+	Username string `yaml:"username"`
+	// PasswordFile is a file holding the password, it replaces the password of the URL
+	PasswordFile string `yaml:"password_file"`
+	// CAFile is a PEM bundle of the certificate authorities trusted for the node
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate presented to the node
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the host name verified in the node certificate
+	ServerName string `yaml:"server_name"`
+}
+
+// redisNodeSettings maps the URL query parameters overriding the defaults of a node to its fields
+var redisNodeSettings = map[string]func(n *RedisNodeConfig) *string{
+	"username":      func(n *RedisNodeConfig) *string { return &n.Username },
+	"password_file": func(n *RedisNodeConfig) *string { return &n.PasswordFile },
+	"ca_file":       func(n *RedisNodeConfig) *string { return &n.CAFile },
+	"cert_file":     func(n *RedisNodeConfig) *string { return &n.CertFile },
+	"key_file":      func(n *RedisNodeConfig) *string { return &n.KeyFile },
+	"server_name":   func(n *RedisNodeConfig) *string { return &n.ServerName },
+}
+
+// redisSchemes are the URL schemes understood by redlock.NewRedisNode
+var redisSchemes = map[string]bool{
+	"redis": true, "rediss": true,
+	"redis+sentinel": true, "rediss+sentinel": true,
+	"redis+cluster": true, "rediss+cluster": true,
+}
+
+// ParseRedisNode returns the settings of the node addr starting from defaults.
+// Settings given as URL query parameters override the defaults and are removed from the URL.
+func ParseRedisNode(addr string, defaults RedisNodeConfig) (RedisNodeConfig, error) {
+	node := defaults
+	node.URL = addr
+
+	u, err := url.Parse(addr)
+	if err != nil || u.RawQuery == "" {
+		return node, nil
+	}
+
+	for key, values := range u.Query() {
+		field, ok := redisNodeSettings[key]
+		if !ok {
+			return node, fmt.Errorf("unknown redis node setting %q", key)
+		}
+		*field(&node) = values[len(values)-1]
+	}
+
+	u.RawQuery = ""
+	node.URL = u.String()
+
+	return node, nil
+}
+
+// validate checks that the URL of the node is complete and its TLS settings are consistent
+func (n RedisNodeConfig) validate() error {
+	if n.URL == "" {
+		return fmt.Errorf("empty URL")
+	}
+
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL :: %v", err)
+	}
+
+	if !redisSchemes[u.Scheme] {
+		return fmt.Errorf("unknown URL scheme %q :: %s", u.Scheme, u.Redacted())
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host :: %s", u.Redacted())
+	}
+	if (n.CertFile == "") != (n.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together :: %s", u.Redacted())
+	}
+	if n.hasTLS() && !strings.HasPrefix(u.Scheme, "rediss") {
+		return fmt.Errorf("ca_file, cert_file, key_file and server_name need a rediss URL :: %s", u.Redacted())
+	}
+
+	return nil
+}
+
+// Redacted returns the URL of the node with its password masked, suitable for logs
+func (n RedisNodeConfig) Redacted() string {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return "<invalid URL>"
+	}
+
+	return u.Redacted()
+}
+
+// Password returns the content of the password file without surrounding whitespace
+func (n RedisNodeConfig) Password() (string, error) {
+	if n.PasswordFile == "" {
+		return "", nil
+	}
+
+	b, err := ioutil.ReadFile(n.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read redis password :: %s", err.Error())
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// hasTLS reports whether any TLS setting of the node is configured
+func (n RedisNodeConfig) hasTLS() bool {
+	return n.CAFile != "" || n.CertFile != "" || n.KeyFile != "" || n.ServerName != ""
+}
+
+// TLSConfig returns the TLS settings of the node or nil if none are configured
+func (n RedisNodeConfig) TLSConfig() (*tls.Config, error) {
+	if !n.hasTLS() {
+		return nil, nil
+	}
+
+	c := &tls.Config{ServerName: n.ServerName}
+
+	if n.CAFile != "" {
+		b, err := ioutil.ReadFile(n.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA bundle :: %s", err.Error())
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("failed to parse redis CA bundle :: file %s", n.CAFile)
+		}
+	}
+
+	if n.CertFile != "" || n.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(n.CertFile, n.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate :: %s", err.Error())
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
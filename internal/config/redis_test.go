@@ -0,0 +1,79 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRedisNode(t *testing.T) {
+	defaults := RedisNodeConfig{Username: "locker", CAFile: "/etc/ca.pem"}
+
+	node, err := ParseRedisNode("rediss://redis-1:6380/0?username=admin&password_file=/run/pw", defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, "rediss://redis-1:6380/0", node.URL, "settings should be removed from the URL")
+	assert.Equal(t, "admin", node.Username, "URL settings should override the defaults")
+	assert.Equal(t, "/run/pw", node.PasswordFile)
+	assert.Equal(t, "/etc/ca.pem", node.CAFile, "defaults should apply to unset settings")
+
+	node, err = ParseRedisNode("redis://redis-2:6379", defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, "redis://redis-2:6379", node.URL)
+	assert.Equal(t, "locker", node.Username)
+
+	_, err = ParseRedisNode("redis://redis-2:6379?password=plain", defaults)
+	assert.Error(t, err, "unknown settings should be rejected")
+}
+
+func TestRedisNodeConfig_Validate(t *testing.T) {
+	assert.NoError(t, RedisNodeConfig{URL: "rediss://redis-1:6380", CAFile: "/etc/ca.pem"}.validate())
+	assert.NoError(t, RedisNodeConfig{URL: "rediss+sentinel://s1:26379/mymaster", ServerName: "redis.internal"}.validate())
+	assert.NoError(t, RedisNodeConfig{URL: "redis://redis-1:6379", Username: "locker"}.validate())
+
+	for name, n := range map[string]RedisNodeConfig{
+		"ca file":      {URL: "redis://redis-1:6379", CAFile: "/etc/ca.pem"},
+		"client cert":  {URL: "redis+cluster://c1:7000", CertFile: "a.pem", KeyFile: "a.key"},
+		"server name":  {URL: "redis://redis-1:6379", ServerName: "redis.internal"},
+		"missing key":  {URL: "rediss://redis-1:6380", CertFile: "a.pem"},
+		"missing host": {URL: "rediss://"},
+	} {
+		assert.Error(t, n.validate(), name)
+	}
+}
+
+func TestRedisNodeConfig_Secrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	pw := filepath.Join(dir, "password")
+	ioutil.WriteFile(pw, []byte("secret\n"), 0600)
+	ca := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(ca, []byte("not a certificate"), 0600)
+
+	password, err := RedisNodeConfig{PasswordFile: pw}.Password()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", password, "trailing newlines should be trimmed")
+
+	c, err := RedisNodeConfig{}.TLSConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, c, "nodes without TLS settings should use the defaults")
+
+	c, err = RedisNodeConfig{ServerName: "redis.internal"}.TLSConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "redis.internal", c.ServerName)
+
+	_, err = RedisNodeConfig{CAFile: ca}.TLSConfig()
+	assert.Error(t, err, "invalid CA bundles should be rejected")
+}
+
+func TestGetEnvAsURLs(t *testing.T) {
+	os.Setenv("TEST_REDIS_CLIENTS", "redis://a:6379,redis+sentinel://s1:26379,s2:26379/mymaster,redis://b:6379")
+	defer os.Unsetenv("TEST_REDIS_CLIENTS")
+
+	assert.Equal(t, []string{"redis://a:6379", "redis+sentinel://s1:26379,s2:26379/mymaster", "redis://b:6379"}, getEnvAsURLs("TEST_REDIS_CLIENTS", nil))
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultTTL is the ttl of locks requested without one
+	DefaultTTL = 30 * time.Second
+
+	// DefaultMaxTTL is the highest ttl of locks unless configured otherwise
+	DefaultMaxTTL = 24 * time.Hour
+)
+
+// TTLBounds limits the ttl of locks, zero durations do not limit
+type TTLBounds struct {
+	// Min is the lowest ttl a lock may be acquired or refreshed with
+	Min time.Duration `yaml:"min"`
+	// Max is the highest ttl a lock may be acquired or refreshed with
+	Max time.Duration `yaml:"max"`
+	// Default replaces a ttl of 0, without a default the ttl is required
+	Default time.Duration `yaml:"default"`
+	// AllowNoExpiry permits locks which never expire, callers additionally need the no-expiry operation
+	AllowNoExpiry bool `yaml:"allow_no_expiry"`
+}
+
+// PrefixTTLConfig overrides the ttl bounds for resources starting with Prefix
+type PrefixTTLConfig struct {
+	Prefix    string `yaml:"prefix"`
+	TTLBounds `yaml:",inline"`
+}
+
+// TTLConfig holds the ttl bounds of all locks and the overrides of resource prefixes.
+// Prefixes inherit min, max and default from the top level, allow_no_expiry is never inherited.
+type TTLConfig struct {
+	TTLBounds `yaml:",inline"`
+	Prefixes  []PrefixTTLConfig `yaml:"prefixes"`
+}
+
+// Effective returns the bounds of every prefix with the unset values taken from the top level
+func (c TTLConfig) Effective() []PrefixTTLConfig {
+	bounds := []PrefixTTLConfig{{TTLBounds: c.TTLBounds}}
+
+	for _, p := range c.Prefixes {
+		if p.Min == 0 {
+			p.Min = c.Min
+		}
+		if p.Max == 0 {
+			p.Max = c.Max
+		}
+		if p.Default == 0 {
+			p.Default = c.Default
+		}
+		bounds = append(bounds, p)
+	}
+
+	return bounds
+}
+
+func (b TTLBounds) validate() error {
+	if b.Min < 0 || b.Max < 0 || b.Default < 0 {
+		return fmt.Errorf("min, max and default must not be negative")
+	}
+	if b.Max > 0 && b.Min > b.Max {
+		return fmt.Errorf("min %s exceeds max %s", b.Min, b.Max)
+	}
+	if b.Default > 0 && (b.Default < b.Min || (b.Max > 0 && b.Default > b.Max)) {
+		return fmt.Errorf("default %s is outside of [%s, %s]", b.Default, b.Min, b.Max)
+	}
+
+	return nil
+}
+
+func (c TTLConfig) validate() error {
+	seen := make(map[string]bool)
+
+	for i, p := range c.Effective() {
+		if i > 0 && (p.Prefix == "" || seen[p.Prefix]) {
+			return fmt.Errorf("invalid config :: ttl :: prefix %d must be unique and not empty", i)
+		}
+		seen[p.Prefix] = true
+
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("invalid config :: ttl :: prefix %q :: %v", p.Prefix, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const testTTLConfig = `
+redlock:
+  backend: memory
+ttl:
+  min: 5s
+  max: 10m
+  prefixes:
+    - prefix: batch/
+      max: 24h
+    - prefix: batch/pinned/
+      allow_no_expiry: true
+`
+
+func TestLoad_TTL(t *testing.T) {
+	c, err := Load(writeConfig(t, testTTLConfig))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 5*time.Second, c.TTL.Min)
+	assert.Equal(t, DefaultTTL, c.TTL.Default, "the default ttl should be kept")
+
+	bounds := c.TTL.Effective()
+	if assert.Len(t, bounds, 3) {
+		assert.Equal(t, TTLBounds{Min: 5 * time.Second, Max: 10 * time.Minute, Default: DefaultTTL}, bounds[0].TTLBounds)
+		assert.Equal(t, "batch/", bounds[1].Prefix)
+		assert.Equal(t, TTLBounds{Min: 5 * time.Second, Max: 24 * time.Hour, Default: DefaultTTL}, bounds[1].TTLBounds, "prefixes should inherit unset bounds")
+		assert.Equal(t, TTLBounds{Min: 5 * time.Second, Max: 10 * time.Minute, Default: DefaultTTL, AllowNoExpiry: true}, bounds[2].TTLBounds)
+	}
+}
+
+func TestLoad_TTLInvalid(t *testing.T) {
+	_, err := Load(writeConfig(t, "redlock:\n  backend: memory\nttl:\n  max: 1h\n"))
+	assert.NoError(t, err, "valid bounds should be accepted")
+
+	for name, content := range map[string]string{
+		"negative":         "ttl:\n  min: -1s\n",
+		"min above max":    "ttl:\n  min: 1m\n  max: 30s\n  default: 45s\n",
+		"default above":    "ttl:\n  max: 10s\n",
+		"default below":    "ttl:\n  min: 1m\n",
+		"empty prefix":     "ttl:\n  prefixes:\n    - max: 1h\n",
+		"duplicate prefix": "ttl:\n  prefixes:\n    - prefix: a/\n    - prefix: a/\n",
+		"prefix bounds":    "ttl:\n  prefixes:\n    - prefix: a/\n      max: 1s\n",
+	} {
+		_, err := Load(writeConfig(t, "redlock:\n  backend: memory\n"+content))
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,358 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefix is the path every lock resource is served under
+const Prefix = "/v1/locks/"
+
+// full grpc method names of the lock service, requests pass the interceptors under these names
+const (
+	methodGet          = "/lock.v1.LockService/GetLock"
+	methodRefresh      = "/lock.v1.LockService/RefreshLock"
+	methodDelete       = "/lock.v1.LockService/DeleteLock"
+	methodCheck        = "/lock.v1.LockService/CheckLock"
+	methodForceRelease = "/lock.v1.LockService/ForceRelease"
+	methodTakeOver     = "/lock.v1.LockService/TakeOver"
+	methodList         = "/lock.v1.LockService/ListLocks"
+)
+
+// maxBodySize limits the size of request bodies
+const maxBodySize = 64 << 10
+
+var (
+	marshaler   = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+	unmarshaler = protojson.UnmarshalOptions{}
+)
+
+// httpStatus maps grpc codes to the HTTP status answered by the gateway
+var httpStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusConflict,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+type method func(context.Context, *pb.LockRequest) (*pb.LockResponse, error)
+
+// Handler serves the lock service as JSON over HTTP:
+//
+//	GET    /v1/locks/{resource}                       CheckLock
+//	PUT    /v1/locks/{resource}  {"lock_id", "ttl"}   GetLock, TakeOver with ?takeover=true
+//	PATCH  /v1/locks/{resource}  {"lock_id", "ttl"}   RefreshLock
+//	DELETE /v1/locks/{resource}?lock_id=...           DeleteLock, ForceRelease with ?force=true
+//	GET    /v1/locks?prefix=...                       ListLocks
+//
+// Every request passes the interceptors like a grpc call of the method, so authorization and limits
+// apply unchanged. Errors are answered with the HTTP status of their grpc code and the grpc status as body.
+// Only the headers in ForwardedHeaders and those added with ForwardHeaders are passed on as grpc metadata.
+type Handler struct {
+	methods     map[string]method
+	list        func(context.Context, *pb.ListRequest) (*pb.ListResponse, error)
+	interceptor grpc.UnaryServerInterceptor
+	headers     map[string]bool
+}
+
+// ForwardedHeaders are the headers every Handler passes on as grpc metadata
+var ForwardedHeaders = []string{"Correlation-Id"}
+
+// NewHandler returns a Handler calling server through the given interceptors, which run in order
+func NewHandler(server pb.LockServiceServer, interceptors ...grpc.UnaryServerInterceptor) *Handler {
+	h := &Handler{
+		methods: map[string]method{
+			methodGet:          server.GetLock,
+			methodRefresh:      server.RefreshLock,
+			methodDelete:       server.DeleteLock,
+			methodCheck:        server.CheckLock,
+			methodForceRelease: server.ForceRelease,
+			methodTakeOver:     server.TakeOver,
+		},
+		list:        server.ListLocks,
+		interceptor: chain(interceptors),
+	}
+
+	return h.ForwardHeaders(ForwardedHeaders...)
+}
+
+// ForwardHeaders adds headers which are passed on as grpc metadata, e.g. the key of an auth.MetadataResolver
+func (h *Handler) ForwardHeaders(keys ...string) *Handler {
+	if h.headers == nil {
+		h.headers = map[string]bool{}
+	}
+
+	for _, k := range keys {
+		h.headers[http.CanonicalHeaderKey(k)] = true
+	}
+
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == Prefix || r.URL.Path+"/" == Prefix {
+		h.serveList(w, r)
+		return
+	}
+
+	resource := strings.TrimPrefix(r.URL.Path, Prefix)
+
+	if !strings.HasPrefix(r.URL.Path, Prefix) {
+		writeError(w, status.Errorf(codes.NotFound, "unknown path %s", r.URL.Path))
+		return
+	}
+
+	name, err := route(r)
+	if err != nil {
+		w.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		writeMessage(w, http.StatusMethodNotAllowed, status.Convert(err).Proto())
+		return
+	}
+
+	req, err := decodeRequest(w, r, resource)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	call := h.methods[name]
+
+	h.invoke(w, r, name, req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return call(ctx, req.(*pb.LockRequest))
+	})
+}
+
+// serveList answers GET /v1/locks?prefix=... with ListLocks
+func (h *Handler) serveList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeMessage(w, http.StatusMethodNotAllowed, status.Newf(codes.Unimplemented, "method %s is not supported", r.Method).Proto())
+		return
+	}
+
+	req := &pb.ListRequest{Prefix: r.URL.Query().Get("prefix")}
+
+	h.invoke(w, r, methodList, req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return h.list(ctx, req.(*pb.ListRequest))
+	})
+}
+
+// invoke calls the grpc method name through the interceptors and writes its response
+func (h *Handler) invoke(w http.ResponseWriter, r *http.Request, name string, req proto.Message, call grpc.UnaryHandler) {
+	stream := &transportStream{method: name}
+	ctx := grpc.NewContextWithServerTransportStream(h.incomingContext(r), stream)
+
+	res, err := h.interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: name}, call)
+
+	stream.writeHeader(w)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, http.StatusOK, res.(proto.Message))
+}
+
+// route returns the grpc method of a request
+func route(r *http.Request) (string, error) {
+	q := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		return methodCheck, nil
+	case http.MethodPut:
+		if isSet(q.Get("takeover")) {
+			return methodTakeOver, nil
+		}
+		return methodGet, nil
+	case http.MethodPatch:
+		return methodRefresh, nil
+	case http.MethodDelete:
+		if isSet(q.Get("force")) {
+			return methodForceRelease, nil
+		}
+		return methodDelete, nil
+	}
+
+	return "", status.Errorf(codes.Unimplemented, "method %s is not supported", r.Method)
+}
+
+func isSet(v string) bool {
+	b, err := strconv.ParseBool(v)
+	return err == nil && b
+}
+
+// decodeRequest reads the lock request from the JSON body and the lock_id, ttl and try_once query parameters,
+// the resource is always taken from the path
+func decodeRequest(w http.ResponseWriter, r *http.Request, resource string) (*pb.LockRequest, error) {
+	req := &pb.LockRequest{}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to read body :: %v", err)
+	}
+
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := unmarshaler.Unmarshal(body, req); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid body :: %v", err)
+		}
+	}
+
+	q := r.URL.Query()
+
+	if id := q.Get("lock_id"); id != "" {
+		req.LockId = id
+	}
+
+	if v := q.Get("ttl"); v != "" {
+		ttl, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid ttl %q", v)
+		}
+		req.Ttl = uint32(ttl)
+	}
+
+	if isSet(q.Get("try_once")) {
+		req.TryOnce = true
+	}
+
+	req.ResourceId = resource
+
+	return req, nil
+}
+
+// incomingContext returns the request context carrying the forwarded headers as grpc metadata and the
+// connection as grpc peer, so resolvers and loggers see the same values as for grpc calls
+func (h *Handler) incomingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for k, v := range r.Header {
+		if h.headers[k] {
+			md.Append(strings.ToLower(k), v...)
+		}
+	}
+
+	p := &peer.Peer{Addr: remoteAddr(r.RemoteAddr)}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{
+			State:          *r.TLS,
+			CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		}
+	}
+
+	return peer.NewContext(metadata.NewIncomingContext(r.Context(), md), p)
+}
+
+// remoteAddr is the address of an HTTP client
+type remoteAddr string
+
+func (a remoteAddr) Network() string { return "tcp" }
+func (a remoteAddr) String() string  { return string(a) }
+
+// transportStream collects the headers and trailers set by interceptors and handlers,
+// both are sent as HTTP headers
+type transportStream struct {
+	method string
+	mu     sync.Mutex
+	md     metadata.MD
+}
+
+func (s *transportStream) Method() string {
+	return s.method
+}
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.md = metadata.Join(s.md, md)
+	return nil
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *transportStream) writeHeader(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.md {
+		for _, value := range v {
+			w.Header().Add(k, value)
+		}
+	}
+}
+
+// chain returns an interceptor running interceptors in order, the last one calls the handler
+func chain(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, wrapped := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, wrapped)
+			}
+		}
+
+		return next(ctx, req)
+	}
+}
+
+// HTTPStatus returns the HTTP status the gateway answers the grpc code c with
+func HTTPStatus(c codes.Code) int {
+	if s, ok := httpStatus[c]; ok {
+		return s
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	writeMessage(w, HTTPStatus(st.Code()), st.Proto())
+}
+
+func writeMessage(w http.ResponseWriter, code int, m proto.Message) {
+	body, err := marshaler.Marshal(m)
+	if err != nil {
+		code = http.StatusInternalServerError
+		body = []byte(fmt.Sprintf(`{"code":%d,"message":"failed to encode response"}`, codes.Internal))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
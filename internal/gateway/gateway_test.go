@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"encoding/json"
+	"github.com/stoex/go-lock/internal/auth"
+	"github.com/stoex/go-lock/internal/ratelimit"
+	"github.com/stoex/go-lock/internal/service"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+default: allow
+rules:
+  - effect: allow
+    principals: [billing]
+    resources: ["invoice/*"]
+    operations: ["*"]
+  - effect: deny
+    principals: ["*"]
+    resources: ["invoice/*"]
+    operations: [get, refresh, delete]
+`
+
+const testLimits = `
+max_locks_per_caller: 1
+`
+
+type testResponse struct {
+	Status     string `json:"status"`
+	ResourceID string `json:"resource_id"`
+	LockID     string `json:"lock_id"`
+	TTL        uint32 `json:"ttl"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+
+	Locks []testResponse `json:"locks"`
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	svc, err := service.NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+
+	policy, err := auth.ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("could not parse policy: %s", err.Error())
+	}
+
+	limits, err := ratelimit.ParseConfig([]byte(testLimits))
+	if err != nil {
+		t.Fatalf("could not parse limits: %s", err.Error())
+	}
+
+	h := NewHandler(svc,
+		auth.UnaryServerInterceptor(auth.NewEngine(policy), auth.MetadataResolver("x-principal")),
+		ratelimit.UnaryServerInterceptor(ratelimit.NewLimiter(limits)),
+	).ForwardHeaders("x-principal")
+
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func do(t *testing.T, s *httptest.Server, method string, path string, principal string, body string) (*http.Response, testResponse) {
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not create request: %s", err.Error())
+	}
+	req.Header.Set("X-Principal", principal)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	data, _ := ioutil.ReadAll(res.Body)
+
+	var r testResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("invalid response body %q: %s", data, err.Error())
+	}
+
+	return res, r
+}
+
+func TestHandler_Lifecycle(t *testing.T) {
+	s := newTestServer(t)
+
+	res, r := do(t, s, http.MethodPut, Prefix+"invoice/42", "billing", `{"lock_id": "a", "ttl": 30}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, "OK", r.Status)
+	assert.Equal(t, "invoice/42", r.ResourceID, "the resource should be taken from the path")
+	assert.Equal(t, "a", r.LockID)
+	assert.InDelta(t, 30, r.TTL, 1)
+
+	res, r = do(t, s, http.MethodGet, Prefix+"invoice/42", "billing", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "a", r.LockID)
+
+	res, _ = do(t, s, http.MethodPatch, Prefix+"invoice/42?lock_id=a&ttl=60", "billing", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode, "lock id and ttl should be read from the query")
+
+	res, _ = do(t, s, http.MethodPatch, Prefix+"invoice/42", "billing", `{"lock_id": "b", "ttl": 60}`)
+	assert.Equal(t, http.StatusConflict, res.StatusCode, "refreshing a lock held by another lock id should conflict")
+
+	res, _ = do(t, s, http.MethodDelete, Prefix+"invoice/42?lock_id=a", "billing", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, _ = do(t, s, http.MethodGet, Prefix+"invoice/42", "billing", "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode, "released locks should not be found")
+}
+
+func TestHandler_TryOnce(t *testing.T) {
+	s := newTestServer(t)
+
+	res, _ := do(t, s, http.MethodPut, Prefix+"parcel/1?lock_id=a&ttl=30", "shipping", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	start := time.Now()
+	res, _ = do(t, s, http.MethodPut, Prefix+"parcel/1?lock_id=b&ttl=30&try_once=true", "billing", "")
+	assert.Equal(t, http.StatusConflict, res.StatusCode, "held locks should not be acquired")
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond), "try_once should not retry")
+}
+
+func TestHandler_List(t *testing.T) {
+	s := newTestServer(t)
+
+	do(t, s, http.MethodPut, Prefix+"invoice/2", "billing", `{"lock_id": "a", "ttl": 30}`)
+	do(t, s, http.MethodPut, Prefix+"parcel/1", "shipping", `{"lock_id": "b", "ttl": 30}`)
+
+	res, r := do(t, s, http.MethodGet, "/v1/locks", "", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, r.Locks, 2, "all locks should be listed without a prefix")
+
+	res, r = do(t, s, http.MethodGet, Prefix+"?prefix=invoice/", "", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	if assert.Len(t, r.Locks, 1) {
+		assert.Equal(t, "invoice/2", r.Locks[0].ResourceID)
+		assert.Equal(t, "a", r.Locks[0].LockID)
+	}
+
+	res, _ = do(t, s, http.MethodDelete, "/v1/locks", "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestHandler_Authorization(t *testing.T) {
+	s := newTestServer(t)
+
+	res, r := do(t, s, http.MethodPut, Prefix+"invoice/42", "shipping", `{"lock_id": "a", "ttl": 30}`)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "the policy should apply")
+	assert.Equal(t, int(codes.PermissionDenied), r.Code)
+	assert.Contains(t, r.Message, "shipping may not get invoice/42")
+
+	res, _ = do(t, s, http.MethodDelete, Prefix+"parcel/1?force=true", "shipping", "")
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "admin operations should need an explicit rule")
+}
+
+func TestHandler_RateLimit(t *testing.T) {
+	s := newTestServer(t)
+
+	res, _ := do(t, s, http.MethodPut, Prefix+"parcel/1", "shipping", `{"lock_id": "a", "ttl": 30}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, r := do(t, s, http.MethodPut, Prefix+"parcel/2", "shipping", `{"lock_id": "a", "ttl": 30}`)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode, "the lock quota should apply")
+	assert.Equal(t, int(codes.ResourceExhausted), r.Code)
+	assert.NotEmpty(t, res.Header.Get("Retry-After"), "the retry-after trailer should be sent as header")
+}
+
+func TestHandler_InvalidRequests(t *testing.T) {
+	s := newTestServer(t)
+
+	res, _ := do(t, s, http.MethodPut, Prefix+"parcel/1", "", `{"lock_id": `)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "invalid bodies should be rejected")
+
+	res, _ = do(t, s, http.MethodPut, Prefix+"parcel/1?ttl=-1", "", "")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "invalid ttls should be rejected")
+
+	res, _ = do(t, s, http.MethodPut, Prefix+"parcel/1", "", `{"lock_id": "a", "ttl": 30, "try_once": "yes"}`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "invalid fields should be rejected")
+
+	res, _ = do(t, s, http.MethodPost, Prefix+"parcel/1", "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+
+	res, _ = do(t, s, http.MethodGet, "/v2/locks/parcel/1", "", "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestHandler_ForwardedHeaders(t *testing.T) {
+	svc, err := service.NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	h := NewHandler(svc).ForwardHeaders("x-principal")
+
+	r := httptest.NewRequest(http.MethodGet, Prefix+"invoice/42", nil)
+	r.Header.Set("Correlation-Id", "deploy-42")
+	r.Header.Set("X-Principal", "billing")
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Cookie", "session=secret")
+
+	md, _ := metadata.FromIncomingContext(h.incomingContext(r))
+
+	assert.Equal(t, []string{"deploy-42"}, md.Get("correlation-id"), "correlation ids should be forwarded by default")
+	assert.Equal(t, []string{"billing"}, md.Get("x-principal"), "added headers should be forwarded")
+	assert.Empty(t, md.Get("authorization"), "other headers should not be forwarded")
+	assert.Empty(t, md.Get("cookie"), "other headers should not be forwarded")
+}
+
+func TestHTTPStatus(t *testing.T) {
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(codes.Unavailable), "draining servers should answer 503")
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(codes.Code(42)), "unknown codes should answer 500")
+}
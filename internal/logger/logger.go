@@ -25,6 +25,11 @@ func init() {
 	}
 }
 
+// SetLevel changes the minimum level of logged statements, it is safe to call at any time
+func SetLevel(level string) error {
+	return config.Level.UnmarshalText([]byte(level))
+}
+
 func getHeaders(ctx context.Context) *metadata.MD {
 	headers, ok := metadata.FromIncomingContext(ctx)
 
@@ -35,7 +40,8 @@ func getHeaders(ctx context.Context) *metadata.MD {
 	return &headers
 }
 
-func getCorrelationID(ctx context.Context) string {
+// CorrelationID returns the correlation id sent by the caller in the request metadata
+func CorrelationID(ctx context.Context) string {
 	md := getHeaders(ctx)
 
 	if md == nil {
@@ -52,7 +58,7 @@ func getCorrelationID(ctx context.Context) string {
 }
 
 func log(ctx context.Context, lvl int, msg string, tags ...zap.Field) {
-	correlationID := getCorrelationID(ctx)
+	correlationID := CorrelationID(ctx)
 	logFields := append(tags, zap.String("correlation-id", correlationID))
 
 	switch lvl {
@@ -63,7 +69,7 @@ func log(ctx context.Context, lvl int, msg string, tags ...zap.Field) {
 	case _error:
 		logger.Error(msg, logFields...)
 	case _debug:
-		logger.Error(msg, logFields...)
+		logger.Debug(msg, logFields...)
 	case _panic:
 		logger.Panic(msg, logFields...)
 	}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+)
+
+// Limit describes a token bucket refilled with Rate tokens per second holding at most Burst tokens
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// PrefixLimit limits all requests for resources starting with Prefix, regardless of the caller
+type PrefixLimit struct {
+	Prefix string `yaml:"prefix"`
+	Limit  `yaml:",inline"`
+}
+
+// Config holds the rate limits and quotas enforced per caller and per resource prefix.
+// A zero limit or quota disables the respective check.
+type Config struct {
+	PerCaller         Limit         `yaml:"per_caller"`
+	Prefixes          []PrefixLimit `yaml:"prefixes"`
+	MaxLocksPerCaller int           `yaml:"max_locks_per_caller"`
+}
+
+// LoadConfig reads and validates a limits config from a yaml file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates a yaml encoded limits config
+func ParseConfig(data []byte) (*Config, error) {
+	c := Config{}
+
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid limits: %v", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Validate checks that all limits and quotas are consistent
+func (c *Config) Validate() error {
+	if err := c.PerCaller.validate(); err != nil {
+		return fmt.Errorf("invalid limits :: per caller :: %v", err)
+	}
+
+	for i, p := range c.Prefixes {
+		if p.Prefix == "" {
+			return fmt.Errorf("invalid limits :: prefix %d :: prefix is required", i)
+		}
+		if err := p.Limit.validate(); err != nil {
+			return fmt.Errorf("invalid limits :: prefix %s :: %v", p.Prefix, err)
+		}
+		if !p.Limit.enabled() {
+			return fmt.Errorf("invalid limits :: prefix %s :: rate is required", p.Prefix)
+		}
+	}
+
+	if c.MaxLocksPerCaller < 0 {
+		return fmt.Errorf("invalid limits :: max locks per caller must not be negative")
+	}
+
+	return nil
+}
+
+func (l Limit) validate() error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return fmt.Errorf("rate and burst must not be negative")
+	}
+	if l.Rate > 0 && l.Burst == 0 {
+		return fmt.Errorf("burst must be at least 1 if a rate is set")
+	}
+	return nil
+}
+
+func (l Limit) enabled() bool {
+	return l.Rate > 0
+}
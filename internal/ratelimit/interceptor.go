@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/stoex/go-lock/internal/auth"
+	"github.com/stoex/go-lock/internal/logger"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	methodGet          = "/lock.v1.LockService/GetLock"
+	methodRefresh      = "/lock.v1.LockService/RefreshLock"
+	methodDelete       = "/lock.v1.LockService/DeleteLock"
+	methodForceRelease = "/lock.v1.LockService/ForceRelease"
+	methodTakeOver     = "/lock.v1.LockService/TakeOver"
+
+	// the unversioned API served alongside lock.v1
+	legacyMethodGet          = "/lock.Lock/GetLock"
+	legacyMethodRefresh      = "/lock.Lock/RefreshLock"
+	legacyMethodDelete       = "/lock.Lock/DeleteLock"
+	legacyMethodForceRelease = "/lock.Lock/ForceRelease"
+	legacyMethodTakeOver     = "/lock.Lock/TakeOver"
+)
+
+type lockRequest interface {
+	GetResourceId() string
+	GetTtl() uint32
+}
+
+// lockResponse carries the ttl granted by the service
+type lockResponse interface {
+	GetTtl() uint32
+}
+
+// UnaryServerInterceptor rejects requests exceeding the rate limits or lock quota of the caller
+// with RESOURCE_EXHAUSTED. The caller is taken from the principal stored by the auth interceptor,
+// so it has to run after it, see callerKey. The retry delay is sent as RetryInfo detail and `retry-after` trailer.
+func UnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r, ok := req.(lockRequest)
+
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		caller := callerKey(ctx)
+
+		if ok, wait := l.Allow(caller, r.GetResourceId()); !ok {
+			logger.Warn(ctx, fmt.Sprintf("-> rate limited :: principal %s :: resource %s :: retry after %s", caller, r.GetResourceId(), wait))
+			return nil, exhausted(ctx, wait, "rate limit exceeded for %s", caller)
+		}
+
+		var reservation *Reservation
+		switch info.FullMethod {
+		case methodGet, methodRefresh, legacyMethodGet, legacyMethodRefresh:
+			var wait time.Duration
+			if reservation, wait = l.Reserve(caller, r.GetResourceId()); reservation == nil {
+				logger.Warn(ctx, fmt.Sprintf("-> quota exceeded :: principal %s :: resource %s :: retry after %s", caller, r.GetResourceId(), wait))
+				return nil, exhausted(ctx, wait, "%s holds too many locks", caller)
+			}
+		}
+
+		res, err := handler(ctx, req)
+
+		if err != nil {
+			if reservation != nil {
+				reservation.Cancel()
+			}
+			return res, err
+		}
+
+		switch info.FullMethod {
+		case methodGet, methodRefresh, legacyMethodGet, legacyMethodRefresh:
+			ttl := r.GetTtl()
+			if granted, ok := res.(lockResponse); ok {
+				ttl = granted.GetTtl()
+			}
+			reservation.Acquired(r.GetResourceId(), time.Duration(ttl)*time.Second)
+		// the caller of a take over is not the new owner, the lock counts for whoever refreshes it next
+		case methodDelete, methodForceRelease, methodTakeOver, legacyMethodDelete, legacyMethodForceRelease, legacyMethodTakeOver:
+			l.Released(r.GetResourceId())
+		}
+
+		return res, nil
+	}
+}
+
+// callerKey returns the principal the limits of a request are kept for. Anonymous callers are told apart
+// by the host of their peer address, callers without one share a single anonymous bucket and quota.
+func callerKey(ctx context.Context) string {
+	caller := auth.FromContext(ctx)
+
+	if caller != auth.Anonymous {
+		return caller
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return caller
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil || host == "" {
+		return caller
+	}
+
+	return caller + "@" + host
+}
+
+// exhausted returns a RESOURCE_EXHAUSTED error telling the caller to retry after wait, the retry-after
+// trailer is rounded up to at least a second so it never asks for an immediate retry
+func exhausted(ctx context.Context, wait time.Duration, format string, args ...interface{}) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	_ = grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(seconds)))
+
+	st := status.Newf(codes.ResourceExhausted, format, args...)
+
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		st = detailed
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,289 @@
+package ratelimit
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the number of caller buckets kept before refilled buckets are dropped
+const maxIdleBuckets = 10000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last refill and returns the time until the bucket holds a token,
+// 0 if it holds one
+func (b *bucket) refill(l Limit, now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * l.Rate
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+func (b *bucket) full(l Limit, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*l.Rate >= float64(l.Burst)
+}
+
+// Limiter enforces the request rate per caller and per resource prefix
+// and tracks the locks held by every caller through this server.
+type Limiter struct {
+	mu       sync.Mutex
+	config   *Config
+	callers  map[string]*bucket
+	prefixes map[string]*bucket
+	held     map[string]map[string]time.Time
+	owners   map[string]string
+	pending  map[string]int
+	now      func() time.Time
+}
+
+// NewLimiter returns a pointer to a Limiter enforcing the given config
+func NewLimiter(c *Config) *Limiter {
+	return &Limiter{
+		config:   c,
+		callers:  make(map[string]*bucket),
+		prefixes: make(map[string]*bucket),
+		held:     make(map[string]map[string]time.Time),
+		owners:   make(map[string]string),
+		pending:  make(map[string]int),
+		now:      time.Now,
+	}
+}
+
+// Allow takes a token from the caller and resource prefix buckets if both hold one, a rejected request
+// takes no token. If the request is rejected the duration after which it may be retried is returned.
+func (l *Limiter) Allow(caller string, resource string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var buckets []*bucket
+	var wait time.Duration
+
+	if l.config.PerCaller.enabled() {
+		if len(l.callers) > maxIdleBuckets {
+			l.dropFullBuckets(now)
+		}
+		b, ok := l.callers[caller]
+		if !ok {
+			b = &bucket{tokens: float64(l.config.PerCaller.Burst), last: now}
+			l.callers[caller] = b
+		}
+		if d := b.refill(l.config.PerCaller, now); d > wait {
+			wait = d
+		}
+		buckets = append(buckets, b)
+	}
+
+	if p, ok := l.prefixLimit(resource); ok {
+		b, ok := l.prefixes[p.Prefix]
+		if !ok {
+			b = &bucket{tokens: float64(p.Burst), last: now}
+			l.prefixes[p.Prefix] = b
+		}
+		if d := b.refill(p.Limit, now); d > wait {
+			wait = d
+		}
+		buckets = append(buckets, b)
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return true, 0
+}
+
+// SetConfig replaces the enforced limits. Buckets of limits which are still set keep their tokens,
+// so a reload does not refill them, and are capped at the new burst when taken from next.
+// Held locks are only tracked while a quota is set, so a newly enabled quota starts counting from zero.
+func (l *Limiter) SetConfig(c *Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.config = c
+
+	if !c.PerCaller.enabled() {
+		l.callers = make(map[string]*bucket)
+	}
+
+	prefixes := make(map[string]bool)
+	for _, p := range c.Prefixes {
+		prefixes[p.Prefix] = true
+	}
+	for prefix := range l.prefixes {
+		if !prefixes[prefix] {
+			delete(l.prefixes, prefix)
+		}
+	}
+
+	if c.MaxLocksPerCaller == 0 {
+		l.held = make(map[string]map[string]time.Time)
+		l.owners = make(map[string]string)
+	}
+}
+
+// Reservation is a slot of the lock quota of a caller taken for an acquisition in flight.
+// It has to be given back with Acquired or Cancel once the acquisition finished.
+type Reservation struct {
+	l       *Limiter
+	caller  string
+	counted bool
+}
+
+// Reserve takes a slot of the lock quota of the caller for a lock on resource, so concurrent acquisitions can not
+// exceed it. A lock the caller already holds on resource keeps its slot. If the caller is at its quota nil is returned
+// with the duration until the first of its locks expires, which is 0 if all of them are held until released.
+func (l *Limiter) Reserve(caller string, resource string) (*Reservation, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.MaxLocksPerCaller == 0 {
+		return &Reservation{l: l, caller: caller}, 0
+	}
+
+	now := l.now()
+	locks := l.held[caller]
+	var next time.Duration
+
+	for held, expiry := range locks {
+		if expiry.IsZero() {
+			continue
+		}
+		if !expiry.After(now) {
+			l.forget(held)
+			continue
+		}
+		if wait := expiry.Sub(now); next == 0 || wait < next {
+			next = wait
+		}
+	}
+
+	if _, ok := l.held[caller][resource]; ok {
+		return &Reservation{l: l, caller: caller}, 0
+	}
+
+	if len(l.held[caller])+l.pending[caller] >= l.config.MaxLocksPerCaller {
+		return nil, next
+	}
+
+	l.pending[caller]++
+
+	return &Reservation{l: l, caller: caller, counted: true}, 0
+}
+
+// Acquired gives back the slot and records the lock on resource like Limiter.Acquired
+func (r *Reservation) Acquired(resource string, ttl time.Duration) {
+	r.l.mu.Lock()
+	defer r.l.mu.Unlock()
+
+	r.release()
+	r.l.acquired(r.caller, resource, ttl)
+}
+
+// Cancel gives back the slot of an acquisition which failed
+func (r *Reservation) Cancel() {
+	r.l.mu.Lock()
+	defer r.l.mu.Unlock()
+
+	r.release()
+}
+
+func (r *Reservation) release() {
+	if !r.counted {
+		return
+	}
+	r.counted = false
+
+	if r.l.pending[r.caller] <= 1 {
+		delete(r.l.pending, r.caller)
+	} else {
+		r.l.pending[r.caller]--
+	}
+}
+
+// Acquired records a lock held by caller until ttl passes, a ttl of 0 is held until released
+func (l *Limiter) Acquired(caller string, resource string, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.acquired(caller, resource, ttl)
+}
+
+func (l *Limiter) acquired(caller string, resource string, ttl time.Duration) {
+	l.forget(resource)
+
+	if l.config.MaxLocksPerCaller == 0 {
+		return
+	}
+
+	if l.held[caller] == nil {
+		l.held[caller] = make(map[string]time.Time)
+	}
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = l.now().Add(ttl)
+	}
+	l.held[caller][resource] = expiry
+	l.owners[resource] = caller
+}
+
+// Released forgets a lock no matter who held it
+func (l *Limiter) Released(resource string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.forget(resource)
+}
+
+func (l *Limiter) forget(resource string) {
+	caller, ok := l.owners[resource]
+
+	if !ok {
+		return
+	}
+
+	delete(l.owners, resource)
+	delete(l.held[caller], resource)
+
+	if len(l.held[caller]) == 0 {
+		delete(l.held, caller)
+	}
+}
+
+// prefixLimit returns the limit with the longest prefix matching resource
+func (l *Limiter) prefixLimit(resource string) (PrefixLimit, bool) {
+	var found PrefixLimit
+	ok := false
+
+	for _, p := range l.config.Prefixes {
+		if strings.HasPrefix(resource, p.Prefix) && len(p.Prefix) > len(found.Prefix) {
+			found = p
+			ok = true
+		}
+	}
+
+	return found, ok
+}
+
+func (l *Limiter) dropFullBuckets(now time.Time) {
+	for caller, b := range l.callers {
+		if b.full(l.config.PerCaller, now) {
+			delete(l.callers, caller)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stoex/go-lock/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+const testLimits = `
+per_caller:
+  rate: 1
+  burst: 2
+prefixes:
+  - prefix: "invoice/"
+    rate: 10
+    burst: 1
+max_locks_per_caller: 1
+`
+
+type testRequest struct {
+	resource string
+	ttl      uint32
+}
+
+func (r *testRequest) GetResourceId() string {
+	return r.resource
+}
+
+func (r *testRequest) GetTtl() uint32 {
+	return r.ttl
+}
+
+func newTestLimiter(t *testing.T) (*Limiter, *time.Time) {
+	c, err := ParseConfig([]byte(testLimits))
+	if err != nil {
+		t.Fatalf("could not parse limits: %s", err.Error())
+	}
+	now := time.Unix(0, 0)
+	l := NewLimiter(c)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiter_AllowPerCaller(t *testing.T) {
+	l, now := newTestLimiter(t)
+
+	ok, _ := l.Allow("a", "parcel/1")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a", "parcel/1")
+	assert.True(t, ok, "burst should allow a second request")
+	ok, wait := l.Allow("a", "parcel/1")
+	assert.False(t, ok, "third request should be limited")
+	assert.Equal(t, time.Second, wait, "a token is refilled after a second")
+
+	ok, _ = l.Allow("b", "parcel/1")
+	assert.True(t, ok, "other callers should not be affected")
+
+	*now = now.Add(time.Second)
+	ok, _ = l.Allow("a", "parcel/1")
+	assert.True(t, ok, "a refilled token should be usable")
+}
+
+func TestLimiter_AllowPrefixRejected(t *testing.T) {
+	l, _ := newTestLimiter(t)
+
+	ok, _ := l.Allow("a", "invoice/1")
+	assert.True(t, ok)
+	ok, _ = l.Allow("b", "invoice/2")
+	assert.False(t, ok, "the prefix limit should reject")
+	assert.Equal(t, float64(2), l.callers["b"].tokens, "a request rejected by the prefix limit should not take a caller token")
+
+	ok, _ = l.Allow("b", "parcel/1")
+	assert.True(t, ok)
+	ok, _ = l.Allow("b", "parcel/2")
+	assert.True(t, ok, "the caller should keep its burst")
+}
+
+func TestLimiter_AllowPerPrefix(t *testing.T) {
+	l, _ := newTestLimiter(t)
+
+	ok, _ := l.Allow("a", "invoice/1")
+	assert.True(t, ok)
+	ok, wait := l.Allow("b", "invoice/2")
+	assert.False(t, ok, "prefix limits apply across callers")
+	assert.Equal(t, 100*time.Millisecond, wait)
+}
+
+// canAcquire reports whether caller may acquire a lock without keeping the reservation
+func canAcquire(l *Limiter, caller string) (bool, time.Duration) {
+	r, wait := l.Reserve(caller, "")
+	if r == nil {
+		return false, wait
+	}
+	r.Cancel()
+	return true, 0
+}
+
+func TestLimiter_Reserve(t *testing.T) {
+	l, now := newTestLimiter(t)
+
+	ok, _ := canAcquire(l, "a")
+	assert.True(t, ok)
+
+	l.Acquired("a", "parcel/1", 10*time.Second)
+	ok, wait := canAcquire(l, "a")
+	assert.False(t, ok, "quota should be exhausted")
+	assert.Equal(t, 10*time.Second, wait)
+
+	l.Released("parcel/1")
+	ok, _ = canAcquire(l, "a")
+	assert.True(t, ok, "released locks should not count")
+
+	l.Acquired("a", "parcel/1", 10*time.Second)
+	*now = now.Add(10 * time.Second)
+	ok, _ = canAcquire(l, "a")
+	assert.True(t, ok, "expired locks should not count")
+
+	l.Acquired("a", "pinned/1", 0)
+	*now = now.Add(24 * time.Hour)
+	ok, wait = canAcquire(l, "a")
+	assert.False(t, ok, "locks without expiry should count until released")
+	assert.Equal(t, time.Duration(0), wait)
+
+	l.Released("pinned/1")
+	ok, _ = canAcquire(l, "a")
+	assert.True(t, ok, "released locks without expiry should not count")
+}
+
+func TestLimiter_ReservePending(t *testing.T) {
+	l, _ := newTestLimiter(t)
+
+	r, _ := l.Reserve("a", "parcel/1")
+	if !assert.NotNil(t, r) {
+		return
+	}
+	ok, _ := canAcquire(l, "a")
+	assert.False(t, ok, "acquisitions in flight should count toward the quota")
+
+	r.Cancel()
+	r.Cancel()
+	ok, _ = canAcquire(l, "a")
+	assert.True(t, ok, "cancelled reservations should give back their slot once")
+
+	r, _ = l.Reserve("a", "parcel/1")
+	r.Acquired("parcel/1", 10*time.Second)
+	ok, _ = canAcquire(l, "a")
+	assert.False(t, ok, "the slot should be kept by the acquired lock")
+	assert.Empty(t, l.pending, "acquired reservations should not stay pending")
+}
+
+func TestLimiter_SetConfig(t *testing.T) {
+	l, now := newTestLimiter(t)
+
+	l.Allow("a", "parcel/1")
+	l.Allow("a", "parcel/1")
+	ok, _ := l.Allow("a", "parcel/1")
+	assert.False(t, ok, "burst should be exhausted")
+
+	l.SetConfig(l.config)
+	ok, _ = l.Allow("a", "parcel/1")
+	assert.False(t, ok, "reloading should not refill the buckets")
+
+	ok, _ = l.Allow("b", "invoice/1")
+	assert.True(t, ok)
+	l.SetConfig(&Config{PerCaller: Limit{Rate: 1, Burst: 2}, Prefixes: []PrefixLimit{{Prefix: "parcel/", Limit: Limit{Rate: 1, Burst: 1}}}})
+	ok, _ = l.Allow("c", "invoice/1")
+	assert.True(t, ok, "buckets of removed prefixes should be dropped")
+
+	*now = now.Add(time.Hour)
+	l.SetConfig(&Config{PerCaller: Limit{Rate: 1, Burst: 1}})
+	ok, _ = l.Allow("a", "parcel/1")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a", "parcel/1")
+	assert.False(t, ok, "buckets should be resized to the new burst")
+
+	l.SetConfig(&Config{})
+	ok, _ = l.Allow("a", "parcel/1")
+	assert.True(t, ok, "removed limits should not apply")
+
+	l.Acquired("a", "parcel/1", 10*time.Second)
+	l.SetConfig(&Config{MaxLocksPerCaller: 1})
+	ok, _ = canAcquire(l, "a")
+	assert.True(t, ok, "locks acquired without quota should not count")
+}
+
+func TestParseConfig_Invalid(t *testing.T) {
+	_, err := ParseConfig([]byte("per_caller: {rate: 1}"))
+	assert.Error(t, err, "rate without burst should be rejected")
+
+	_, err = ParseConfig([]byte("prefixes: [{prefix: a}]"))
+	assert.Error(t, err, "prefix without rate should be rejected")
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	interceptor := UnaryServerInterceptor(l)
+	info := &grpc.UnaryServerInfo{FullMethod: methodGet}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	ctx := auth.NewContext(context.Background(), "a")
+
+	_, err := interceptor(ctx, &testRequest{"parcel/1", 10}, info, handler)
+	assert.NoError(t, err)
+
+	_, err = interceptor(ctx, &testRequest{"parcel/2", 10}, info, handler)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code(), "quota should be enforced")
+	if assert.Len(t, st.Details(), 1) {
+		retry := st.Details()[0].(*errdetails.RetryInfo)
+		assert.Equal(t, int64(10), retry.RetryDelay.Seconds, "retry delay should be sent")
+	}
+
+	_, err = interceptor(ctx, &testRequest{"parcel/2", 10}, &grpc.UnaryServerInfo{FullMethod: legacyMethodGet}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "quota should be enforced on the unversioned API")
+}
+
+func TestUnaryServerInterceptor_ConcurrentGet(t *testing.T) {
+	l := NewLimiter(&Config{MaxLocksPerCaller: 1})
+	interceptor := UnaryServerInterceptor(l)
+	info := &grpc.UnaryServerInfo{FullMethod: methodGet}
+	ctx := auth.NewContext(context.Background(), "a")
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	blocking := func(ctx context.Context, req interface{}) (interface{}, error) {
+		close(entered)
+		<-release
+		return req, nil
+	}
+	done := make(chan error)
+	go func() {
+		_, err := interceptor(ctx, &testRequest{"parcel/1", 10}, info, blocking)
+		done <- err
+	}()
+	<-entered
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	_, err := interceptor(ctx, &testRequest{"parcel/2", 10}, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "the quota should hold acquisitions in flight")
+
+	close(release)
+	assert.NoError(t, <-done)
+
+	failing := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Aborted, "held")
+	}
+	l.Released("parcel/1")
+	_, err = interceptor(ctx, &testRequest{"parcel/3", 10}, info, failing)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	_, err = interceptor(ctx, &testRequest{"parcel/3", 10}, info, handler)
+	assert.NoError(t, err, "failed acquisitions should give back their slot")
+}
+
+// trailerStream records the trailers set by an interceptor
+type trailerStream struct {
+	grpc.ServerTransportStream
+	trailer metadata.MD
+}
+
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func TestExhausted_RetryAfter(t *testing.T) {
+	stream := &trailerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+
+	_ = exhausted(ctx, 0, "quota exceeded")
+	assert.Equal(t, []string{"1"}, stream.trailer.Get("retry-after"), "retry-after should never ask for an immediate retry")
+
+	stream.trailer = nil
+	_ = exhausted(ctx, 1500*time.Millisecond, "rate limited")
+	assert.Equal(t, []string{"2"}, stream.trailer.Get("retry-after"), "retry-after should be rounded up")
+}
+
+func TestCallerKey(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4242}})
+
+	assert.Equal(t, "anonymous@10.0.0.1", callerKey(ctx), "anonymous callers should be told apart by their host")
+	assert.Equal(t, "billing", callerKey(auth.NewContext(ctx, "billing")), "identified callers should keep their principal")
+	assert.Equal(t, auth.Anonymous, callerKey(context.Background()))
+
+	unix := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.UnixAddr{Name: "@", Net: "unix"}})
+	assert.Equal(t, auth.Anonymous, callerKey(unix), "callers without a host should share the anonymous limits")
+}
+
+func TestUnaryServerInterceptor_TakeOver(t *testing.T) {
+	l := NewLimiter(&Config{MaxLocksPerCaller: 1})
+	interceptor := UnaryServerInterceptor(l)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	admin, owner := auth.NewContext(context.Background(), "admin"), auth.NewContext(context.Background(), "a")
+
+	_, err := interceptor(auth.NewContext(context.Background(), "b"), &testRequest{"parcel/1", 10}, &grpc.UnaryServerInfo{FullMethod: methodGet}, handler)
+	assert.NoError(t, err)
+	_, err = interceptor(admin, &testRequest{"parcel/1", 10}, &grpc.UnaryServerInfo{FullMethod: methodTakeOver}, handler)
+	assert.NoError(t, err)
+
+	ok, _ := canAcquire(l, "admin")
+	assert.True(t, ok, "taken over locks should not count for the admin taking them over")
+	ok, _ = canAcquire(l, "b")
+	assert.True(t, ok, "taken over locks should not count for the previous owner")
+
+	_, err = interceptor(owner, &testRequest{"parcel/1", 10}, &grpc.UnaryServerInfo{FullMethod: methodRefresh}, handler)
+	assert.NoError(t, err)
+	ok, _ = canAcquire(l, "a")
+	assert.False(t, ok, "taken over locks should count for the new owner once it refreshes them")
+}
+
+func TestUnaryServerInterceptor_Refresh(t *testing.T) {
+	l := NewLimiter(&Config{MaxLocksPerCaller: 1})
+	interceptor := UnaryServerInterceptor(l)
+	info := &grpc.UnaryServerInfo{FullMethod: methodRefresh}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	ctx := auth.NewContext(context.Background(), "a")
+
+	_, err := interceptor(ctx, &testRequest{"parcel/1", 10}, &grpc.UnaryServerInfo{FullMethod: methodGet}, handler)
+	assert.NoError(t, err)
+	_, err = interceptor(ctx, &testRequest{"parcel/1", 10}, info, handler)
+	assert.NoError(t, err, "refreshing a counted lock should keep its slot")
+
+	_, err = interceptor(ctx, &testRequest{"parcel/2", 10}, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "refreshing should not exceed the quota")
+	_, err = interceptor(ctx, &testRequest{"parcel/2", 10}, &grpc.UnaryServerInfo{FullMethod: legacyMethodRefresh}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "quota should be enforced on refreshes of the unversioned API")
+}
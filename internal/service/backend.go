@@ -0,0 +1,81 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-redis/redis"
+	_ "github.com/lib/pq" // registers the postgres driver
+	"github.com/stoex/go-lock/internal/config"
+	"github.com/stoex/go-lock/pkg/redlock"
+)
+
+// Lock backends selectable with config.RedlockConfig.Backend
+const (
+	BackendRedis    = "redis"
+	BackendEtcd     = "etcd"
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+)
+
+// NewStores returns the lock stores (nodes) of the configured backend
+func NewStores(c config.RedlockConfig) ([]redlock.Store, error) {
+	switch c.Backend {
+	case BackendRedis, "":
+		nodes, err := c.RedisNodes()
+		if err != nil {
+			return nil, err
+		}
+		stores := make([]redlock.Store, len(nodes))
+		for i, n := range nodes {
+			client, err := newRedisNode(n)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redis node %d :: %s :: %v", i+1, n.Redacted(), err)
+			}
+			stores[i] = redlock.NewRedisStore(client)
+		}
+		return stores, nil
+	case BackendEtcd:
+		client, err := redlock.NewEtcdClient(c.EtcdEndpoints)
+		if err != nil {
+			return nil, err
+		}
+		store, err := redlock.NewEtcdStore(client, c.EtcdPrefix)
+		if err != nil {
+			return nil, err
+		}
+		return []redlock.Store{store}, nil
+	case BackendPostgres:
+		db, err := sql.Open("postgres", c.PostgresDSN)
+		if err != nil {
+			return nil, err
+		}
+		store := redlock.NewSQLStore(db, redlock.PostgresDialect)
+		if err := store.CreateTable(); err != nil {
+			return nil, fmt.Errorf("failed to create lock table :: %s", err.Error())
+		}
+		return []redlock.Store{store}, nil
+	case BackendMemory:
+		return []redlock.Store{redlock.NewMemoryStore()}, nil
+	}
+
+	return nil, fmt.Errorf("unknown lock backend %q", c.Backend)
+}
+
+// newRedisNode returns a client for n, loading its TLS material and password from their files
+func newRedisNode(n config.RedisNodeConfig) (redis.Cmdable, error) {
+	tlsConfig, err := n.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := n.Password()
+	if err != nil {
+		return nil, err
+	}
+
+	return redlock.NewRedisNodeWithOptions(n.URL, &redlock.RedisNodeOptions{
+		TLSConfig: tlsConfig,
+		Username:  n.Username,
+		Password:  password,
+	})
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	legacypb "github.com/stoex/go-lock/internal/generated"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+)
+
+// LegacyServer serves the unversioned lock.Lock API by converting its messages to lock.v1,
+// so clients can migrate while both are registered on the same server
+type LegacyServer struct {
+	svc *LockService
+}
+
+// NewLegacyServer returns a pointer to a LegacyServer calling svc
+func NewLegacyServer(svc *LockService) *LegacyServer {
+	return &LegacyServer{svc: svc}
+}
+
+func toV1(req *legacypb.LockRequest) *pb.LockRequest {
+	return &pb.LockRequest{
+		ResourceId: req.ResourceId,
+		LockId:     req.LockId,
+		Ttl:        req.Ttl,
+	}
+}
+
+func fromV1(res *pb.LockResponse) *legacypb.LockResponse {
+	if res == nil {
+		return nil
+	}
+
+	return &legacypb.LockResponse{
+		Status:     legacypb.ResponseStatus(res.Status),
+		ResourceId: res.ResourceId,
+		LockId:     res.LockId,
+		Ttl:        res.Ttl,
+	}
+}
+
+func (s *LegacyServer) call(ctx context.Context, req *legacypb.LockRequest, fn func(context.Context, *pb.LockRequest) (*pb.LockResponse, error)) (*legacypb.LockResponse, error) {
+	res, err := fn(ctx, toV1(req))
+
+	return fromV1(res), err
+}
+
+// GetLock is responsible for aquiring a resource lock
+func (s *LegacyServer) GetLock(ctx context.Context, req *legacypb.LockRequest) (*legacypb.LockResponse, error) {
+	return s.call(ctx, req, s.svc.GetLock)
+}
+
+// RefreshLock is responsible for refreshing / extending a resource lock
+func (s *LegacyServer) RefreshLock(ctx context.Context, req *legacypb.LockRequest) (*legacypb.LockResponse, error) {
+	return s.call(ctx, req, s.svc.RefreshLock)
+}
+
+// DeleteLock is responsible for deleting / removing a resource lock
+func (s *LegacyServer) DeleteLock(ctx context.Context, req *legacypb.LockRequest) (*legacypb.LockResponse, error) {
+	return s.call(ctx, req, s.svc.DeleteLock)
+}
+
+// CheckLock returns information about a lock
+func (s *LegacyServer) CheckLock(ctx context.Context, req *legacypb.LockRequest) (*legacypb.LockResponse, error) {
+	return s.call(ctx, req, s.svc.CheckLock)
+}
+
+// ForceRelease removes a resource lock regardless of its owner
+func (s *LegacyServer) ForceRelease(ctx context.Context, req *legacypb.LockRequest) (*legacypb.LockResponse, error) {
+	return s.call(ctx, req, s.svc.ForceRelease)
+}
+
+// TakeOver transfers an existing resource lock to a new owner
+func (s *LegacyServer) TakeOver(ctx context.Context, req *legacypb.LockRequest) (*legacypb.LockResponse, error) {
+	return s.call(ctx, req, s.svc.TakeOver)
+}
+
+// ListLocks returns the locks on resources starting with the requested prefix
+func (s *LegacyServer) ListLocks(ctx context.Context, req *legacypb.ListRequest) (*legacypb.ListResponse, error) {
+	res, err := s.svc.ListLocks(ctx, &pb.ListRequest{Prefix: req.Prefix})
+
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]*legacypb.LockResponse, len(res.Locks))
+	for i, l := range res.Locks {
+		locks[i] = fromV1(l)
+	}
+
+	return &legacypb.ListResponse{Locks: locks}, nil
+}
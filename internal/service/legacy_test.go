@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+	legacypb "github.com/stoex/go-lock/internal/generated"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLegacyServer(t *testing.T) {
+	svc, _ := newTestMemoryService(t)
+	legacy := NewLegacyServer(svc)
+	ctx := context.Background()
+
+	res, err := legacy.GetLock(ctx, &legacypb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL})
+	assert.NoError(t, err, "legacy requests should be served")
+	assert.Equal(t, legacypb.ResponseStatus_OK, res.Status)
+	assert.Equal(t, testLockID, res.LockId)
+
+	res, err = legacy.CheckLock(ctx, &legacypb.LockRequest{ResourceId: testResourceID})
+	assert.NoError(t, err)
+	assert.Equal(t, testLockID, res.LockId, "legacy and v1 should share locks")
+
+	list, err := legacy.ListLocks(ctx, &legacypb.ListRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, list.Locks, 1)
+
+	res, err = legacy.GetLock(ctx, &legacypb.LockRequest{ResourceId: testResourceID, LockId: "someoneelse", Ttl: testTTL})
+	assert.Error(t, err)
+	assert.Nil(t, res, "failed requests should not return a response")
+}
@@ -3,30 +3,50 @@ package service
 import (
 	"context"
 	"fmt"
-	pb "github.com/stoex/go-lock/internal/generated"
+	"github.com/stoex/go-lock/internal/audit"
+	"github.com/stoex/go-lock/internal/auth"
+	"github.com/stoex/go-lock/internal/config"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
 	"github.com/stoex/go-lock/internal/logger"
 	"github.com/stoex/go-lock/pkg/redlock"
+	"sync"
+	"time"
 )
 
 // LockService represents a grpc service handler
 type LockService struct {
-	redlock *redlock.Redlock
+	redlock  *redlock.Redlock
+	auditMu  sync.RWMutex
+	audit    audit.Sink
+	drain    drain
+	maxDelay time.Duration
 }
 
-// NewLockService returns a pointer to a LockService instance.
-// The errors that could be returned from this come from the redis clients.
+// NewLockService returns a pointer to a LockService instance running on the redis nodes in addr,
+// see NewLockServiceWithStores. The errors that could be returned from this come from the redis clients.
 func NewLockService(addr []string) (*LockService, error) {
-	service := LockService{}
-	clients, err := redlock.NewRedisClientPool(addr)
+	clients, err := redlock.NewRedisNodePool(addr)
 
 	if err != nil {
 		return nil, err
 	}
 
-	service.redlock = redlock.NewRedlock()
+	stores := make([]redlock.Store, len(clients))
+	for i, c := range clients {
+		stores[i] = redlock.NewRedisStore(c)
+	}
+
+	return NewLockServiceWithStores(stores)
+}
 
-	for _, c := range clients {
-		if err := service.redlock.AddRedisClient(c); err != nil {
+// NewLockServiceWithStores returns a pointer to a LockService running the redlock algorithm on the given stores.
+// The ttl of locks is bounded by config.DefaultMaxTTL and defaults to config.DefaultTTL until ConfigureTTL is called.
+func NewLockServiceWithStores(stores []redlock.Store) (*LockService, error) {
+	service := LockService{audit: audit.NopSink(), redlock: redlock.NewRedlock(), maxDelay: configMaxDelay(config.RedlockConfig{})}
+	service.redlock.SetTTLPolicies(redlock.TTLPolicy{Max: config.DefaultMaxTTL, Default: config.DefaultTTL})
+
+	for _, s := range stores {
+		if err := service.redlock.AddStore(s); err != nil {
 			return nil, err
 		}
 	}
@@ -34,17 +54,102 @@ func NewLockService(addr []string) (*LockService, error) {
 	return &service, nil
 }
 
+// Configure applies the redlock tuning of c, zero values keep the current settings
+func (s *LockService) Configure(c config.RedlockConfig) {
+	s.redlock.SetRetryCount(c.RetryCount)
+	s.redlock.SetRetryDelay(c.RetryDelay)
+	s.redlock.SetRetryStrategy(configRetryStrategy(c))
+	s.maxDelay = configMaxDelay(c)
+	s.redlock.SetDriftFactor(c.DriftFactor)
+}
+
+// SetAuditSink sets the sink receiving an audit record for every lock state transition and returns the previous one.
+// Once it returns no record is written to the previous sink anymore, so it can be closed.
+func (s *LockService) SetAuditSink(sink audit.Sink) audit.Sink {
+	if sink == nil {
+		return nil
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	prev := s.audit
+	s.audit = sink
+
+	return prev
+}
+
+// record writes an audit record of action. ttl is the granted ttl in seconds, it is replaced by the
+// requested one if the action failed. Auditing is best-effort, write failures are logged and do not fail the action.
+func (s *LockService) record(ctx context.Context, action string, req *pb.LockRequest, ttl int64, err error) {
+	s.auditMu.RLock()
+	defer s.auditMu.RUnlock()
+
+	if s.audit == nil {
+		return
+	}
+
+	if err != nil {
+		ttl = int64(req.Ttl)
+	}
+
+	r := audit.NewRecord(ctx, action, req.ResourceId, req.LockId, uint32(ttl), err)
+
+	if err := s.audit.Write(r); err != nil {
+		logger.Error(ctx, fmt.Sprintf("failed to write audit record :: action %s :: resource %s :: %v", action, req.ResourceId, err))
+	}
+}
+
 // GetLock is responsible for aquiring a resource lock
 func (s *LockService) GetLock(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
 	logger.Info(ctx, fmt.Sprintf("<- get :: resource %s :: lock-id %s :: ttl %d", req.ResourceId, req.LockId, req.Ttl))
 
-	ttl, err := s.redlock.Lock(req.ResourceId, req.LockId, int(req.Ttl))
+	if s.drain.draining.Load() {
+		logger.Warn(ctx, "-> get fail :: draining")
+		return nil, errDraining
+	}
+
+	if err := redlock.ValidateResource(req.ResourceId); err != nil {
+		logger.Warn(ctx, fmt.Sprintf("-> get fail :: %v", err))
+		return nil, lockError(err)
+	}
 
+	strategy, err := s.requestRetryStrategy(req.Retry)
 	if err != nil {
-		logger.Error(ctx, "-> get fail")
+		logger.Warn(ctx, fmt.Sprintf("-> get fail :: %v", err))
 		return nil, err
 	}
 
+	resolved, err := s.redlock.ResolveTTL(req.ResourceId, int(req.Ttl), req.NoExpiry)
+	if err != nil {
+		logger.Warn(ctx, fmt.Sprintf("-> get fail :: %v", err))
+		return nil, ttlError(err)
+	}
+	req.Ttl = uint32(resolved)
+
+	lockCtx, cancel := s.drain.acquireContext(ctx)
+	defer cancel()
+
+	ttl, err := s.redlock.LockContextWithOptions(lockCtx, req.ResourceId, req.LockId, int(req.Ttl), &redlock.LockOptions{
+		RetryStrategy: strategy,
+		TryOnce:       req.TryOnce,
+		MaxRetries:    int(req.MaxRetries),
+		MaxWait:       time.Duration(req.MaxWaitMs) * time.Millisecond,
+		NoExpiry:      req.NoExpiry,
+		MaxLeaseAge:   time.Duration(req.MaxLeaseAge) * time.Second,
+	})
+	s.record(ctx, audit.ActionAcquire, req, ttl, err)
+
+	if err != nil {
+		logger.Error(ctx, "-> get fail")
+		if s.drain.draining.Load() {
+			return nil, errDraining
+		}
+		return nil, lockError(err)
+	}
+
+	s.drain.acquired(req.ResourceId, req.LockId, time.Duration(req.Ttl)*time.Second)
+
 	logger.Info(ctx, fmt.Sprintf("-> get ok, ttl: %d", ttl))
 
 	return &pb.LockResponse{
@@ -57,15 +162,43 @@ func (s *LockService) GetLock(ctx context.Context, req *pb.LockRequest) (*pb.Loc
 
 // RefreshLock is responsible for refreshing / extending a resource lock
 func (s *LockService) RefreshLock(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
-	logger.Info(ctx, fmt.Sprintf("<- refresh :: resource %s :: lock-id %s :: ttl %d", req.ResourceId, req.LockId, req.Ttl))
+	logger.Info(ctx, fmt.Sprintf("<- refresh :: resource %s :: lock-id %s :: ttl %d :: mode %s", req.ResourceId, req.LockId, req.Ttl, req.RefreshMode))
 
-	ttl, err := s.redlock.Refresh(req.ResourceId, req.LockId, int(req.Ttl))
+	if err := redlock.ValidateResource(req.ResourceId); err != nil {
+		logger.Warn(ctx, fmt.Sprintf("-> refresh fail :: %v", err))
+		return nil, lockError(err)
+	}
 
+	mode, err := requestRefreshMode(req.RefreshMode)
 	if err != nil {
-		logger.Error(ctx, "-> refresh fail")
+		logger.Warn(ctx, fmt.Sprintf("-> refresh fail :: %v", err))
 		return nil, err
 	}
 
+	if mode == redlock.RefreshReset {
+		resolved, err := s.redlock.ResolveTTL(req.ResourceId, int(req.Ttl), false)
+		if err != nil {
+			logger.Warn(ctx, fmt.Sprintf("-> refresh fail :: %v", err))
+			return nil, ttlError(err)
+		}
+		req.Ttl = uint32(resolved)
+	}
+
+	ttl, err := s.redlock.RefreshWithOptions(req.ResourceId, req.LockId, int(req.Ttl), &redlock.RefreshOptions{Mode: mode})
+	s.record(ctx, audit.ActionRefresh, req, ttl, err)
+
+	if err != nil {
+		logger.Error(ctx, "-> refresh fail")
+		return nil, lockError(err)
+	}
+
+	// only a reset knows the new ttl, otherwise the validity is a lower bound
+	held := time.Duration(ttl) * time.Second
+	if mode == redlock.RefreshReset {
+		held = time.Duration(req.Ttl) * time.Second
+	}
+	s.drain.refreshed(req.ResourceId, req.LockId, held)
+
 	logger.Info(ctx, fmt.Sprintf("-> refresh ok, ttl: %d", ttl))
 
 	return &pb.LockResponse{
@@ -81,12 +214,15 @@ func (s *LockService) DeleteLock(ctx context.Context, req *pb.LockRequest) (*pb.
 	logger.Info(ctx, fmt.Sprintf("<- delete :: resource %s :: lock-id %s", req.ResourceId, req.LockId))
 
 	err := s.redlock.Unlock(req.ResourceId, req.LockId)
+	s.record(ctx, audit.ActionRelease, req, 0, err)
 
 	if err != nil {
 		logger.Error(ctx, "-> delete fail")
-		return nil, err
+		return nil, lockError(err)
 	}
 
+	s.drain.released(req.ResourceId)
+
 	logger.Info(ctx, "-> delete ok")
 
 	return &pb.LockResponse{
@@ -102,7 +238,7 @@ func (s *LockService) CheckLock(ctx context.Context, req *pb.LockRequest) (*pb.L
 
 	if err != nil {
 		logger.Error(ctx, "-> check fail")
-		return nil, err
+		return nil, lockError(err)
 	}
 
 	logger.Info(ctx, fmt.Sprintf("-> check ok :: resource %s :: lock-id %s :: ttl %d", l.Resource, l.ID, l.TTL))
@@ -114,3 +250,88 @@ func (s *LockService) CheckLock(ctx context.Context, req *pb.LockRequest) (*pb.L
 		Ttl:        uint32(l.TTL),
 	}, nil
 }
+
+// ForceRelease removes a resource lock regardless of its owner
+func (s *LockService) ForceRelease(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	logger.Info(ctx, fmt.Sprintf("<- force release :: resource %s :: principal %s", req.ResourceId, auth.FromContext(ctx)))
+
+	err := s.redlock.ForceUnlock(req.ResourceId)
+	s.record(ctx, audit.ActionForceRelease, req, 0, err)
+
+	if err != nil {
+		logger.Error(ctx, "-> force release fail")
+		return nil, lockError(err)
+	}
+
+	s.drain.released(req.ResourceId)
+
+	logger.Info(ctx, "-> force release ok")
+
+	return &pb.LockResponse{
+		Status:     1,
+		ResourceId: req.ResourceId,
+	}, nil
+}
+
+// TakeOver transfers an existing resource lock to a new owner
+func (s *LockService) TakeOver(ctx context.Context, req *pb.LockRequest) (*pb.LockResponse, error) {
+	logger.Info(ctx, fmt.Sprintf("<- take over :: resource %s :: lock-id %s :: ttl %d :: principal %s", req.ResourceId, req.LockId, req.Ttl, auth.FromContext(ctx)))
+
+	err := s.redlock.TakeOver(req.ResourceId, req.LockId, int(req.Ttl))
+	s.record(ctx, audit.ActionTakeOver, req, int64(req.Ttl), err)
+
+	if err != nil {
+		logger.Error(ctx, "-> take over fail")
+		return nil, lockError(err)
+	}
+
+	s.drain.released(req.ResourceId)
+
+	// the lock kept its expiry, report what is left of it
+	ttl := req.Ttl
+	if ttl == 0 {
+		if l, err := s.redlock.Check(req.ResourceId); err == nil && l.ID == req.LockId {
+			ttl = uint32(l.TTL)
+		}
+	}
+
+	logger.Info(ctx, "-> take over ok")
+
+	return &pb.LockResponse{
+		Status:     1,
+		ResourceId: req.ResourceId,
+		LockId:     req.LockId,
+		Ttl:        ttl,
+	}, nil
+}
+
+// ListLocks returns the locks on resources starting with the requested prefix
+func (s *LockService) ListLocks(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	logger.Info(ctx, fmt.Sprintf("<- list :: prefix %s", req.Prefix))
+
+	locks, err := s.redlock.List(req.Prefix)
+
+	if err != nil {
+		logger.Error(ctx, "-> list fail")
+		return nil, err
+	}
+
+	res := &pb.ListResponse{Locks: make([]*pb.LockResponse, 0, len(locks))}
+
+	for _, l := range locks {
+		// the prefix is allowed, rules below it may still deny single resources
+		if !auth.Allowed(ctx, l.Resource, auth.OpList) {
+			continue
+		}
+		res.Locks = append(res.Locks, &pb.LockResponse{
+			Status:     1,
+			ResourceId: l.Resource,
+			LockId:     l.ID,
+			Ttl:        uint32(l.TTL),
+		})
+	}
+
+	logger.Info(ctx, fmt.Sprintf("-> list ok :: locks %d", len(res.Locks)))
+
+	return res, nil
+}
@@ -5,10 +5,15 @@ import (
 	"github.com/alicebob/miniredis"
 	"github.com/elliotchance/redismock"
 	"github.com/go-redis/redis"
-	pb "github.com/stoex/go-lock/internal/generated"
+	"github.com/stoex/go-lock/internal/audit"
+	"github.com/stoex/go-lock/internal/auth"
+	"github.com/stoex/go-lock/internal/config"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
 	"github.com/stoex/go-lock/pkg/redlock"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"log"
 	"net"
@@ -56,7 +61,7 @@ func init() {
 
 	rl, _ = newTestRedlock()
 
-	pb.RegisterLockServer(s, &LockService{redlock: rl})
+	pb.RegisterLockServiceServer(s, &LockService{redlock: rl})
 
 	go func() {
 		if err := s.Serve(lis); err != nil {
@@ -79,7 +84,7 @@ func TestGetLock(t *testing.T) {
 	defer conn.Close()
 	defer rl.Unlock(testResourceID, testLockID)
 
-	client := pb.NewLockClient(conn)
+	client := pb.NewLockServiceClient(conn)
 	res, err := client.GetLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL})
 
 	if err != nil {
@@ -105,7 +110,7 @@ func TestRefreshLock(t *testing.T) {
 	// set lock
 	rl.Lock(testResourceID, testLockID, testTTL)
 
-	client := pb.NewLockClient(conn)
+	client := pb.NewLockServiceClient(conn)
 	res, err := client.RefreshLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL})
 
 	if err != nil {
@@ -131,7 +136,7 @@ func TestDeleteLock(t *testing.T) {
 	// set lock
 	rl.Lock(testResourceID, testLockID, testTTL)
 
-	client := pb.NewLockClient(conn)
+	client := pb.NewLockServiceClient(conn)
 	res, err := client.DeleteLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID})
 
 	if err != nil {
@@ -154,7 +159,7 @@ func TestCheckLock(t *testing.T) {
 	// set lock
 	rl.Lock(testResourceID, testLockID, testTTL)
 
-	client := pb.NewLockClient(conn)
+	client := pb.NewLockServiceClient(conn)
 	res, err := client.CheckLock(ctx, &pb.LockRequest{ResourceId: testResourceID})
 
 	if err != nil {
@@ -166,3 +171,245 @@ func TestCheckLock(t *testing.T) {
 	assert.Equal(t, res.ResourceId, testResourceID)
 	assert.LessOrEqual(t, int(res.Ttl), testTTL)
 }
+
+func TestForceRelease(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+
+	defer conn.Close()
+	defer rl.Unlock(testResourceID, testLockID)
+
+	// set lock
+	rl.Lock(testResourceID, "someoneelse", testTTL)
+
+	client := pb.NewLockServiceClient(conn)
+	res, err := client.ForceRelease(ctx, &pb.LockRequest{ResourceId: testResourceID})
+
+	if err != nil {
+		t.Fatalf("ForceRelease failed: %v", err)
+	}
+
+	assert.Equal(t, res.Status, pb.ResponseStatus_OK)
+	assert.Equal(t, res.ResourceId, testResourceID)
+
+	_, err = rl.Lock(testResourceID, testLockID, testTTL)
+	assert.NoError(t, err, "lock should be free after force release")
+}
+
+func TestTakeOver(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+
+	defer conn.Close()
+	defer rl.Unlock(testResourceID, testLockID)
+
+	// set lock
+	rl.Lock(testResourceID, "someoneelse", testTTL)
+
+	client := pb.NewLockServiceClient(conn)
+	res, err := client.TakeOver(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL})
+
+	if err != nil {
+		t.Fatalf("TakeOver failed: %v", err)
+	}
+
+	assert.Equal(t, res.Status, pb.ResponseStatus_OK)
+	assert.Equal(t, res.LockId, testLockID)
+	assert.Equal(t, res.ResourceId, testResourceID)
+}
+
+func TestListLocks(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+
+	defer conn.Close()
+	defer rl.Unlock("list/1", testLockID)
+	defer rl.Unlock("list/2", testLockID)
+
+	// set locks
+	rl.Lock("list/2", testLockID, testTTL)
+	rl.Lock("list/1", testLockID, testTTL)
+
+	client := pb.NewLockServiceClient(conn)
+	res, err := client.ListLocks(ctx, &pb.ListRequest{Prefix: "list/"})
+
+	if err != nil {
+		t.Fatalf("ListLocks failed: %v", err)
+	}
+
+	if assert.Len(t, res.Locks, 2) {
+		assert.Equal(t, "list/1", res.Locks[0].ResourceId)
+		assert.Equal(t, testLockID, res.Locks[0].LockId)
+		assert.LessOrEqual(t, int(res.Locks[0].Ttl), testTTL)
+	}
+}
+
+func TestListLocks_Policy(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1})
+
+	policy, err := auth.ParsePolicy([]byte(`
+default: allow
+rules:
+  - effect: deny
+    principals: ["*"]
+    resources: ["invoice/*"]
+    operations: [list, check]
+`))
+	if err != nil {
+		t.Fatalf("could not parse policy: %s", err.Error())
+	}
+
+	ctx := context.Background()
+	for _, resource := range []string{"invoice/1", "parcel/1"} {
+		if _, err := svc.GetLock(ctx, &pb.LockRequest{ResourceId: resource, LockId: testLockID, Ttl: testTTL}); err != nil {
+			t.Fatalf("could not acquire lock: %s", err.Error())
+		}
+	}
+
+	interceptor := auth.UnaryServerInterceptor(auth.NewEngine(policy), func(context.Context) string { return "shipping" })
+	info := &grpc.UnaryServerInfo{FullMethod: "/lock.v1.LockService/ListLocks"}
+	list := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return svc.ListLocks(ctx, req.(*pb.ListRequest))
+	}
+
+	res, err := interceptor(ctx, &pb.ListRequest{Prefix: ""}, info, list)
+	if err != nil {
+		t.Fatalf("ListLocks failed: %v", err)
+	}
+	if assert.Len(t, res.(*pb.ListResponse).Locks, 1, "denied resources below an allowed prefix should be dropped") {
+		assert.Equal(t, "parcel/1", res.(*pb.ListResponse).Locks[0].ResourceId)
+	}
+
+	_, err = interceptor(ctx, &pb.ListRequest{Prefix: "invoice/"}, info, list)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "a denied prefix should be rejected")
+
+	res, err = svc.ListLocks(ctx, &pb.ListRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, res.(*pb.ListResponse).Locks, 2, "calls without a policy should list every lock")
+}
+
+// sliceSink keeps the audit records written to it
+type sliceSink struct {
+	records []*audit.Record
+}
+
+func (s *sliceSink) Write(r *audit.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *sliceSink) Close() error {
+	return nil
+}
+
+func TestLockService_AuditTTL(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1})
+
+	sink := &sliceSink{}
+	svc.SetAuditSink(sink)
+	ctx := context.Background()
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID})
+	assert.NoError(t, err)
+	_, err = svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: 10, RefreshMode: pb.LockRequest_EXTEND})
+	assert.NoError(t, err)
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: "other", Ttl: testTTL})
+	assert.Error(t, err)
+
+	if assert.Len(t, sink.records, 3) {
+		assert.InDelta(t, 30, sink.records[0].TTL, 1, "the granted default ttl should be recorded")
+		assert.InDelta(t, 40, sink.records[1].TTL, 1, "the extended ttl should be recorded")
+		assert.Equal(t, uint32(testTTL), sink.records[2].TTL, "failed actions should record the requested ttl")
+	}
+}
+
+func TestNewLockService_DefaultTTL(t *testing.T) {
+	var addr []string
+	for i := 0; i < 3; i++ {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("could not start miniredis: %s", err.Error())
+		}
+		defer mr.Close()
+		addr = append(addr, "redis://"+mr.Addr())
+	}
+
+	svc, err := NewLockService(addr)
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	ctx := context.Background()
+
+	res, err := svc.GetLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID})
+	assert.NoError(t, err, "a ttl of 0 should take the default")
+	assert.InDelta(t, config.DefaultTTL.Seconds(), res.GetTtl(), 1)
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "invoice/43", LockId: testLockID, Ttl: uint32(config.DefaultMaxTTL.Seconds()) + 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "the default max ttl should be enforced")
+}
+
+func TestLockService_TakeOverTTL(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	ctx := context.Background()
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL})
+	assert.NoError(t, err)
+
+	res, err := svc.TakeOver(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: "other"})
+	assert.NoError(t, err)
+	assert.InDelta(t, testTTL, res.Ttl, 1, "a take over keeping the expiry should report the remaining ttl")
+}
+
+func TestLockService_ErrorCodes(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1})
+	ctx := context.Background()
+
+	_, err = svc.CheckLock(ctx, &pb.LockRequest{ResourceId: testResourceID})
+	assert.Equal(t, codes.NotFound, status.Code(err), "missing locks should not be found")
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL})
+	assert.NoError(t, err)
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: "other", Ttl: testTTL})
+	assert.Equal(t, codes.Aborted, status.Code(err), "held locks should be reported as contention")
+
+	_, err = svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: "other", Ttl: testTTL})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "locks held by another lock id should not be refreshed")
+
+	_, err = svc.DeleteLock(ctx, &pb.LockRequest{ResourceId: testResourceID, LockId: "other"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "locks held by another lock id should not be released")
+
+	_, err = svc.TakeOver(ctx, &pb.LockRequest{ResourceId: "missing", LockId: "other"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "missing locks should not be taken over")
+
+	for _, call := range []func(context.Context, *pb.LockRequest) (*pb.LockResponse, error){
+		svc.GetLock, svc.RefreshLock, svc.DeleteLock, svc.CheckLock, svc.ForceRelease, svc.TakeOver,
+	} {
+		_, err = call(ctx, &pb.LockRequest{ResourceId: testResourceID + "\x1flease", LockId: testLockID, Ttl: testTTL})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "resources with reserved characters should be rejected")
+	}
+}
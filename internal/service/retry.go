@@ -0,0 +1,109 @@
+package service
+
+import (
+	"github.com/stoex/go-lock/internal/config"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// maxDelayFactor is the cap of a single delay relative to the base delay if none is given,
+// a single delay is at most 3.2s with the default base delay
+const maxDelayFactor = 1 << 4
+
+// newRetryStrategy returns the strategy called name, it returns nil for uniform and unknown names
+func newRetryStrategy(name string, base time.Duration, max time.Duration) redlock.RetryStrategy {
+	if max <= 0 {
+		max = base * maxDelayFactor
+	}
+
+	switch name {
+	case "fixed":
+		return redlock.FixedRetry(base)
+	case "exponential":
+		return redlock.ExponentialRetry(base, max)
+	case "decorrelated_jitter":
+		return redlock.DecorrelatedJitterRetry(base, max)
+	}
+
+	return nil
+}
+
+// configMaxDelay returns the longest single delay of c, the max delay of its strategy bounded by its deadline.
+// Requests can not ask for longer delays.
+func configMaxDelay(c config.RedlockConfig) time.Duration {
+	max := time.Duration(c.RetryMaxDelay) * time.Millisecond
+	if max <= 0 {
+		base := time.Duration(c.RetryDelay) * time.Millisecond
+		if base <= 0 {
+			base = redlock.DefaultRetryDelay * time.Millisecond
+		}
+		max = base * maxDelayFactor
+	}
+
+	if deadline := time.Duration(c.RetryDeadline) * time.Millisecond; deadline > 0 && deadline < max {
+		return deadline
+	}
+
+	return max
+}
+
+// configRetryStrategy returns the retry strategy of c, nil keeps the uniform delay of the Redlock
+func configRetryStrategy(c config.RedlockConfig) redlock.RetryStrategy {
+	base := time.Duration(c.RetryDelay) * time.Millisecond
+	if base <= 0 {
+		base = redlock.DefaultRetryDelay * time.Millisecond
+	}
+
+	s := newRetryStrategy(c.RetryStrategy, base, time.Duration(c.RetryMaxDelay)*time.Millisecond)
+	if c.RetryDeadline <= 0 {
+		return s
+	}
+	if s == nil {
+		s = redlock.UniformRetry(base)
+	}
+
+	return redlock.DeadlineRetry(s, time.Duration(c.RetryDeadline)*time.Millisecond)
+}
+
+// requestRetryStrategy returns the retry strategy asked for by p, nil keeps the one of the server.
+// Delays are capped at the max delay of the server.
+func (s *LockService) requestRetryStrategy(p *pb.RetryPolicy) (redlock.RetryStrategy, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	base := time.Duration(p.BaseDelayMs) * time.Millisecond
+	max := time.Duration(p.MaxDelayMs) * time.Millisecond
+
+	strategy := s.redlock.RetryStrategy()
+	if p.Strategy != pb.RetryPolicy_DEFAULT {
+		if base <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid retry policy :: base_delay_ms is required for %s", p.Strategy)
+		}
+		if max > 0 && max < base {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid retry policy :: max_delay_ms is less than base_delay_ms")
+		}
+		if max <= 0 {
+			max = base * maxDelayFactor
+		}
+		if s.maxDelay > 0 && max > s.maxDelay {
+			max = s.maxDelay
+		}
+		if base > max {
+			base = max
+		}
+		if strategy = newRetryStrategy(strings.ToLower(p.Strategy.String()), base, max); strategy == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid retry policy :: unknown strategy %s", p.Strategy)
+		}
+	}
+
+	if p.DeadlineMs > 0 {
+		strategy = redlock.DeadlineRetry(strategy, time.Duration(p.DeadlineMs)*time.Millisecond)
+	}
+
+	return strategy, nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"github.com/stoex/go-lock/internal/config"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestConfigRetryStrategy(t *testing.T) {
+	assert.Nil(t, configRetryStrategy(config.RedlockConfig{}), "the uniform delay should be kept by default")
+
+	s := configRetryStrategy(config.RedlockConfig{RetryStrategy: "fixed", RetryDelay: 20, RetryDeadline: 50})
+	d, ok := s.Delay(1, 0, 0, nil)
+	assert.True(t, ok)
+	assert.Equal(t, 20*time.Millisecond, d, "the retry delay should be the base delay")
+
+	_, ok = s.Delay(3, 0, 50*time.Millisecond, nil)
+	assert.False(t, ok, "the deadline should apply")
+
+	s = configRetryStrategy(config.RedlockConfig{RetryStrategy: "exponential"})
+	rnd := redlock.NewRand(1)
+	for n := 1; n <= 30; n++ {
+		d, ok := s.Delay(n, 0, 0, rnd)
+		assert.True(t, ok)
+		assert.Less(t, int64(d), int64(maxDelayFactor*redlock.DefaultRetryDelay*time.Millisecond), "delays should be capped by default")
+	}
+}
+
+func TestGetLock_RetryPolicy(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1000, RetryStrategy: "fixed", RetryDelay: 1000})
+
+	_, err = svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: testResourceID, LockId: "someoneelse", Ttl: testTTL})
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL, Retry: &pb.RetryPolicy{
+		Strategy:    pb.RetryPolicy_EXPONENTIAL,
+		BaseDelayMs: 5,
+		MaxDelayMs:  20,
+		DeadlineMs:  100,
+	}})
+	elapsed := time.Since(start)
+
+	assert.Error(t, err, "held locks should not be acquired")
+	assert.GreaterOrEqual(t, int64(elapsed), int64(100*time.Millisecond), "retries should continue until the deadline")
+	assert.Less(t, int64(elapsed), int64(time.Second), "the policy of the request should replace the one of the server")
+
+	for name, p := range map[string]*pb.RetryPolicy{
+		"missing base delay": {Strategy: pb.RetryPolicy_FIXED},
+		"max below base":     {Strategy: pb.RetryPolicy_EXPONENTIAL, BaseDelayMs: 100, MaxDelayMs: 10},
+		"unknown strategy":   {Strategy: pb.RetryPolicy_Strategy(42), BaseDelayMs: 100},
+	} {
+		_, err = svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: "other", LockId: testLockID, Ttl: testTTL, Retry: p})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+	}
+}
+
+func TestRequestRetryStrategy_MaxDelay(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	rnd := redlock.NewRand(1)
+
+	s, err := svc.requestRetryStrategy(&pb.RetryPolicy{Strategy: pb.RetryPolicy_FIXED, BaseDelayMs: math.MaxUint32})
+	assert.NoError(t, err)
+	d, _ := s.Delay(1, 0, 0, rnd)
+	assert.Equal(t, maxDelayFactor*redlock.DefaultRetryDelay*time.Millisecond, d, "the base delay should be capped by the default max delay")
+
+	svc.Configure(config.RedlockConfig{RetryDelay: 10, RetryMaxDelay: 500})
+	s, err = svc.requestRetryStrategy(&pb.RetryPolicy{Strategy: pb.RetryPolicy_FIXED, BaseDelayMs: 1000})
+	assert.NoError(t, err)
+	d, _ = s.Delay(1, 0, 0, rnd)
+	assert.Equal(t, 500*time.Millisecond, d, "the base delay should be capped by the max delay of the server")
+
+	s, err = svc.requestRetryStrategy(&pb.RetryPolicy{Strategy: pb.RetryPolicy_EXPONENTIAL, BaseDelayMs: 100, MaxDelayMs: math.MaxUint32})
+	assert.NoError(t, err)
+	for n := 1; n <= 30; n++ {
+		d, _ = s.Delay(n, 0, 0, rnd)
+		assert.LessOrEqual(t, int64(d), int64(500*time.Millisecond), "the max delay should be capped by the max delay of the server")
+	}
+
+	svc.Configure(config.RedlockConfig{RetryDeadline: 50})
+	s, err = svc.requestRetryStrategy(&pb.RetryPolicy{Strategy: pb.RetryPolicy_FIXED, BaseDelayMs: 1000})
+	assert.NoError(t, err)
+	d, _ = s.Delay(1, 0, 0, rnd)
+	assert.Equal(t, 50*time.Millisecond, d, "delays should not exceed the deadline of the server")
+}
+
+func TestGetLock_TryOnce(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 10, RetryStrategy: "fixed", RetryDelay: 1000})
+
+	_, err = svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: testResourceID, LockId: "someoneelse", Ttl: testTTL})
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL, TryOnce: true})
+	assert.Error(t, err, "held locks should not be acquired")
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond), "try once should not wait for a retry")
+
+	start = time.Now()
+	_, err = svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL, MaxWaitMs: 50})
+	elapsed := time.Since(start)
+	assert.Error(t, err, "held locks should not be acquired")
+	assert.GreaterOrEqual(t, int64(elapsed), int64(50*time.Millisecond), "retries should continue until max wait")
+	assert.Less(t, int64(elapsed), int64(500*time.Millisecond), "retries should stop at max wait")
+
+	_, err = svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: "other", LockId: testLockID, Ttl: testTTL, TryOnce: true})
+	assert.NoError(t, err, "free locks should be acquired with a single attempt")
+}
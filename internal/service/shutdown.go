@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/stoex/go-lock/internal/audit"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/internal/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errDraining is returned for acquires while the server shuts down
+var errDraining = status.Error(codes.Unavailable, "server is shutting down")
+
+// lease is a lock acquired through this server, the zero expiry never passes
+type lease struct {
+	lockID string
+	expiry time.Time
+}
+
+func (l lease) expired(now time.Time) bool {
+	return !l.expiry.IsZero() && !l.expiry.After(now)
+}
+
+// drain holds the shutdown state of a LockService, its zero value is a serving service
+type drain struct {
+	draining atomic.Bool
+	once     sync.Once
+	stop     context.Context
+	cancel   context.CancelFunc
+
+	mu     sync.Mutex
+	track  bool
+	leases map[string]lease
+}
+
+// stopping returns a context which is canceled once the service drains
+func (d *drain) stopping() context.Context {
+	d.once.Do(func() {
+		d.stop, d.cancel = context.WithCancel(context.Background())
+	})
+
+	return d.stop
+}
+
+// acquireContext returns a context for an acquire which is canceled by ctx or when the service drains
+func (d *drain) acquireContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(d.stopping(), cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func (d *drain) acquired(resource string, lockID string, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.track {
+		return
+	}
+	if d.leases == nil {
+		d.leases = make(map[string]lease)
+	}
+
+	now := time.Now()
+	for r, l := range d.leases {
+		if l.expired(now) {
+			delete(d.leases, r)
+		}
+	}
+
+	l := lease{lockID: lockID}
+	if ttl > 0 {
+		l.expiry = now.Add(ttl)
+	}
+	d.leases[resource] = l
+}
+
+func (d *drain) refreshed(resource string, lockID string, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if l, ok := d.leases[resource]; ok && l.lockID == lockID {
+		d.leases[resource] = lease{lockID: lockID, expiry: time.Now().Add(ttl)}
+	}
+}
+
+func (d *drain) released(resource string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.leases, resource)
+}
+
+// SetReleaseOnShutdown makes the service track the locks acquired through it so ReleaseAll can release them
+func (s *LockService) SetReleaseOnShutdown(release bool) {
+	s.drain.mu.Lock()
+	defer s.drain.mu.Unlock()
+
+	s.drain.track = release
+}
+
+// Drain rejects new acquires with UNAVAILABLE and cancels the retry loops of acquires in flight
+func (s *LockService) Drain() {
+	s.drain.draining.Store(true)
+	s.drain.stopping()
+	s.drain.cancel()
+}
+
+// ReleaseAll releases the unexpired locks acquired through this server, see SetReleaseOnShutdown.
+// It gives up once ctx is done.
+func (s *LockService) ReleaseAll(ctx context.Context) error {
+	s.drain.mu.Lock()
+	leases := s.drain.leases
+	s.drain.leases = nil
+	s.drain.mu.Unlock()
+
+	now := time.Now()
+	failed := 0
+
+	for resource, l := range leases {
+		if l.expired(now) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("failed to release locks on shutdown :: %v", err)
+		}
+
+		err := s.redlock.Unlock(resource, l.lockID)
+		s.record(ctx, audit.ActionRelease, &pb.LockRequest{ResourceId: resource, LockId: l.lockID}, 0, err)
+
+		if err != nil {
+			failed++
+			logger.Warn(ctx, fmt.Sprintf("-> release on shutdown fail :: resource %s :: %v", resource, err))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to release %d locks on shutdown", failed)
+	}
+
+	return nil
+}
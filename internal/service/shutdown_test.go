@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func newTestMemoryService(t *testing.T) (*LockService, *redlock.MemoryStore) {
+	store := redlock.NewMemoryStore()
+	svc, err := NewLockServiceWithStores([]redlock.Store{store})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	return svc, store
+}
+
+func TestLockService_Drain(t *testing.T) {
+	svc, store := newTestMemoryService(t)
+	store.SetIfAbsent(testResourceID, "someoneelse", time.Minute)
+
+	done := make(chan error)
+	go func() {
+		_, err := svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: testResourceID, LockId: testLockID, Ttl: testTTL})
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	svc.Drain()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, codes.Unavailable, status.Code(err), "acquires in flight should be canceled")
+	case <-time.After(time.Second):
+		t.Fatal("acquire in flight was not canceled")
+	}
+
+	_, err := svc.GetLock(context.Background(), &pb.LockRequest{ResourceId: "other", LockId: testLockID, Ttl: testTTL})
+	assert.Equal(t, codes.Unavailable, status.Code(err), "new acquires should be rejected")
+
+	_, err = svc.CheckLock(context.Background(), &pb.LockRequest{ResourceId: testResourceID})
+	assert.NoError(t, err, "other requests should still be served")
+}
+
+func TestLockService_ReleaseAll(t *testing.T) {
+	svc, store := newTestMemoryService(t)
+	svc.SetReleaseOnShutdown(true)
+	ctx := context.Background()
+
+	for _, r := range []string{"a", "b", "c"} {
+		_, err := svc.GetLock(ctx, &pb.LockRequest{ResourceId: r, LockId: testLockID, Ttl: testTTL})
+		assert.NoError(t, err)
+	}
+	_, err := svc.DeleteLock(ctx, &pb.LockRequest{ResourceId: "c", LockId: testLockID})
+	assert.NoError(t, err)
+	store.SetIfAbsent("c", "someoneelse", time.Minute)
+
+	svc.Drain()
+	assert.NoError(t, svc.ReleaseAll(ctx))
+
+	for _, r := range []string{"a", "b"} {
+		_, _, ok, _ := store.Get(r)
+		assert.False(t, ok, "locks acquired through the server should be released")
+	}
+	_, _, ok, _ := store.Get("c")
+	assert.True(t, ok, "locks released before shutdown should not be touched")
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/stoex/go-lock/internal/config"
+	"github.com/stoex/go-lock/internal/logger"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"net/url"
+	"strings"
+)
+
+// DescribeStores returns a description without secrets of every store NewStores creates for c, in the same order
+func DescribeStores(c config.RedlockConfig) []string {
+	switch c.Backend {
+	case BackendRedis, "":
+		nodes, err := c.RedisNodes()
+		if err != nil {
+			return nil
+		}
+		names := make([]string, len(nodes))
+		for i, n := range nodes {
+			names[i] = n.Redacted()
+		}
+		return names
+	case BackendEtcd:
+		return []string{"etcd://" + strings.Join(c.EtcdEndpoints, ",")}
+	case BackendPostgres:
+		if u, err := url.Parse(c.PostgresDSN); err == nil && u.Scheme != "" {
+			return []string{u.Redacted()}
+		}
+		return []string{"postgres"}
+	}
+
+	return []string{c.Backend}
+}
+
+// CheckStores pings every store and logs the effective topology before serving.
+// Unreachable nodes are only reported unless c.Strict is set, then they fail the check.
+func CheckStores(ctx context.Context, c config.RedlockConfig, stores []redlock.Store) error {
+	names := DescribeStores(c)
+	quorum := len(stores)/2 + 1
+	errs := make([]error, len(stores))
+	done := make(chan struct{}, len(stores))
+
+	for i, s := range stores {
+		go func(i int, s redlock.Store) {
+			if p, ok := s.(redlock.Pinger); ok {
+				errs[i] = p.Ping()
+			}
+			done <- struct{}{}
+		}(i, s)
+	}
+	for range stores {
+		<-done
+	}
+
+	reachable := 0
+	for i, err := range errs {
+		name := fmt.Sprintf("node %d", i+1)
+		if i < len(names) {
+			name = names[i]
+		}
+		if err != nil {
+			logger.Warn(ctx, fmt.Sprintf("-> topology :: node %d :: %s :: unreachable :: %v", i+1, name, err))
+			continue
+		}
+		reachable++
+		logger.Info(ctx, fmt.Sprintf("-> topology :: node %d :: %s :: ok", i+1, name))
+	}
+
+	logger.Info(ctx, fmt.Sprintf("-> topology :: backend %s :: nodes %d :: quorum %d :: reachable %d :: tolerated failures %d",
+		c.Backend, len(stores), quorum, reachable, len(stores)-quorum))
+
+	if len(stores) > 1 && len(stores)%2 == 0 {
+		logger.Warn(ctx, fmt.Sprintf("-> topology :: even number of nodes %d tolerates %d failures, the same as %d nodes", len(stores), len(stores)-quorum, len(stores)-1))
+	}
+
+	if reachable < quorum {
+		logger.Warn(ctx, fmt.Sprintf("-> topology :: only %d of %d nodes reachable, no lock can be acquired until %d are", reachable, len(stores), quorum))
+	}
+
+	if c.Strict && reachable < len(stores) {
+		return fmt.Errorf("failed to reach %d of %d lock nodes", len(stores)-reachable, len(stores))
+	}
+
+	return nil
+}
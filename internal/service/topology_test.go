@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"github.com/alicebob/miniredis"
+	"github.com/stoex/go-lock/internal/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckStores(t *testing.T) {
+	var clients []string
+	for i := 0; i < 3; i++ {
+		s, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("could not start miniredis: %s", err.Error())
+		}
+		defer s.Close()
+		s.RequireAuth("secret")
+		clients = append(clients, "redis://:secret@"+s.Addr())
+		if i == 2 {
+			s.Close()
+		}
+	}
+
+	c := config.RedlockConfig{Backend: BackendRedis, Clients: clients}
+	stores, err := NewStores(c)
+	assert.NoError(t, err)
+
+	names := DescribeStores(c)
+	assert.Len(t, names, 3)
+	assert.NotContains(t, names[0], "secret", "passwords should not be logged")
+
+	assert.NoError(t, CheckStores(context.Background(), c, stores), "unreachable nodes should only be reported")
+
+	c.Strict = true
+	assert.Error(t, CheckStores(context.Background(), c, stores), "unreachable nodes should fail in strict mode")
+	assert.NoError(t, CheckStores(context.Background(), c, stores[:2]), "reachable nodes should pass in strict mode")
+}
+
+func TestNewStores_InvalidNode(t *testing.T) {
+	_, err := NewStores(config.RedlockConfig{Backend: BackendRedis, Clients: []string{"memcached://:secret@localhost"}})
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret", "passwords should not be part of errors")
+}
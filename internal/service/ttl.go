@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"github.com/stoex/go-lock/internal/config"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// refreshModes maps the refresh modes of the api to redlock
+var refreshModes = map[pb.LockRequest_RefreshMode]redlock.RefreshMode{
+	pb.LockRequest_RESET:            redlock.RefreshReset,
+	pb.LockRequest_EXTEND:           redlock.RefreshExtend,
+	pb.LockRequest_TO_MAX_LEASE_AGE: redlock.RefreshToMaxLeaseAge,
+}
+
+// requestRefreshMode returns the redlock refresh mode of m
+func requestRefreshMode(m pb.LockRequest_RefreshMode) (redlock.RefreshMode, error) {
+	mode, ok := refreshModes[m]
+	if !ok {
+		return 0, status.Errorf(codes.InvalidArgument, "unknown refresh mode %d", m)
+	}
+
+	return mode, nil
+}
+
+// ConfigureTTL applies the ttl bounds of c, the bounds of the longest matching prefix apply to a lock
+func (s *LockService) ConfigureTTL(c config.TTLConfig) {
+	var policies []redlock.TTLPolicy
+
+	for _, b := range c.Effective() {
+		policies = append(policies, redlock.TTLPolicy{
+			Prefix:        b.Prefix,
+			Min:           b.Min,
+			Max:           b.Max,
+			Default:       b.Default,
+			AllowNoExpiry: b.AllowNoExpiry,
+		})
+	}
+
+	s.redlock.SetTTLPolicies(policies...)
+}
+
+// lockError returns err as grpc status if the lock was held elsewhere, not held by the lock id, not found
+// or the resource is invalid, held locks are reported as ABORTED so callers can tell contention from other failures
+func lockError(err error) error {
+	switch {
+	case errors.Is(err, redlock.ErrInvalidResource):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, redlock.ErrNotAcquired):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, redlock.ErrNotHeld):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, redlock.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	}
+
+	return ttlError(err)
+}
+
+// ttlError returns err as grpc status if the ttl policy or the max lease age rejected the request
+func ttlError(err error) error {
+	switch {
+	case errors.Is(err, redlock.ErrInvalidTTL):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, redlock.ErrNoExpiry):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, redlock.ErrLeaseExceeded), errors.Is(err, redlock.ErrNoMaxLeaseAge):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return err
+}
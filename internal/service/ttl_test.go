@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"github.com/stoex/go-lock/internal/config"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestLockService_TTL(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1})
+
+	ctx := context.Background()
+
+	res, err := svc.GetLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID})
+	assert.NoError(t, err)
+	assert.InDelta(t, config.DefaultTTL.Seconds(), res.Ttl, 1, "new services should apply the default ttl")
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "huge", LockId: testLockID, Ttl: 4294967295})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "new services should apply the default max ttl")
+
+	svc.ConfigureTTL(config.TTLConfig{
+		TTLBounds: config.TTLBounds{Min: 5 * time.Second, Max: time.Minute, Default: 10 * time.Second},
+		Prefixes: []config.PrefixTTLConfig{
+			{Prefix: "pinned/", TTLBounds: config.TTLBounds{AllowNoExpiry: true}},
+		},
+	})
+
+	res, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "b", LockId: testLockID})
+	assert.NoError(t, err)
+	assert.InDelta(t, 10, res.Ttl, 1, "the configured default should apply")
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "c", LockId: testLockID, Ttl: 3600})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "ttls above the max should be rejected")
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "c", LockId: testLockID, Ttl: 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "ttls below the min should be rejected")
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "c", LockId: testLockID, NoExpiry: true})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "locks without expiry should need an allowing prefix")
+
+	res, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "pinned/1", LockId: testLockID, NoExpiry: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), res.Ttl, "locks without expiry should have no ttl")
+
+	res, err = svc.CheckLock(ctx, &pb.LockRequest{ResourceId: "pinned/1"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), res.Ttl, "the lock should not expire")
+
+	_, err = svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: "b", LockId: testLockID, Ttl: 3600})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "refreshes should be bounded")
+
+	_, err = svc.TakeOver(ctx, &pb.LockRequest{ResourceId: "b", LockId: "other", Ttl: 3600})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "take overs should be bounded")
+}
+
+func TestLockService_RefreshModes(t *testing.T) {
+	svc, err := NewLockServiceWithStores([]redlock.Store{redlock.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+	svc.Configure(config.RedlockConfig{RetryCount: 1})
+
+	ctx := context.Background()
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID, Ttl: 10, MaxLeaseAge: 5})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "the ttl should be within the max lease age")
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID, Ttl: 10, MaxLeaseAge: uint32(config.DefaultMaxTTL.Seconds()) + 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "the max lease age should be within the max ttl")
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID, Ttl: 10, MaxLeaseAge: 60})
+	assert.NoError(t, err)
+
+	res, err := svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID, Ttl: 20, RefreshMode: pb.LockRequest_EXTEND})
+	assert.NoError(t, err)
+	assert.InDelta(t, 29, res.Ttl, 1, "extending should add to the remaining ttl")
+
+	res, err = svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID, RefreshMode: pb.LockRequest_TO_MAX_LEASE_AGE})
+	assert.NoError(t, err)
+	assert.InDelta(t, 59, res.Ttl, 1, "the lock should be kept until the max lease age")
+
+	_, err = svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID, Ttl: 120})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "refreshes past the max lease age should be rejected")
+
+	_, err = svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID, RefreshMode: pb.LockRequest_EXTEND})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "extending should need a ttl")
+
+	_, err = svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: "a", LockId: testLockID, RefreshMode: 42})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "unknown modes should be rejected")
+
+	_, err = svc.GetLock(ctx, &pb.LockRequest{ResourceId: "b", LockId: testLockID, Ttl: 10})
+	assert.NoError(t, err)
+	_, err = svc.RefreshLock(ctx, &pb.LockRequest{ResourceId: "b", LockId: testLockID, RefreshMode: pb.LockRequest_TO_MAX_LEASE_AGE})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "locks without max lease age can not be kept until it")
+}
@@ -0,0 +1,235 @@
+package client
+
+import (
+	"context"
+	"errors"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// ErrLockLost is returned by WithLock if the lock could not be refreshed while fn was running
+var ErrLockLost = errors.New("lock lost")
+
+// Client acquires locks from a go-lock server
+type Client struct {
+	lock pb.LockServiceClient
+}
+
+// New returns a pointer to a Client using the given connection
+func New(conn *grpc.ClientConn) *Client {
+	return &Client{lock: pb.NewLockServiceClient(conn)}
+}
+
+// Handle represents an acquired lock which is refreshed in the background until released
+type Handle struct {
+	client   *Client
+	resource string
+	lockID   string
+	opts     *options
+	// md is the outgoing metadata of the Acquire ctx, it is sent with every refresh
+	md metadata.MD
+
+	lost     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Acquire acquires the lock for resource, retrying with exponential backoff,
+// and starts refreshing it in the background. Only errors which can go away on their own
+// (the lock is held, the server is unavailable or rate limited) are retried.
+// The outgoing metadata of ctx is also sent with the background refreshes.
+func (c *Client) Acquire(ctx context.Context, resource string, opts ...Option) (*Handle, error) {
+	o := newOptions(opts)
+
+	if o.lockID == "" {
+		id, err := redlock.NewToken()
+		if err != nil {
+			return nil, err
+		}
+		o.lockID = id
+	}
+
+	req := &pb.LockRequest{ResourceId: resource, LockId: o.lockID, Ttl: o.ttlSeconds()}
+	var resp *pb.LockResponse
+	var err error
+	var start time.Time
+
+	for i := 0; i <= o.retries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-o.clock.After(o.backoff(i - 1)):
+			}
+		}
+
+		start = o.clock.Now()
+		if resp, err = c.lock.GetLock(ctx, req); err == nil || !retryable(err) {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	h := &Handle{
+		client:   c,
+		resource: resource,
+		lockID:   o.lockID,
+		opts:     o,
+		md:       md,
+		lost:     make(chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go h.refresh(o.expiry(start, resp))
+
+	return h, nil
+}
+
+// WithLock acquires the lock for resource, runs fn and releases the lock afterwards.
+// The context passed to fn is cancelled if the lock is lost, in which case ErrLockLost is returned
+// unless fn returned an error itself.
+func (c *Client) WithLock(ctx context.Context, resource string, fn func(ctx context.Context) error, opts ...Option) error {
+	h, err := c.Acquire(ctx, resource, opts...)
+
+	if err != nil {
+		return err
+	}
+
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-h.Lost():
+			cancel()
+		case <-fnCtx.Done():
+		}
+	}()
+
+	err = fn(fnCtx)
+
+	select {
+	case <-h.Lost():
+		if err == nil {
+			err = ErrLockLost
+		}
+		return err
+	default:
+	}
+
+	if releaseErr := h.Release(context.Background()); err == nil {
+		err = releaseErr
+	}
+
+	return err
+}
+
+// Resource returns the locked resource
+func (h *Handle) Resource() string {
+	return h.resource
+}
+
+// LockID returns the id (owner token) of the lock
+func (h *Handle) LockID() string {
+	return h.lockID
+}
+
+// Lost returns a channel which is closed once the lock is gone or its ttl passed without a successful refresh
+func (h *Handle) Lost() <-chan struct{} {
+	return h.lost
+}
+
+// Release stops refreshing and releases the lock
+func (h *Handle) Release(ctx context.Context) error {
+	h.stopOnce.Do(func() { close(h.stop) })
+	<-h.done
+
+	_, err := h.client.lock.DeleteLock(ctx, &pb.LockRequest{ResourceId: h.resource, LockId: h.lockID})
+
+	return err
+}
+
+// refresh refreshes the lock every refresh interval until released. Failed refreshes are retried with backoff
+// while the lock may still be held, the lock is lost once the server reports it gone or its ttl passed.
+func (h *Handle) refresh(expires time.Time) {
+	defer close(h.done)
+
+	req := &pb.LockRequest{ResourceId: h.resource, LockId: h.lockID, Ttl: h.opts.ttlSeconds()}
+	wait := h.opts.refreshInterval
+	failures := 0
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-h.opts.clock.After(wait):
+		}
+
+		start := h.opts.clock.Now()
+		ctx, cancel := context.WithTimeout(h.refreshContext(), h.opts.refreshInterval)
+		resp, err := h.client.lock.RefreshLock(ctx, req)
+		cancel()
+
+		if err == nil {
+			expires = h.opts.expiry(start, resp)
+			wait = h.opts.refreshInterval
+			failures = 0
+			continue
+		}
+
+		remaining := expires.Sub(h.opts.clock.Now())
+		if lost(err) || remaining <= 0 {
+			close(h.lost)
+			return
+		}
+
+		wait = h.opts.backoff(failures)
+		if wait > remaining {
+			wait = remaining
+		}
+		failures++
+	}
+}
+
+// refreshContext returns the context refreshes are made with, carrying the metadata of the Acquire ctx
+func (h *Handle) refreshContext() context.Context {
+	if h.md == nil {
+		return context.Background()
+	}
+
+	return metadata.NewOutgoingContext(context.Background(), h.md)
+}
+
+// retryable returns whether acquiring a lock may succeed when retried after err
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Aborted, codes.Unavailable, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// lost returns whether the lock is gone after refreshing failed with err, the server no longer knows the lock
+// or it is held by someone else. Transient errors (see retryable), timeouts and other failures say nothing about
+// the lock and are retried.
+func lost(err error) bool {
+	switch status.Code(err) {
+	case codes.NotFound, codes.FailedPrecondition:
+		return true
+	default:
+		return false
+	}
+}
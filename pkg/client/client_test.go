@@ -0,0 +1,337 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis"
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/internal/service"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"github.com/stoex/go-lock/pkg/redlock/redlocktest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	testResourceID = "resource"
+	bufSize        = 1024 * 1024
+)
+
+// newTestClient starts an in-process lock server backed by three miniredis nodes
+func newTestClient(t *testing.T) (*Client, []*miniredis.Miniredis, func()) {
+	var nodes []*miniredis.Miniredis
+	var addr []string
+
+	for i := 0; i < 3; i++ {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("could not start miniredis: %s", err.Error())
+		}
+		nodes = append(nodes, mr)
+		addr = append(addr, "redis://"+mr.Addr())
+	}
+
+	svc, err := service.NewLockService(addr)
+	if err != nil {
+		t.Fatalf("could not create lock service: %s", err.Error())
+	}
+
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	pb.RegisterLockServiceServer(s, svc)
+	go s.Serve(lis)
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+
+	return New(conn), nodes, func() {
+		conn.Close()
+		s.Stop()
+		for _, mr := range nodes {
+			mr.Close()
+		}
+	}
+}
+
+func TestClient_Acquire(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	h, err := c.Acquire(ctx, testResourceID)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	assert.Equal(t, testResourceID, h.Resource())
+	assert.NotEmpty(t, h.LockID(), "a lock id should be generated")
+
+	_, err = c.Acquire(ctx, testResourceID, WithRetries(0))
+	assert.Error(t, err, "a held lock should not be acquired twice")
+
+	assert.NoError(t, h.Release(ctx))
+
+	h, err = c.Acquire(ctx, testResourceID, WithRetries(0))
+	assert.NoError(t, err, "a released lock should be acquirable")
+	assert.NoError(t, h.Release(ctx))
+}
+
+func TestClient_AcquireRetries(t *testing.T) {
+	c, _, cleanup := newTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+
+	h, err := c.Acquire(ctx, testResourceID, WithTTL(5*time.Second))
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		h2, err := c.Acquire(ctx, testResourceID, WithRetries(10), WithClock(clock))
+		if h2 != nil {
+			h2.Release(ctx)
+		}
+		acquired <- err
+	}()
+
+	clock.BlockUntil(1)
+	assert.NoError(t, h.Release(ctx))
+	clock.Advance(DefaultMaxBackoff)
+
+	assert.NoError(t, <-acquired, "the lock should be acquired once released")
+	waits := clock.Waits()
+	if assert.NotEmpty(t, waits, "acquiring should be retried while the lock is held") {
+		assert.True(t, waits[0] <= DefaultMinBackoff, "the first retry should wait at most the min backoff")
+	}
+}
+
+type fakeLockClient struct {
+	pb.LockServiceClient
+	errs  []error
+	calls int
+
+	// refreshErrs are returned by RefreshLock in order, the last one is repeated
+	refreshErrs []error
+	refreshes   int
+}
+
+func (f *fakeLockClient) GetLock(context.Context, *pb.LockRequest, ...grpc.CallOption) (*pb.LockResponse, error) {
+	err := f.errs[f.calls%len(f.errs)]
+	f.calls++
+	return &pb.LockResponse{}, err
+}
+
+func (f *fakeLockClient) RefreshLock(context.Context, *pb.LockRequest, ...grpc.CallOption) (*pb.LockResponse, error) {
+	err := f.refreshErrs[min(f.refreshes, len(f.refreshErrs)-1)]
+	f.refreshes++
+	return &pb.LockResponse{}, err
+}
+
+func (f *fakeLockClient) DeleteLock(context.Context, *pb.LockRequest, ...grpc.CallOption) (*pb.LockResponse, error) {
+	return &pb.LockResponse{}, nil
+}
+
+func TestClient_AcquireRetryableErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"held", status.Error(codes.Aborted, "lock not acquired"), 4},
+		{"unavailable", status.Error(codes.Unavailable, "shutting down"), 4},
+		{"rate limited", status.Error(codes.ResourceExhausted, "too many locks"), 4},
+		{"denied", status.Error(codes.PermissionDenied, "denied"), 1},
+		{"invalid", status.Error(codes.InvalidArgument, "invalid ttl"), 1},
+		{"internal", status.Error(codes.Internal, "boom"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := redlocktest.NewClock(time.Unix(0, 0))
+			clock.AutoAdvance(true)
+			fake := &fakeLockClient{errs: []error{tt.err}}
+			c := &Client{lock: fake}
+
+			_, err := c.Acquire(context.Background(), testResourceID, WithRetries(3), WithClock(clock))
+
+			assert.Equal(t, status.Code(tt.err), status.Code(err))
+			assert.Equal(t, tt.calls, fake.calls)
+		})
+	}
+}
+
+func TestHandle_Refresh(t *testing.T) {
+	c, nodes, cleanup := newTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+
+	h, err := c.Acquire(ctx, testResourceID, WithTTL(5*time.Second), WithRefreshInterval(time.Second), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+		clock.BlockUntil(1)
+		for _, mr := range nodes {
+			mr.FastForward(4900 * time.Millisecond)
+		}
+	}
+
+	select {
+	case <-h.Lost():
+		t.Fatal("lock should not be lost while refreshing")
+	default:
+	}
+
+	for _, mr := range nodes {
+		assert.True(t, mr.Exists(testResourceID), "refreshed lock should not expire")
+	}
+
+	assert.NoError(t, h.Release(ctx))
+}
+
+func TestHandle_Lost(t *testing.T) {
+	c, nodes, cleanup := newTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+
+	h, err := c.Acquire(ctx, testResourceID, WithRefreshInterval(time.Second), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	for _, mr := range nodes {
+		mr.FlushAll()
+	}
+
+	// the refresh may time out while the server retries, the lock is lost at the latest once its ttl passed
+	clock.BlockUntil(1)
+	clock.AutoAdvance(true)
+	clock.Advance(time.Second)
+
+	select {
+	case <-h.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost should be closed once refreshing fails")
+	}
+}
+
+func TestHandle_RefreshRetries(t *testing.T) {
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+	unavailable := status.Error(codes.Unavailable, "shutting down")
+	fake := &fakeLockClient{errs: []error{nil}, refreshErrs: []error{unavailable, unavailable, nil}}
+	c := &Client{lock: fake}
+
+	h, err := c.Acquire(context.Background(), testResourceID, WithTTL(3*time.Second), WithRefreshInterval(time.Second),
+		WithClock(clock), WithRand(redlock.NewRand(1)))
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(DefaultMinBackoff << uint(i))
+	}
+	clock.BlockUntil(1)
+
+	select {
+	case <-h.Lost():
+		t.Fatal("transient errors should be retried while the ttl lasts")
+	default:
+	}
+
+	assert.NoError(t, h.Release(context.Background()))
+	assert.Equal(t, 3, fake.refreshes)
+	waits := clock.Waits()
+	if assert.Len(t, waits, 4) {
+		assert.True(t, waits[1] <= DefaultMinBackoff, "the first retry should wait at most the min backoff")
+		assert.Equal(t, time.Second, waits[3], "a successful retry should return to the refresh interval")
+	}
+}
+
+func TestHandle_RefreshLost(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		refreshes int
+	}{
+		{"not found", status.Error(codes.NotFound, "lock not found"), 1},
+		{"not held", status.Error(codes.FailedPrecondition, "lock not held"), 1},
+		{"unavailable", status.Error(codes.Unavailable, "shutting down"), 0},
+		{"internal", status.Error(codes.Internal, "boom"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := redlocktest.NewClock(time.Unix(0, 0))
+			clock.AutoAdvance(true)
+			fake := &fakeLockClient{errs: []error{nil}, refreshErrs: []error{tt.err}}
+			c := &Client{lock: fake}
+
+			h, err := c.Acquire(context.Background(), testResourceID, WithTTL(3*time.Second), WithRefreshInterval(time.Second),
+				WithClock(clock), WithRand(redlock.NewRand(1)))
+			if err != nil {
+				t.Fatalf("Acquire failed: %v", err)
+			}
+
+			select {
+			case <-h.Lost():
+			case <-time.After(5 * time.Second):
+				t.Fatal("lost should be closed")
+			}
+
+			if tt.refreshes > 0 {
+				assert.Equal(t, tt.refreshes, fake.refreshes, "a gone lock should not be refreshed again")
+			} else {
+				assert.True(t, fake.refreshes > 1, "transient errors should be retried")
+				assert.False(t, clock.Now().Before(time.Unix(3, 0)), "the lock should only be lost once the ttl passed")
+			}
+		})
+	}
+}
+
+func TestClient_WithLock(t *testing.T) {
+	c, nodes, cleanup := newTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	called := false
+	err := c.WithLock(ctx, testResourceID, func(ctx context.Context) error {
+		called = true
+		for _, mr := range nodes {
+			assert.True(t, mr.Exists(testResourceID), "lock should be held while fn runs")
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, called, "fn should be called")
+	for _, mr := range nodes {
+		assert.False(t, mr.Exists(testResourceID), "lock should be released after fn returns")
+	}
+
+	fnErr := errors.New("boom")
+	err = c.WithLock(ctx, testResourceID, func(ctx context.Context) error {
+		return fnErr
+	})
+	assert.Equal(t, fnErr, err, "errors of fn should be returned")
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	pb "github.com/stoex/go-lock/internal/generated/lockv1"
+	"github.com/stoex/go-lock/pkg/redlock"
+	"time"
+)
+
+const (
+	// DefaultTTL is the ttl of a lock if none is given
+	DefaultTTL = 30 * time.Second
+
+	// DefaultRetries is the number of times acquiring a lock is retried
+	DefaultRetries = 3
+
+	// DefaultMinBackoff is the first wait time between acquire attempts
+	DefaultMinBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the upper bound of the wait time between acquire attempts
+	DefaultMaxBackoff = 2 * time.Second
+)
+
+type options struct {
+	ttl             time.Duration
+	lockID          string
+	refreshInterval time.Duration
+	retries         int
+	minBackoff      time.Duration
+	maxBackoff      time.Duration
+	clock           redlock.Clock
+	rand            redlock.Rand
+}
+
+// Option configures how a lock is acquired and kept
+type Option func(*options)
+
+// WithTTL sets the ttl of the lock, it is rounded up to full seconds
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		if ttl > 0 {
+			o.ttl = ttl
+		}
+	}
+}
+
+// WithLockID sets the lock id (owner token), a random id is generated otherwise
+func WithLockID(id string) Option {
+	return func(o *options) {
+		o.lockID = id
+	}
+}
+
+// WithRefreshInterval sets how often the lock is refreshed in the background.
+// The default is a third of the ttl.
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.refreshInterval = interval
+		}
+	}
+}
+
+// WithRetries sets how often acquiring the lock is retried, 0 tries exactly once
+func WithRetries(retries int) Option {
+	return func(o *options) {
+		if retries >= 0 {
+			o.retries = retries
+		}
+	}
+}
+
+// WithBackoff sets the bounds of the exponential backoff between acquire attempts
+func WithBackoff(min time.Duration, max time.Duration) Option {
+	return func(o *options) {
+		if min > 0 && max >= min {
+			o.minBackoff = min
+			o.maxBackoff = max
+		}
+	}
+}
+
+// WithClock sets the clock timing the backoff and the refreshes, tests use a redlocktest.Clock
+func WithClock(c redlock.Clock) Option {
+	return func(o *options) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
+
+// WithRand sets the source of the backoff jitter, e.g. redlock.NewRand with a fixed seed for reproducible tests
+func WithRand(rnd redlock.Rand) Option {
+	return func(o *options) {
+		if rnd != nil {
+			o.rand = rnd
+		}
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		ttl:        DefaultTTL,
+		retries:    DefaultRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		clock:      redlock.SystemClock(),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.rand == nil {
+		o.rand = redlock.NewRand(redlock.NewSeed())
+	}
+
+	if o.refreshInterval == 0 || o.refreshInterval >= o.ttl {
+		o.refreshInterval = o.ttl / 3
+	}
+
+	return o
+}
+
+// ttlSeconds returns the ttl rounded up to full seconds as expected by the lock service
+func (o *options) ttlSeconds() uint32 {
+	return uint32((o.ttl + time.Second - 1) / time.Second)
+}
+
+// expiry returns when a lock acquired or refreshed at start expires, the server may have shortened the ttl
+func (o *options) expiry(start time.Time, resp *pb.LockResponse) time.Time {
+	if resp != nil && resp.Ttl > 0 {
+		return start.Add(time.Duration(resp.Ttl) * time.Second)
+	}
+
+	return start.Add(o.ttl)
+}
+
+// backoff returns the wait time before the given retry using exponential backoff with full jitter
+func (o *options) backoff(retry int) time.Duration {
+	max := o.minBackoff << uint(retry)
+
+	if max > o.maxBackoff || max <= 0 {
+		max = o.maxBackoff
+	}
+
+	return time.Duration(o.rand.Int63n(int64(max)) + 1)
+}
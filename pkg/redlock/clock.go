@@ -0,0 +1,27 @@
+package redlock
+
+import "time"
+
+// Clock tells the time and waits. Redlock uses it for the validity of locks, the waits between retries
+// and the extension of a Mutex, tests replace it (see redlocktest.Clock) to control the timing.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After returns a channel receiving the time once d passed
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// SystemClock returns the Clock of the operating system
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
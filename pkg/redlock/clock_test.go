@@ -0,0 +1,122 @@
+package redlock
+
+import (
+	"context"
+	"github.com/stoex/go-lock/pkg/redlock/redlocktest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// slowStore is a MemoryStore whose writes take delay on the fake clock
+type slowStore struct {
+	*MemoryStore
+	clock *redlocktest.Clock
+	delay time.Duration
+}
+
+func (s *slowStore) SetIfAbsent(key string, value string, ttl time.Duration) (bool, error) {
+	s.clock.Advance(s.delay)
+	return s.MemoryStore.SetIfAbsent(key, value, ttl)
+}
+
+func (s *slowStore) SetIfExists(key string, value string, ttl time.Duration) (bool, error) {
+	s.clock.Advance(s.delay)
+	return s.MemoryStore.SetIfExists(key, value, ttl)
+}
+
+// newClockRedlock returns a redlock on three memory stores timed by a fake clock, every write takes delay
+func newClockRedlock(delay time.Duration) (*Redlock, []*slowStore, *redlocktest.Clock) {
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+	manager := NewRedlock()
+	manager.SetClock(clock)
+	manager.SetRetryCount(1)
+
+	var stores []*slowStore
+	for i := 0; i < 3; i++ {
+		s := &slowStore{MemoryStore: NewMemoryStoreWithClock(clock), clock: clock, delay: delay}
+		stores = append(stores, s)
+		_ = manager.AddStore(s)
+	}
+
+	return manager, stores, clock
+}
+
+func TestRedlock_ValidityDrift(t *testing.T) {
+	redlock, _, clock := newClockRedlock(time.Second)
+	ctx := context.Background()
+
+	// acquiring takes 3s, the drift is 1% of the ttl plus 2ms
+	m := redlock.NewMutex(testResourceID, &MutexOptions{TTL: 10 * time.Second})
+	assert.NoError(t, m.TryLock(ctx))
+	assert.Equal(t, time.Unix(0, 0).Add(6898*time.Millisecond), m.Until(), "validity should subtract elapsed time and drift")
+	assert.NoError(t, m.Unlock(ctx))
+
+	redlock.SetDriftFactor(0.1)
+	start := clock.Now()
+	assert.NoError(t, m.TryLock(ctx))
+	assert.Equal(t, start.Add(5998*time.Millisecond), m.Until(), "the drift factor should apply")
+	assert.NoError(t, m.Unlock(ctx))
+
+	ttl, err := redlock.Lock("other", testLockID, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), ttl, "the validity should be rounded to whole seconds")
+}
+
+func TestRedlock_ValidityExceeded(t *testing.T) {
+	redlock, stores, _ := newClockRedlock(3300 * time.Millisecond)
+
+	_, err := redlock.Lock(testResourceID, testLockID, 10)
+	assert.Error(t, err, "locks acquired slower than their ttl should fail")
+
+	// the release is not awaited by Lock
+	for _, s := range stores {
+		assert.Eventually(t, func() bool {
+			_, _, ok, _ := s.Get(testResourceID)
+			return !ok
+		}, time.Second, time.Millisecond, "the partially acquired lock should be released")
+	}
+}
+
+func TestMutex_Expiry(t *testing.T) {
+	redlock, _, clock := newClockRedlock(0)
+	ctx := context.Background()
+
+	m := redlock.NewMutex(testResourceID, &MutexOptions{TTL: 3 * time.Second})
+	assert.NoError(t, m.TryLock(ctx))
+	assert.True(t, m.Valid())
+
+	clock.Advance(2968 * time.Millisecond)
+	assert.False(t, m.Valid(), "the mutex should expire before the nodes to account for drift")
+
+	other := redlock.NewMutex(testResourceID, nil)
+	assert.Equal(t, ErrNotAcquired, other.TryLock(ctx), "the nodes should still hold the lock")
+
+	clock.Advance(32 * time.Millisecond)
+	assert.NoError(t, other.TryLock(ctx), "expired locks should be acquired")
+}
+
+func TestMutex_AutoExtendClock(t *testing.T) {
+	redlock, stores, clock := newClockRedlock(0)
+	ctx := context.Background()
+
+	m := redlock.NewMutex(testResourceID, &MutexOptions{TTL: 3 * time.Second, AutoExtend: true, ExtendInterval: time.Second})
+	assert.NoError(t, m.TryLock(ctx))
+	defer m.Unlock(ctx)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	assert.Equal(t, time.Unix(0, 0).Add(3968*time.Millisecond), m.Until(), "the lock should be extended after the interval")
+
+	for _, s := range stores {
+		_ = s.Delete(testResourceID)
+	}
+	clock.Advance(time.Second)
+
+	select {
+	case <-m.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lost should be closed once extending fails")
+	}
+}
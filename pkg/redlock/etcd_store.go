@@ -0,0 +1,293 @@
+package redlock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// DefaultEtcdTimeout is the time a single etcd request may take
+	DefaultEtcdTimeout = 5 * time.Second
+	// DefaultEtcdPrefix is the namespace of the lock keys in the etcd keyspace
+	DefaultEtcdPrefix = "go-lock/"
+)
+
+// ErrEtcdPrefix is returned by NewEtcdStore without a key prefix
+var ErrEtcdPrefix = errors.New("etcd key prefix is required")
+
+// EtcdStore is a Store backed by an etcd cluster. Keys expire through leases and every
+// conditional operation is a single transaction. Note that etcd enforces a minimum lease ttl,
+// shorter ttls are rounded up by the server.
+// All keys live below a prefix, keys of the cluster outside of it are neither listed nor changed.
+type EtcdStore struct {
+	client  *clientv3.Client
+	prefix  string
+	timeout time.Duration
+}
+
+// NewEtcdClient returns a pointer to a new etcd client connected to the given cluster endpoints
+func NewEtcdClient(endpoints []string) (*clientv3.Client, error) {
+	return clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: DefaultEtcdTimeout,
+	})
+}
+
+// NewEtcdStore returns a pointer to an EtcdStore using client which keeps its keys below prefix
+func NewEtcdStore(client *clientv3.Client, prefix string) (*EtcdStore, error) {
+	if prefix == "" {
+		return nil, ErrEtcdPrefix
+	}
+
+	return &EtcdStore{client: client, prefix: prefix, timeout: DefaultEtcdTimeout}, nil
+}
+
+// key returns the etcd key of a store key
+func (s *EtcdStore) key(key string) string {
+	return s.prefix + key
+}
+
+// grant returns the options attaching a key to a new lease with ttl, a ttl of 0 does not expire
+func (s *EtcdStore) grant(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, []clientv3.OpOption, error) {
+	if ttl <= 0 {
+		return clientv3.NoLease, nil, nil
+	}
+
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	lease, err := s.client.Grant(ctx, seconds)
+
+	if err != nil {
+		return clientv3.NoLease, nil, err
+	}
+
+	return lease.ID, []clientv3.OpOption{clientv3.WithLease(lease.ID)}, nil
+}
+
+// revoke releases a lease which is no longer attached to any key
+func (s *EtcdStore) revoke(ctx context.Context, id clientv3.LeaseID) {
+	if id != clientv3.NoLease {
+		_, _ = s.client.Revoke(ctx, id)
+	}
+}
+
+// put stores value under key with a new lease if cmp holds
+func (s *EtcdStore) put(key string, value string, ttl time.Duration, cmp clientv3.Cmp) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	lease, opts, err := s.grant(ctx, ttl)
+
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := s.client.Txn(ctx).
+		If(cmp).
+		Then(clientv3.OpPut(key, value, opts...)).
+		Commit()
+
+	if err != nil || !resp.Succeeded {
+		s.revoke(ctx, lease)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetIfAbsent creates key if it does not exist yet
+func (s *EtcdStore) SetIfAbsent(key string, value string, ttl time.Duration) (bool, error) {
+	key = s.key(key)
+	return s.put(key, value, ttl, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+}
+
+// SetIfExists replaces the value of an existing key, keeping its lease if ttl is 0
+func (s *EtcdStore) SetIfExists(key string, value string, ttl time.Duration) (bool, error) {
+	key = s.key(key)
+	if ttl > 0 {
+		return s.put(key, value, ttl, clientv3.Compare(clientv3.CreateRevision(key), ">", 0))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0)).
+		Then(clientv3.OpPut(key, value, clientv3.WithIgnoreLease())).
+		Commit()
+
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Succeeded, nil
+}
+
+// DeleteIfOwner deletes key in a transaction comparing its value
+func (s *EtcdStore) DeleteIfOwner(key string, value string) (bool, error) {
+	key = s.key(key)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", value)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Succeeded, nil
+}
+
+// ExtendIfOwner attaches key to a new lease with ttl if it holds value and revokes the old lease
+func (s *EtcdStore) ExtendIfOwner(key string, value string, ttl time.Duration) (bool, error) {
+	key = s.key(key)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	current, err := s.client.Get(ctx, key)
+
+	if err != nil || len(current.Kvs) == 0 || string(current.Kvs[0].Value) != value {
+		return false, err
+	}
+
+	ok, err := s.put(key, value, ttl, clientv3.Compare(clientv3.ModRevision(key), "=", current.Kvs[0].ModRevision))
+
+	if ok {
+		s.revoke(ctx, clientv3.LeaseID(current.Kvs[0].Lease))
+	}
+
+	return ok, err
+}
+
+// ExtendByIfOwner attaches key to a new lease with its remaining ttl plus delta if it holds value and the new
+// ttl is within max. The transaction compares the revision read, so it is retried if the key changed meanwhile.
+func (s *EtcdStore) ExtendByIfOwner(key string, value string, delta time.Duration, max time.Duration) (time.Duration, bool, error) {
+	key = s.key(key)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	for {
+		current, err := s.client.Get(ctx, key)
+
+		if err != nil || len(current.Kvs) == 0 || string(current.Kvs[0].Value) != value || current.Kvs[0].Lease == 0 {
+			return 0, false, err
+		}
+
+		lease, err := s.client.TimeToLive(ctx, clientv3.LeaseID(current.Kvs[0].Lease))
+
+		if err != nil || lease.TTL <= 0 {
+			return 0, false, err
+		}
+
+		ttl := time.Duration(lease.TTL)*time.Second + delta
+		if max > 0 && ttl > max {
+			return ttl, false, nil
+		}
+
+		ok, err := s.put(key, value, ttl, clientv3.Compare(clientv3.ModRevision(key), "=", current.Kvs[0].ModRevision))
+
+		if err != nil {
+			return 0, false, err
+		}
+		if ok {
+			s.revoke(ctx, clientv3.LeaseID(current.Kvs[0].Lease))
+			return ttl, true, nil
+		}
+	}
+}
+
+// Get returns the value of key and the remaining ttl of its lease
+func (s *EtcdStore) Get(key string) (string, time.Duration, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, s.key(key))
+
+	if err != nil || len(resp.Kvs) == 0 {
+		return "", 0, false, err
+	}
+
+	kv := resp.Kvs[0]
+
+	if kv.Lease == 0 {
+		return string(kv.Value), 0, true, nil
+	}
+
+	lease, err := s.client.TimeToLive(ctx, clientv3.LeaseID(kv.Lease))
+
+	if err != nil {
+		return "", 0, false, err
+	}
+	// the lease expired after the key was read
+	if lease.TTL <= 0 {
+		return "", 0, false, nil
+	}
+
+	return string(kv.Value), time.Duration(lease.TTL) * time.Second, true, nil
+}
+
+// List returns every key starting with prefix and the remaining ttl of its lease
+func (s *EtcdStore) List(prefix string) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, s.key(prefix), clientv3.WithPrefix())
+
+	if err != nil {
+		return nil, err
+	}
+
+	ttls := make(map[int64]time.Duration)
+	entries := make([]Entry, 0, len(resp.Kvs))
+
+	for _, kv := range resp.Kvs {
+		e := Entry{Key: strings.TrimPrefix(string(kv.Key), s.prefix), Value: string(kv.Value)}
+
+		if kv.Lease != 0 {
+			ttl, ok := ttls[kv.Lease]
+			if !ok {
+				lease, err := s.client.TimeToLive(ctx, clientv3.LeaseID(kv.Lease))
+				if err != nil {
+					return nil, err
+				}
+				// the lease expired after the key was read
+				if lease.TTL <= 0 {
+					continue
+				}
+				ttl = time.Duration(lease.TTL) * time.Second
+				ttls[kv.Lease] = ttl
+			}
+			e.TTL = ttl
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Delete deletes key
+func (s *EtcdStore) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.client.Delete(ctx, s.key(key))
+
+	return err
+}
+
+// Ping checks that the etcd cluster answers a read
+func (s *EtcdStore) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.client.Get(ctx, s.prefix)
+
+	return err
+}
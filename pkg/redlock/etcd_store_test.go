@@ -0,0 +1,176 @@
+package redlock
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not find a free port: %s", err.Error())
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// startTestEtcd starts an embedded etcd server and returns its client url
+func startTestEtcd(t *testing.T) (string, func()) {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("could not start etcd: %s", err.Error())
+	}
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		t.Fatal("etcd did not become ready")
+	}
+
+	return clientURL.String(), e.Close
+}
+
+// newTestEtcdStore returns a store below a prefix of its own on an embedded etcd server, or on the cluster given by
+// ETCD_TEST_ENDPOINTS if it is set. Keys below the prefix are deleted on cleanup.
+func newTestEtcdStore(t *testing.T) (*EtcdStore, func()) {
+	stop := func() {}
+	endpoints := os.Getenv("ETCD_TEST_ENDPOINTS")
+	if endpoints == "" {
+		endpoints, stop = startTestEtcd(t)
+	}
+
+	client, err := NewEtcdClient(strings.Split(endpoints, ","))
+	if err != nil {
+		stop()
+		t.Fatalf("could not create etcd client: %s", err.Error())
+	}
+
+	prefix := "go-lock-test/" + t.Name() + "/"
+	store, err := NewEtcdStore(client, prefix)
+	if err != nil {
+		client.Close()
+		stop()
+		t.Fatalf("could not create etcd store: %s", err.Error())
+	}
+
+	return store, func() {
+		client.Delete(context.Background(), prefix, clientv3.WithPrefix())
+		client.Close()
+		stop()
+	}
+}
+
+func TestEtcdStore_Redlock(t *testing.T) {
+	store, cleanup := newTestEtcdStore(t)
+	defer cleanup()
+
+	redlock := NewRedlock()
+	redlock.SetRetryCount(2)
+	if err := redlock.AddStore(store); err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+
+	ttl, err := redlock.Lock(testResourceID, testLockID, testTTL)
+	assert.NoError(t, err, "lock should succeed")
+	assert.LessOrEqual(t, int(ttl), testTTL)
+
+	_, err = redlock.Lock(testResourceID, "someoneelse", testTTL)
+	assert.Error(t, err, "a held lock should not be acquired twice")
+
+	_, err = redlock.Refresh(testResourceID, testLockID, 2*testTTL)
+	assert.NoError(t, err, "refresh should succeed")
+
+	_, err = redlock.Refresh(testResourceID, "someoneelse", testTTL)
+	assert.Error(t, err, "refresh of a foreign lock should fail")
+
+	l, err := redlock.Check(testResourceID)
+	assert.NoError(t, err, "check should succeed")
+	assert.Equal(t, testLockID, l.ID)
+	assert.Greater(t, l.TTL, testTTL, "refresh should attach the new ttl")
+
+	assert.NoError(t, redlock.TakeOver(testResourceID, "admin", 0), "take over should succeed")
+	assert.Error(t, redlock.Unlock(testResourceID, testLockID), "unlock of a foreign lock should fail")
+	assert.NoError(t, redlock.Unlock(testResourceID, "admin"), "unlock should succeed")
+
+	_, err = redlock.Lock(testResourceID, "someoneelse", testTTL)
+	assert.NoError(t, err, "a released lock should be acquired")
+	assert.NoError(t, redlock.ForceUnlock(testResourceID), "force unlock should succeed")
+}
+
+func TestEtcdStore_Expiry(t *testing.T) {
+	store, cleanup := newTestEtcdStore(t)
+	defer cleanup()
+
+	ok, err := store.SetIfAbsent(testResourceID, testLockID, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Eventually(t, func() bool {
+		_, _, ok, _ := store.Get(testResourceID)
+		return !ok
+	}, 10*time.Second, 100*time.Millisecond, "key should expire with its lease")
+}
+
+func TestEtcdStore_List(t *testing.T) {
+	s, cleanup := newTestEtcdStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := s.client.Put(ctx, "invoice/3", "foreign"); err != nil {
+		t.Fatalf("could not write foreign key: %s", err.Error())
+	}
+	defer s.client.Delete(ctx, "invoice/3")
+
+	s.SetIfAbsent("invoice/1", testLockID, time.Minute)
+	s.SetIfAbsent("invoice/2", testLockID, 0)
+	s.SetIfAbsent("parcel/1", testLockID, time.Minute)
+
+	entries, err := s.List("invoice/")
+	assert.NoError(t, err, "list should succeed")
+	assert.Len(t, entries, 2, "keys outside the prefix should not be listed")
+
+	entries, err = s.List("")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3, "an empty prefix should list the keys of the store only")
+	assert.Equal(t, "invoice/1", entries[0].Key, "the prefix should be stripped")
+	assert.Greater(t, entries[0].TTL, 50*time.Second, "the ttl should be taken from the lease")
+
+	assert.NoError(t, s.Delete("invoice/3"))
+	ok, err := s.SetIfExists("invoice/3", testLockID, 0)
+	assert.NoError(t, err)
+	assert.False(t, ok, "keys outside the prefix should not be overwritten")
+
+	resp, err := s.client.Get(ctx, "invoice/3")
+	assert.NoError(t, err)
+	assert.Len(t, resp.Kvs, 1, "keys outside the prefix should not be deleted")
+}
+
+func TestNewEtcdStore_Prefix(t *testing.T) {
+	_, err := NewEtcdStore(nil, "")
+	assert.Equal(t, ErrEtcdPrefix, err, "the prefix should be required")
+}
+
+func TestEtcdStore_ExtendByIfOwner(t *testing.T) {
+	s, cleanup := newTestEtcdStore(t)
+	defer cleanup()
+
+	testExtendByIfOwner(t, s)
+}
@@ -0,0 +1,283 @@
+package redlock
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// reservedSeparator separates a resource from the suffixes of the keys redlock keeps next to its lock,
+	// resources containing it are rejected so these keys can not be locked, listed or deleted as resources
+	reservedSeparator = "\x1f"
+
+	// leaseKeySuffix is appended to a resource to form the key recording the max lease age of its lock.
+	// The lease key holds the lock id and expires leaseKeyGrace after the lease reaches its max age.
+	leaseKeySuffix = reservedSeparator + "lease"
+
+	// leaseKeyGrace keeps the lease key beyond the max lease age, so a lock which outlives its lease by the rounding
+	// of a store or the time between reading the lease and writing the lock finds it passed instead of missing
+	leaseKeyGrace = time.Minute
+)
+
+var (
+	// ErrInvalidResource is returned for resources containing the separator reserved for keys of redlock
+	ErrInvalidResource = errors.New("invalid resource")
+
+	// ErrLeaseExceeded is returned if a refresh would keep a lock beyond the max lease age recorded on acquisition
+	ErrLeaseExceeded = errors.New("max lease age exceeded")
+
+	// ErrNoMaxLeaseAge is returned by RefreshToMaxLeaseAge refreshes of locks acquired without a max lease age
+	ErrNoMaxLeaseAge = errors.New("lock has no max lease age")
+)
+
+// RefreshMode selects the new ttl of a refreshed lock
+type RefreshMode int
+
+const (
+	// RefreshReset sets the remaining ttl of the lock to the given ttl
+	RefreshReset RefreshMode = iota
+	// RefreshExtend adds the given ttl to the remaining ttl of the lock. It is not retried, as a retry could add
+	// the ttl twice on the nodes where the first attempt succeeded.
+	RefreshExtend
+	// RefreshToMaxLeaseAge keeps the lock until its lease reaches the max age, the given ttl is ignored.
+	// The new ttl is capped at the max ttl of the TTLPolicy.
+	RefreshToMaxLeaseAge
+)
+
+// RefreshOptions select how a refresh computes the new ttl of a lock, the zero value resets the ttl
+type RefreshOptions struct {
+	Mode RefreshMode
+}
+
+// holding is the state of a lock held by a lock id on a quorum of stores
+type holding struct {
+	// ttl is the lowest remaining ttl of the lock, 0 if it does not expire
+	ttl time.Duration
+	// leased is set if a max lease age was recorded on acquisition
+	leased bool
+	// lease is the lowest remaining time until the lease reaches its max age, at most 0 once it passed
+	lease time.Duration
+}
+
+type holdingInstance struct {
+	held, leased bool
+	ttl, lease   time.Duration
+}
+
+// extendedInstance is the result of extending a lock on a single store
+type extendedInstance struct {
+	// ok is set if the lock was extended, ttl is its new ttl then and otherwise the rejected ttl if it exceeded the max
+	ok  bool
+	ttl time.Duration
+}
+
+// ValidateResource returns ErrInvalidResource if resource contains the separator reserved for keys of redlock
+func ValidateResource(resource string) error {
+	if strings.Contains(resource, reservedSeparator) {
+		return fmt.Errorf("%w :: resource %q :: must not contain \\x1f", ErrInvalidResource, resource)
+	}
+
+	return nil
+}
+
+func leaseKey(resource string) string {
+	return resource + leaseKeySuffix
+}
+
+func isLeaseKey(key string) bool {
+	return strings.HasSuffix(key, leaseKeySuffix)
+}
+
+// recordLease replaces the lease key of a lock which was just acquired. Without a lease a stale lease key
+// left by an expired lock with the same id is removed. A stale lease key of another lock id is overwritten,
+// as only the holder of the lock writes its lease key.
+func recordLease(store Store, resource string, lockID string, lease time.Duration) error {
+	if lease <= 0 {
+		_, err := store.DeleteIfOwner(leaseKey(resource), lockID)
+		return err
+	}
+
+	ok, err := store.SetIfExists(leaseKey(resource), lockID, lease+leaseKeyGrace)
+	if err != nil || ok {
+		return err
+	}
+
+	_, err = store.SetIfAbsent(leaseKey(resource), lockID, lease+leaseKeyGrace)
+
+	return err
+}
+
+func holdInstance(store Store, resource string, lockID string, c chan holdingInstance) {
+	var h holdingInstance
+
+	if store == nil {
+		c <- h
+		return
+	}
+
+	id, ttl, ok, err := store.Get(resource)
+	if err != nil || !ok || id != lockID {
+		c <- h
+		return
+	}
+	h.held, h.ttl = true, ttl
+
+	id, lease, ok, err := store.Get(leaseKey(resource))
+	h.leased, h.lease = err == nil && ok && id == lockID, lease-leaseKeyGrace
+
+	c <- h
+}
+
+func extendInstance(store Store, resource string, lockID string, delta time.Duration, max time.Duration, c chan extendedInstance) {
+	if store == nil {
+		c <- extendedInstance{}
+		return
+	}
+
+	if e, ok := store.(Extender); ok {
+		ttl, ok, err := e.ExtendByIfOwner(resource, lockID, delta, max)
+		if err != nil {
+			c <- extendedInstance{}
+			return
+		}
+		c <- extendedInstance{ok: ok, ttl: ttl}
+		return
+	}
+
+	// without an Extender the ttl is read and reset, so an extend running concurrently can get lost
+	id, ttl, held, err := store.Get(resource)
+	if err != nil || !held || id != lockID || ttl == 0 {
+		c <- extendedInstance{}
+		return
+	}
+
+	ttl += delta
+	if max > 0 && ttl > max {
+		c <- extendedInstance{ttl: ttl}
+		return
+	}
+
+	ok, err := store.ExtendIfOwner(resource, lockID, ttl)
+	c <- extendedInstance{ok: err == nil && ok, ttl: ttl}
+}
+
+// hold returns the state of the lock if lockID holds it on a quorum of stores
+func (r *Redlock) hold(resource string, lockID string) (holding, bool) {
+	c := make(chan holdingInstance, len(r.stores))
+	held, leased := 0, 0
+	var h holding
+
+	for _, store := range r.stores {
+		go holdInstance(store, resource, lockID, c)
+	}
+	for i := 0; i < len(r.stores); i++ {
+		s := <-c
+		if !s.held {
+			continue
+		}
+		if held == 0 || s.ttl < h.ttl {
+			h.ttl = s.ttl
+		}
+		held++
+
+		if s.leased {
+			if leased == 0 || s.lease < h.lease {
+				h.lease = s.lease
+			}
+			leased++
+		}
+	}
+
+	h.leased = leased >= r.quorum
+
+	return h, held >= r.quorum
+}
+
+// extendOnce makes a single attempt to add delta to the remaining ttl of the held lock h on a quorum of nodes.
+// The new ttl is capped by the max of the TTLPolicy and the remaining lease, on stores implementing Extender
+// the ttl is extended and checked against the cap in one step.
+func (r *Redlock) extendOnce(resource string, lockID string, h holding, delta time.Duration) (time.Duration, bool, error) {
+	if delta <= 0 {
+		return 0, false, fmt.Errorf("%w :: resource %s :: ttl is required to extend a lock", ErrInvalidTTL, resource)
+	}
+	if h.ttl == 0 {
+		return 0, false, fmt.Errorf("%w :: resource %s :: locks without expiry can not be extended", ErrInvalidTTL, resource)
+	}
+	if h.leased && h.lease <= 0 {
+		return 0, false, fmt.Errorf("%w :: resource %s :: the lease has passed", ErrLeaseExceeded, resource)
+	}
+
+	max := r.TTLPolicy(resource).Max
+	leased := h.leased && (max == 0 || h.lease < max)
+	if leased {
+		max = h.lease
+	}
+
+	c := make(chan extendedInstance, len(r.stores))
+	success, rejected := 0, time.Duration(0)
+	var ttl time.Duration
+	start := r.clock.Now()
+
+	for _, store := range r.stores {
+		go extendInstance(store, resource, lockID, delta, max, c)
+	}
+	for i := 0; i < len(r.stores); i++ {
+		e := <-c
+		switch {
+		case e.ok:
+			if success == 0 || e.ttl < ttl {
+				ttl = e.ttl
+			}
+			success++
+		case e.ttl > rejected:
+			rejected = e.ttl
+		}
+	}
+
+	if validityTime := r.validity(ttl, r.clock.Now().Sub(start)); success >= r.quorum && validityTime > 0 {
+		return validityTime, true, nil
+	}
+
+	switch {
+	case rejected > 0 && leased:
+		return 0, false, fmt.Errorf("%w :: resource %s :: ttl %s exceeds the remaining lease of %s", ErrLeaseExceeded, resource, rejected.Round(time.Millisecond), max.Round(time.Millisecond))
+	case rejected > 0:
+		return 0, false, fmt.Errorf("%w :: resource %s :: ttl %s exceeds the maximum of %s", ErrInvalidTTL, resource, rejected.Round(time.Millisecond), max)
+	}
+
+	return 0, false, nil
+}
+
+// refreshTTL returns the new ttl of the held lock h for the given refresh mode, extends are made by extendOnce
+func (r *Redlock) refreshTTL(resource string, h holding, ttl time.Duration, mode RefreshMode) (time.Duration, error) {
+	if h.leased && h.lease <= 0 {
+		return 0, fmt.Errorf("%w :: resource %s :: the lease has passed", ErrLeaseExceeded, resource)
+	}
+
+	switch mode {
+	case RefreshReset:
+	case RefreshToMaxLeaseAge:
+		if !h.leased {
+			return 0, fmt.Errorf("%w :: resource %s", ErrNoMaxLeaseAge, resource)
+		}
+		// the policy may have been lowered since the lock was acquired
+		if p := r.TTLPolicy(resource); p.Max > 0 && h.lease > p.Max {
+			return p.Max, nil
+		}
+		return h.lease, nil
+	default:
+		return 0, fmt.Errorf("%w :: resource %s :: unknown refresh mode %d", ErrInvalidTTL, resource, mode)
+	}
+
+	// a ttl of 0 would remove the expiry of the lock
+	if ttl <= 0 {
+		return 0, fmt.Errorf("%w :: resource %s :: ttl is required", ErrInvalidTTL, resource)
+	}
+	if h.leased && ttl > h.lease {
+		return 0, fmt.Errorf("%w :: resource %s :: ttl %s exceeds the remaining lease of %s", ErrLeaseExceeded, resource, ttl, h.lease.Round(time.Millisecond))
+	}
+
+	return ttl, nil
+}
@@ -0,0 +1,294 @@
+package redlock
+
+import (
+	"context"
+	"errors"
+	"github.com/stoex/go-lock/pkg/redlock/redlocktest"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRedlock_RefreshModes(t *testing.T) {
+	redlock, stores, clock := newClockRedlock(0)
+	ctx := context.Background()
+
+	_, err := redlock.LockContextWithOptions(ctx, testResourceID, testLockID, 10, &LockOptions{MaxLeaseAge: time.Minute})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	clock.Advance(5 * time.Second)
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 20, &RefreshOptions{Mode: RefreshExtend})
+	assert.NoError(t, err)
+	_, ttl, _, _ := stores[0].Get(testResourceID)
+	assert.Equal(t, 25*time.Second, ttl, "extending should add to the remaining ttl")
+
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 10, &RefreshOptions{Mode: RefreshReset})
+	assert.NoError(t, err)
+	_, ttl, _, _ = stores[0].Get(testResourceID)
+	assert.Equal(t, 10*time.Second, ttl, "resetting should replace the remaining ttl")
+
+	clock.Advance(5 * time.Second)
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 0, &RefreshOptions{Mode: RefreshToMaxLeaseAge})
+	assert.NoError(t, err)
+	_, ttl, _, _ = stores[0].Get(testResourceID)
+	assert.Equal(t, 50*time.Second, ttl, "the lock should be kept until the max lease age")
+
+	_, err = redlock.Refresh(testResourceID, testLockID, 51)
+	assert.True(t, errors.Is(err, ErrLeaseExceeded), "resetting past the max lease age should be rejected")
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 1, &RefreshOptions{Mode: RefreshExtend})
+	assert.True(t, errors.Is(err, ErrLeaseExceeded), "extending past the max lease age should be rejected")
+
+	clock.Advance(50 * time.Second)
+	_, err = redlock.Refresh(testResourceID, testLockID, 10)
+	assert.Error(t, err, "the lock should expire at the max lease age")
+
+	_, err = redlock.Lock(testResourceID, testLockID, 10)
+	assert.NoError(t, err)
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 0, &RefreshOptions{Mode: RefreshToMaxLeaseAge})
+	assert.True(t, errors.Is(err, ErrNoMaxLeaseAge), "locks without max lease age can not be refreshed up to it")
+	_, err = redlock.Refresh(testResourceID, testLockID, 3600)
+	assert.NoError(t, err, "locks without max lease age should not be capped")
+}
+
+func TestRedlock_MaxLeaseAge(t *testing.T) {
+	redlock, stores, clock := newClockRedlock(0)
+	ctx := context.Background()
+
+	_, err := redlock.LockContextWithOptions(ctx, testResourceID, testLockID, 120, &LockOptions{MaxLeaseAge: time.Minute})
+	assert.True(t, errors.Is(err, ErrInvalidTTL), "the ttl should not exceed the max lease age")
+
+	_, err = redlock.LockContextWithOptions(ctx, testResourceID, testLockID, 10, &LockOptions{MaxLeaseAge: time.Minute})
+	assert.NoError(t, err)
+
+	locks, err := redlock.List("")
+	assert.NoError(t, err)
+	assert.Len(t, locks, 1, "lease keys should not be listed")
+
+	assert.NoError(t, redlock.Unlock(testResourceID, testLockID))
+	_, _, ok, _ := stores[0].Get(leaseKey(testResourceID))
+	assert.False(t, ok, "unlocking should remove the lease")
+
+	_, err = redlock.LockContextWithOptions(ctx, testResourceID, testLockID, 10, &LockOptions{MaxLeaseAge: time.Minute})
+	assert.NoError(t, err)
+	clock.Advance(10 * time.Second)
+
+	// the lock expired without unlocking, reacquiring it with the same id must not inherit the old lease
+	_, err = redlock.Lock(testResourceID, testLockID, 10)
+	assert.NoError(t, err)
+	_, err = redlock.Refresh(testResourceID, testLockID, 3600)
+	assert.NoError(t, err, "stale leases should be removed on acquisition")
+
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 10, &RefreshOptions{Mode: RefreshMode(42)})
+	assert.True(t, errors.Is(err, ErrInvalidTTL), "unknown modes should be rejected")
+}
+
+func TestRedlock_MaxLeaseAgePolicy(t *testing.T) {
+	redlock, stores, _ := newClockRedlock(0)
+	ctx := context.Background()
+	redlock.SetTTLPolicies(TTLPolicy{Max: time.Minute})
+
+	_, err := redlock.LockContextWithOptions(ctx, testResourceID, testLockID, 10, &LockOptions{MaxLeaseAge: time.Hour})
+	assert.True(t, errors.Is(err, ErrInvalidTTL), "the max lease age should not exceed the max ttl")
+
+	_, err = redlock.LockContextWithOptions(ctx, testResourceID, testLockID, 10, &LockOptions{MaxLeaseAge: time.Minute})
+	assert.NoError(t, err)
+
+	redlock.SetTTLPolicies(TTLPolicy{Max: 30 * time.Second})
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 0, &RefreshOptions{Mode: RefreshToMaxLeaseAge})
+	assert.NoError(t, err)
+	_, ttl, _, _ := stores[0].Get(testResourceID)
+	assert.Equal(t, 30*time.Second, ttl, "refreshing up to the max lease age should be capped at the max ttl")
+}
+
+func TestRedlock_ReservedResource(t *testing.T) {
+	redlock, stores, clock := newClockRedlock(0)
+	ctx := context.Background()
+	reserved := leaseKey(testResourceID)
+
+	_, err := redlock.LockContextWithOptions(ctx, testResourceID, testLockID, 10, &LockOptions{MaxLeaseAge: time.Minute})
+	assert.NoError(t, err)
+
+	_, err = redlock.Lock(reserved, testLockID, 10)
+	assert.True(t, errors.Is(err, ErrInvalidResource), "lease keys should not be lockable")
+	_, err = redlock.Refresh(reserved, testLockID, 10)
+	assert.True(t, errors.Is(err, ErrInvalidResource))
+	_, err = redlock.Check(reserved)
+	assert.True(t, errors.Is(err, ErrInvalidResource))
+	assert.True(t, errors.Is(redlock.Unlock(reserved, testLockID), ErrInvalidResource))
+	assert.True(t, errors.Is(redlock.ForceUnlock(reserved), ErrInvalidResource))
+	assert.True(t, errors.Is(redlock.TakeOver(reserved, "other", 10), ErrInvalidResource))
+
+	id, _, ok, _ := stores[0].Get(reserved)
+	assert.True(t, ok, "the lease should be kept")
+	assert.Equal(t, testLockID, id)
+
+	// the lock expires while its lease is left, the next holder replaces the stale lease
+	clock.Advance(10 * time.Second)
+	_, err = redlock.LockContextWithOptions(ctx, testResourceID, "other", 10, &LockOptions{MaxLeaseAge: 20 * time.Second})
+	assert.NoError(t, err)
+
+	id, ttl, _, _ := stores[0].Get(reserved)
+	assert.Equal(t, "other", id, "stale leases should be replaced")
+	assert.Equal(t, 20*time.Second+leaseKeyGrace, ttl)
+}
+
+func TestRedlock_LeasePassed(t *testing.T) {
+	redlock, stores, clock := newClockRedlock(0)
+
+	_, err := redlock.LockContextWithOptions(context.Background(), testResourceID, testLockID, 10, &LockOptions{MaxLeaseAge: time.Minute})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the lock outlives its lease, e.g. by the rounding of a store
+	for _, s := range stores {
+		_, _ = s.ExtendIfOwner(testResourceID, testLockID, 2*time.Minute)
+	}
+	clock.Advance(time.Minute + time.Second)
+
+	_, err = redlock.Refresh(testResourceID, testLockID, 10)
+	assert.True(t, errors.Is(err, ErrLeaseExceeded), "resetting after the lease passed should be rejected")
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 1, &RefreshOptions{Mode: RefreshExtend})
+	assert.True(t, errors.Is(err, ErrLeaseExceeded), "extending after the lease passed should be rejected")
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 0, &RefreshOptions{Mode: RefreshToMaxLeaseAge})
+	assert.True(t, errors.Is(err, ErrLeaseExceeded), "refreshing up to a passed lease should be rejected")
+
+	for _, s := range stores {
+		_, ttl, _, _ := s.Get(testResourceID)
+		assert.NotZero(t, ttl, "the lock should keep its expiry")
+	}
+}
+
+// leaseFailingStore is a MemoryStore which fails to write leases
+type leaseFailingStore struct {
+	*MemoryStore
+}
+
+func (s *leaseFailingStore) SetIfExists(key string, val string, ttl time.Duration) (bool, error) {
+	if key == leaseKey(testResourceID) {
+		return false, errors.New("lease write failed")
+	}
+	return s.MemoryStore.SetIfExists(key, val, ttl)
+}
+
+func TestRedlock_LeaseWriteFails(t *testing.T) {
+	redlock := NewRedlock()
+	redlock.SetRetryCount(1)
+
+	var stores []*leaseFailingStore
+	for i := 0; i < 3; i++ {
+		s := &leaseFailingStore{MemoryStore: NewMemoryStore()}
+		stores = append(stores, s)
+		_ = redlock.AddStore(s)
+	}
+
+	_, err := redlock.LockContextWithOptions(context.Background(), testResourceID, testLockID, 10, &LockOptions{MaxLeaseAge: time.Minute})
+	assert.Error(t, err, "the lock should not be acquired without its lease")
+
+	for _, s := range stores {
+		assert.Eventually(t, func() bool {
+			_, _, ok, _ := s.Get(testResourceID)
+			return !ok
+		}, time.Second, time.Millisecond, "the lock should be released although no node reported success")
+	}
+
+	_, err = redlock.Lock(testResourceID, "other", 10)
+	assert.NoError(t, err, "the resource should not stay locked")
+}
+
+// yieldingStore is a MemoryStore whose reads take a moment to return, so concurrent refreshes interleave
+type yieldingStore struct {
+	*MemoryStore
+}
+
+func (s *yieldingStore) Get(key string) (string, time.Duration, bool, error) {
+	defer time.Sleep(time.Millisecond)
+	return s.MemoryStore.Get(key)
+}
+
+func TestRedlock_ConcurrentExtend(t *testing.T) {
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+	redlock := NewRedlock()
+	redlock.SetClock(clock)
+	redlock.SetRetryCount(1)
+
+	var stores []*yieldingStore
+	for i := 0; i < 3; i++ {
+		s := &yieldingStore{MemoryStore: NewMemoryStoreWithClock(clock)}
+		stores = append(stores, s)
+		_ = redlock.AddStore(s)
+	}
+
+	_, err := redlock.Lock(testResourceID, testLockID, 10)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	extend := func(n int) int32 {
+		var wg sync.WaitGroup
+		var extended int32
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := redlock.RefreshWithOptions(testResourceID, testLockID, 1, &RefreshOptions{Mode: RefreshExtend}); err == nil {
+					atomic.AddInt32(&extended, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		return extended
+	}
+
+	// the clock does not move, so every extend adds to the ttl left by the previous ones
+	assert.Equal(t, int32(10), extend(10), "concurrent extends should succeed")
+	for _, s := range stores {
+		_, ttl, _, _ := s.Get(testResourceID)
+		assert.Equal(t, 20*time.Second, ttl, "no concurrent extend should get lost")
+	}
+
+	redlock.SetTTLPolicies(TTLPolicy{Max: 25 * time.Second})
+	assert.LessOrEqual(t, extend(10), int32(5), "extends beyond the max should be rejected")
+	for _, s := range stores {
+		_, ttl, _, _ := s.Get(testResourceID)
+		assert.Equal(t, 25*time.Second, ttl, "the max should be checked against the extended ttl")
+	}
+}
+
+// extendFailingStore is a MemoryStore which fails to extend locks by a delta
+type extendFailingStore struct {
+	*MemoryStore
+}
+
+func (s *extendFailingStore) ExtendByIfOwner(key string, value string, delta time.Duration, max time.Duration) (time.Duration, bool, error) {
+	return 0, false, errors.New("extend failed")
+}
+
+func TestRedlock_ExtendNotRetried(t *testing.T) {
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+	redlock := NewRedlock()
+	redlock.SetClock(clock)
+	redlock.SetRetryCount(3)
+	redlock.SetRetryStrategy(FixedRetry(0))
+
+	extending := NewMemoryStoreWithClock(clock)
+	_ = redlock.AddStore(extending)
+	for i := 0; i < 2; i++ {
+		_ = redlock.AddStore(&extendFailingStore{MemoryStore: NewMemoryStoreWithClock(clock)})
+	}
+
+	_, err := redlock.Lock(testResourceID, testLockID, 10)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = redlock.RefreshWithOptions(testResourceID, testLockID, 30, &RefreshOptions{Mode: RefreshExtend})
+	assert.True(t, errors.Is(err, ErrNotHeld), "an extend without quorum should fail")
+
+	_, ttl, _, _ := extending.Get(testResourceID)
+	assert.Equal(t, 40*time.Second, ttl, "the delta should be added once")
+}
@@ -0,0 +1,200 @@
+package redlock
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value  string
+	expiry time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiry.IsZero() && !now.Before(e.expiry)
+}
+
+// MemoryStore is a Store keeping locks in process memory, useful for tests and single process use
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+// NewMemoryStore returns a pointer to an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithClock(SystemClock())
+}
+
+// NewMemoryStoreWithClock returns a pointer to an empty MemoryStore expiring its locks by c
+func NewMemoryStoreWithClock(c Clock) *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     c.Now,
+	}
+}
+
+// get returns the entry for key, removing it if it expired. The caller has to hold s.mu.
+func (s *MemoryStore) get(key string) (memoryEntry, bool) {
+	e, ok := s.entries[key]
+
+	if ok && e.expired(s.now()) {
+		delete(s.entries, key)
+		return memoryEntry{}, false
+	}
+
+	return e, ok
+}
+
+func (s *MemoryStore) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return s.now().Add(ttl)
+}
+
+// SetIfAbsent sets key to value if the key does not exist yet
+func (s *MemoryStore) SetIfAbsent(key string, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.get(key); ok {
+		return false, nil
+	}
+
+	s.entries[key] = memoryEntry{value: value, expiry: s.expiry(ttl)}
+
+	return true, nil
+}
+
+// SetIfExists replaces the value of an existing key, keeping the remaining ttl if ttl is 0
+func (s *MemoryStore) SetIfExists(key string, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.get(key)
+
+	if !ok {
+		return false, nil
+	}
+
+	e.value = value
+	if ttl > 0 {
+		e.expiry = s.expiry(ttl)
+	}
+	s.entries[key] = e
+
+	return true, nil
+}
+
+// DeleteIfOwner deletes key if it holds value
+func (s *MemoryStore) DeleteIfOwner(key string, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.get(key); !ok || e.value != value {
+		return false, nil
+	}
+
+	delete(s.entries, key)
+
+	return true, nil
+}
+
+// ExtendIfOwner resets the ttl of key if it holds value
+func (s *MemoryStore) ExtendIfOwner(key string, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.get(key)
+
+	if !ok || e.value != value {
+		return false, nil
+	}
+
+	e.expiry = s.expiry(ttl)
+	s.entries[key] = e
+
+	return true, nil
+}
+
+// ExtendByIfOwner adds delta to the remaining ttl of key if it holds value and the new ttl is within max
+func (s *MemoryStore) ExtendByIfOwner(key string, value string, delta time.Duration, max time.Duration) (time.Duration, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.get(key)
+
+	if !ok || e.value != value || e.expiry.IsZero() {
+		return 0, false, nil
+	}
+
+	ttl := e.expiry.Sub(s.now()) + delta
+	if max > 0 && ttl > max {
+		return ttl, false, nil
+	}
+
+	e.expiry = e.expiry.Add(delta)
+	s.entries[key] = e
+
+	return ttl, true, nil
+}
+
+// Get returns the value and remaining ttl of key
+func (s *MemoryStore) Get(key string) (string, time.Duration, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.get(key)
+
+	if !ok {
+		return "", 0, false, nil
+	}
+
+	var ttl time.Duration
+	if !e.expiry.IsZero() {
+		ttl = e.expiry.Sub(s.now())
+	}
+
+	return e.value, ttl, true, nil
+}
+
+// Delete deletes key
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+
+	return nil
+}
+
+// List returns every unexpired key starting with prefix
+func (s *MemoryStore) List(prefix string) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []Entry
+
+	for key := range s.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		e, ok := s.get(key)
+		if !ok {
+			continue
+		}
+
+		var ttl time.Duration
+		if !e.expiry.IsZero() {
+			ttl = e.expiry.Sub(s.now())
+		}
+
+		entries = append(entries, Entry{Key: key, Value: e.value, TTL: ttl})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,165 @@
+package redlock
+
+import (
+	"fmt"
+	"github.com/stoex/go-lock/pkg/redlock/redlocktest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestMemoryRedlock() (*Redlock, []*MemoryStore, error) {
+	stores := []*MemoryStore{NewMemoryStore(), NewMemoryStore(), NewMemoryStore()}
+
+	manager := NewRedlock()
+	manager.SetRetryCount(2)
+	for _, s := range stores {
+		if err := manager.AddStore(s); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return manager, stores, nil
+}
+
+func TestMemoryStore_Redlock(t *testing.T) {
+	redlock, _, err := newTestMemoryRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+
+	ttl, err := redlock.Lock(testResourceID, testLockID, testTTL)
+	assert.NoError(t, err, "lock should succeed")
+	assert.LessOrEqual(t, int(ttl), testTTL)
+
+	_, err = redlock.Lock(testResourceID, "someoneelse", testTTL)
+	assert.Error(t, err, "a held lock should not be acquired twice")
+
+	_, err = redlock.Refresh(testResourceID, testLockID, testTTL)
+	assert.NoError(t, err, "refresh should succeed")
+
+	_, err = redlock.Refresh(testResourceID, "someoneelse", testTTL)
+	assert.Error(t, err, "refresh of a foreign lock should fail")
+
+	l, err := redlock.Check(testResourceID)
+	assert.NoError(t, err, "check should succeed")
+	assert.Equal(t, testLockID, l.ID)
+
+	assert.Error(t, redlock.Unlock(testResourceID, "someoneelse"), "unlock of a foreign lock should fail")
+	assert.NoError(t, redlock.Unlock(testResourceID, testLockID), "unlock should succeed")
+
+	_, err = redlock.Lock(testResourceID, "someoneelse", testTTL)
+	assert.NoError(t, err, "a released lock should be acquired")
+}
+
+func TestMemoryStore_Quorum(t *testing.T) {
+	redlock, stores, err := newTestMemoryRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+
+	stores[0].SetIfAbsent(testResourceID, "someoneelse", time.Minute)
+	_, err = redlock.Lock(testResourceID, testLockID, testTTL)
+	assert.NoError(t, err, "a lock held on a minority of nodes should be acquired")
+
+	stores[1].Delete(testResourceID)
+	stores[2].Delete(testResourceID)
+	stores[1].SetIfAbsent(testResourceID, "someoneelse", time.Minute)
+	stores[2].SetIfAbsent(testResourceID, "someoneelse", time.Minute)
+	_, err = redlock.Lock(testResourceID, "third", testTTL)
+	assert.Error(t, err, "a lock held on a majority of nodes should not be acquired")
+	_, _, ok, _ := stores[0].Get(testResourceID)
+	assert.True(t, ok, "failed attempts should not release foreign locks")
+}
+
+func TestMemoryStore_Expiry(t *testing.T) {
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+	s := NewMemoryStoreWithClock(clock)
+
+	ok, _ := s.SetIfAbsent(testResourceID, testLockID, time.Second)
+	assert.True(t, ok)
+
+	_, ttl, ok, _ := s.Get(testResourceID)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, ttl)
+
+	ok, _ = s.ExtendIfOwner(testResourceID, testLockID, 2*time.Second)
+	assert.True(t, ok, "owner should extend")
+
+	clock.Advance(2 * time.Second)
+	_, _, ok, _ = s.Get(testResourceID)
+	assert.False(t, ok, "key should expire")
+
+	ok, _ = s.SetIfAbsent(testResourceID, "someoneelse", 0)
+	assert.True(t, ok, "expired keys should be replaced")
+}
+
+func TestMemoryStore_List(t *testing.T) {
+	redlock, stores, err := newTestMemoryRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+
+	_, err = redlock.Lock("invoice/2", testLockID, testTTL)
+	assert.NoError(t, err)
+	_, err = redlock.Lock("invoice/1", testLockID, testTTL)
+	assert.NoError(t, err)
+	_, err = redlock.Lock("parcel/1", testLockID, testTTL)
+	assert.NoError(t, err)
+	stores[0].SetIfAbsent("invoice/3", "minority", time.Minute)
+
+	locks, err := redlock.List("invoice/")
+	assert.NoError(t, err, "list should succeed")
+	assert.Len(t, locks, 2, "only locks held on a quorum with the prefix should be listed")
+	assert.Equal(t, "invoice/1", locks[0].Resource, "locks should be sorted by resource")
+	assert.Equal(t, testLockID, locks[0].ID)
+	assert.InDelta(t, testTTL, locks[0].TTL, 1)
+
+	locks, err = redlock.List("")
+	assert.NoError(t, err)
+	assert.Len(t, locks, 3, "an empty prefix should list all locks")
+
+	locks, err = redlock.List("order/")
+	assert.NoError(t, err)
+	assert.Empty(t, locks)
+}
+
+// testExtendByIfOwner checks the Extender of a store, ttls are compared with a tolerance as etcd truncates them to seconds
+func testExtendByIfOwner(t *testing.T, s Store) {
+	e, ok := s.(Extender)
+	if !assert.True(t, ok, "the store should implement Extender") {
+		return
+	}
+
+	s.SetIfAbsent(testResourceID, testLockID, 10*time.Second)
+	s.SetIfAbsent("pinned", testLockID, 0)
+
+	ttl, ok, err := e.ExtendByIfOwner(testResourceID, testLockID, 5*time.Second, 0)
+	assert.NoError(t, err)
+	assert.True(t, ok, "the lock should be extended")
+	assert.InDelta(t, 15*time.Second, ttl, float64(2*time.Second), "the delta should be added to the remaining ttl")
+
+	ttl, ok, err = e.ExtendByIfOwner(testResourceID, testLockID, 10*time.Second, 20*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, ok, "extends beyond the max should be rejected")
+	assert.InDelta(t, 25*time.Second, ttl, float64(2*time.Second), "the rejected ttl should be returned")
+
+	_, ttl, _, _ = s.Get(testResourceID)
+	assert.InDelta(t, 15*time.Second, ttl, float64(2*time.Second), "rejected extends should not change the ttl")
+
+	for _, key := range []string{"pinned", "missing"} {
+		ttl, ok, err = e.ExtendByIfOwner(key, testLockID, 5*time.Second, 0)
+		assert.NoError(t, err)
+		assert.False(t, ok, "keys without expiry or missing keys should not be extended")
+		assert.Equal(t, time.Duration(0), ttl)
+	}
+
+	ttl, ok, err = e.ExtendByIfOwner(testResourceID, "someoneelse", 5*time.Second, 0)
+	assert.NoError(t, err)
+	assert.False(t, ok, "keys held by another value should not be extended")
+	assert.Equal(t, time.Duration(0), ttl)
+}
+
+func TestMemoryStore_ExtendByIfOwner(t *testing.T) {
+	testExtendByIfOwner(t, NewMemoryStoreWithClock(redlocktest.NewClock(time.Unix(0, 0))))
+}
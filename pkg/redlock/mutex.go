@@ -0,0 +1,277 @@
+package redlock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultMutexTTL is the ttl of a mutex if none is given
+const DefaultMutexTTL = 30 * time.Second
+
+var (
+	// ErrNotAcquired is returned by TryLock and wrapped by Redlock.Lock if the lock is held by someone else
+	ErrNotAcquired = errors.New("lock not acquired")
+
+	// ErrNotHeld is returned by Unlock and Extend if the mutex is not locked and wrapped by
+	// Redlock.Unlock and Redlock.Refresh if the lock is not held by the lock id
+	ErrNotHeld = errors.New("lock not held")
+
+	// ErrNotFound is wrapped by Redlock.Check and Redlock.TakeOver if the resource is not locked
+	ErrNotFound = errors.New("lock not found")
+
+	// ErrHeld is returned by Lock and TryLock if the mutex is already locked
+	ErrHeld = errors.New("lock already held")
+)
+
+// MutexOptions configures a Mutex, the zero value uses the defaults
+type MutexOptions struct {
+	// TTL is the expiry of the lock, it is rounded up to full seconds. Defaults to DefaultMutexTTL.
+	TTL time.Duration
+	// AutoExtend keeps extending the lock in the background until it is unlocked
+	AutoExtend bool
+	// ExtendInterval is the time between automatic extensions. Defaults to a third of the TTL.
+	ExtendInterval time.Duration
+}
+
+// Mutex is a distributed lock on a single resource which keeps track of its own owner token
+// and validity. A Mutex can be locked again after it has been unlocked.
+//
+//	m := rl.NewMutex("invoice/42", nil)
+type Mutex struct {
+	redlock        *Redlock
+	resource       string
+	ttl            int
+	autoExtend     bool
+	extendInterval time.Duration
+
+	mu    sync.Mutex
+	token string
+	until time.Time
+	lost  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewMutex returns a pointer to a Mutex for resource, opts may be nil
+func (r *Redlock) NewMutex(resource string, opts *MutexOptions) *Mutex {
+	o := MutexOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.TTL <= 0 {
+		o.TTL = DefaultMutexTTL
+	}
+	if o.ExtendInterval <= 0 || o.ExtendInterval >= o.TTL {
+		o.ExtendInterval = o.TTL / 3
+	}
+
+	return &Mutex{
+		redlock:        r,
+		resource:       resource,
+		ttl:            int((o.TTL + time.Second - 1) / time.Second),
+		autoExtend:     o.AutoExtend,
+		extendInterval: o.ExtendInterval,
+	}
+}
+
+// Lock acquires the lock, retrying with the strategy of the Redlock until it succeeds, the strategy
+// gives up or ctx is done
+func (m *Mutex) Lock(ctx context.Context) error {
+	var delay time.Duration
+	s := m.redlock.RetryStrategy()
+	start := m.redlock.clock.Now()
+
+	for n := 1; ; n++ {
+		err := m.TryLock(ctx)
+
+		if err != ErrNotAcquired {
+			return err
+		}
+
+		d, ok := s.Delay(n, delay, m.redlock.clock.Now().Sub(start), m.redlock.rand)
+		if !ok {
+			return err
+		}
+		delay = d
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.redlock.clock.After(delay):
+		}
+	}
+}
+
+// TryLock makes a single attempt to acquire the lock and returns ErrNotAcquired if it is held elsewhere
+func (m *Mutex) TryLock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ValidateResource(m.resource); err != nil {
+		return err
+	}
+
+	if m.Token() != "" {
+		return ErrHeld
+	}
+
+	if _, err := m.redlock.ResolveTTL(m.resource, m.ttl, false); err != nil {
+		return err
+	}
+
+	token, err := NewToken()
+
+	if err != nil {
+		return err
+	}
+
+	start := m.redlock.clock.Now()
+	validity, ok := m.redlock.lockOnce(m.resource, token, m.ttl, 0)
+
+	if !ok {
+		return ErrNotAcquired
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" {
+		m.redlock.unlockAll(m.resource, token)
+		return ErrHeld
+	}
+
+	m.token = token
+	m.until = start.Add(validity)
+	m.lost = make(chan struct{})
+
+	if m.autoExtend {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.extend(m.token, m.lost, m.stop, m.done)
+	}
+
+	return nil
+}
+
+// Unlock stops any automatic extension and releases the lock
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	token, stop, done := m.token, m.stop, m.done
+	m.token, m.until, m.stop, m.done = "", time.Time{}, nil, nil
+	m.mu.Unlock()
+
+	if token == "" {
+		return ErrNotHeld
+	}
+
+	// wait for a running extension so it can not recreate the lock after it was released
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.redlock.Unlock(m.resource, token)
+}
+
+// Extend resets the ttl of the held lock and moves the validity deadline accordingly
+func (m *Mutex) Extend(ctx context.Context) error {
+	m.mu.Lock()
+	token := m.token
+	m.mu.Unlock()
+
+	if token == "" {
+		return ErrNotHeld
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.extendOnce(token)
+}
+
+// Token returns the owner token of the held lock or an empty string
+func (m *Mutex) Token() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.token
+}
+
+// Until returns the time until which the held lock is valid
+func (m *Mutex) Until() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.until
+}
+
+// Valid reports whether the lock is held and its validity deadline has not passed
+func (m *Mutex) Valid() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.token != "" && m.redlock.clock.Now().Before(m.until)
+}
+
+// Lost returns a channel which is closed once automatically extending the held lock failed
+func (m *Mutex) Lost() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lost
+}
+
+func (m *Mutex) extendOnce(token string) error {
+	start := m.redlock.clock.Now()
+	validity, ok := m.redlock.refreshOnce(m.resource, token, time.Duration(m.ttl)*time.Second)
+
+	if !ok {
+		return ErrNotHeld
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == token {
+		m.until = start.Add(validity)
+	}
+
+	return nil
+}
+
+func (m *Mutex) extend(token string, lost chan struct{}, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-m.redlock.clock.After(m.extendInterval):
+			if err := m.extendOnce(token); err != nil {
+				close(lost)
+				return
+			}
+		}
+	}
+}
+
+// NewToken returns a random 128 bit token hex encoded, suitable as lock id
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
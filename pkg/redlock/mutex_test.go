@@ -0,0 +1,116 @@
+package redlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMutex_Lock(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	ctx := context.Background()
+	m := redlock.NewMutex(testResourceID, nil)
+
+	assert.NoError(t, m.Lock(ctx), "lock should succeed")
+	assert.NotEmpty(t, m.Token(), "owner token should be set")
+	assert.True(t, m.Valid(), "lock should be valid")
+	assert.True(t, m.Until().After(time.Now().Add(DefaultMutexTTL-time.Second)), "validity should match the ttl")
+	assert.Equal(t, ErrHeld, m.TryLock(ctx), "a held mutex should not be locked twice")
+
+	for _, client := range testClients(redlock) {
+		assert.Equal(t, m.Token(), client.Get(testResourceID).Val(), "every node should hold the token")
+	}
+
+	assert.NoError(t, m.Unlock(ctx), "unlock should succeed")
+	assert.Empty(t, m.Token(), "owner token should be cleared")
+	assert.Equal(t, ErrNotHeld, m.Unlock(ctx), "an unlocked mutex should not be unlocked twice")
+
+	for _, client := range testClients(redlock) {
+		assert.Equal(t, int64(0), client.Exists(testResourceID).Val(), "lock should be removed from every node")
+	}
+}
+
+func TestMutex_TryLockFailHeld(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	ctx := context.Background()
+	m1 := redlock.NewMutex(testResourceID, nil)
+	m2 := redlock.NewMutex(testResourceID, nil)
+
+	assert.NoError(t, m1.TryLock(ctx))
+	assert.Equal(t, ErrNotAcquired, m2.TryLock(ctx), "a lock held elsewhere should not be acquired")
+
+	ctx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, m2.Lock(ctx), "lock should give up once ctx is done")
+
+	assert.NoError(t, m1.Unlock(context.Background()))
+	assert.NoError(t, m2.TryLock(context.Background()), "a released lock should be acquired")
+}
+
+func TestMutex_ReservedResource(t *testing.T) {
+	redlock, stores, _ := newClockRedlock(0)
+	ctx := context.Background()
+	m := redlock.NewMutex(leaseKey(testResourceID), nil)
+
+	assert.True(t, errors.Is(m.TryLock(ctx), ErrInvalidResource), "lease keys should not be lockable")
+	assert.True(t, errors.Is(m.Lock(ctx), ErrInvalidResource), "lease keys should not be lockable")
+	assert.Empty(t, m.Token())
+
+	_, _, ok, _ := stores[0].Get(leaseKey(testResourceID))
+	assert.False(t, ok, "no lease key should be written")
+}
+
+func TestMutex_Extend(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	ctx := context.Background()
+	m := redlock.NewMutex(testResourceID, &MutexOptions{TTL: 10 * time.Second})
+
+	assert.Equal(t, ErrNotHeld, m.Extend(ctx), "an unlocked mutex should not be extended")
+	assert.NoError(t, m.Lock(ctx))
+
+	until := m.Until()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, m.Extend(ctx), "extend should succeed")
+	assert.True(t, m.Until().After(until), "validity deadline should move")
+
+	for _, client := range testClients(redlock) {
+		client.Del(testResourceID)
+	}
+	assert.Equal(t, ErrNotHeld, m.Extend(ctx), "a lost lock should not be extended")
+}
+
+func TestMutex_AutoExtend(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	ctx := context.Background()
+	m := redlock.NewMutex(testResourceID, &MutexOptions{TTL: 10 * time.Second, AutoExtend: true, ExtendInterval: 20 * time.Millisecond})
+
+	assert.NoError(t, m.Lock(ctx))
+	until := m.Until()
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, m.Until().After(until), "validity deadline should be moved in the background")
+
+	for _, client := range testClients(redlock) {
+		client.Del(testResourceID)
+	}
+
+	select {
+	case <-m.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lost should be closed once extending fails")
+	}
+}
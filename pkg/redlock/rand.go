@@ -0,0 +1,43 @@
+package redlock
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Rand is the source of the retry jitter, implementations must be safe for concurrent use
+type Rand interface {
+	// Int63n returns a random number in [0, n)
+	Int63n(n int64) int64
+}
+
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRand returns a Rand safe for concurrent use producing the sequence given by seed
+func NewRand(seed int64) Rand {
+	return &lockedRand{rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (r *lockedRand) Int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rnd.Int63n(n)
+}
+
+// NewSeed returns a random seed for NewRand so processes do not share their jitter, it falls back to the current time
+func NewSeed() int64 {
+	var b [8]byte
+
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
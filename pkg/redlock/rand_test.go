@@ -0,0 +1,109 @@
+package redlock
+
+import (
+	"fmt"
+	"github.com/stoex/go-lock/pkg/redlock/redlocktest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// newHeldTestRedlock returns a test redlock whose lock on testResourceID is held by someone else
+func newHeldTestRedlock(t *testing.T) (*Redlock, *redlocktest.Clock) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	for _, client := range testClients(redlock) {
+		client.Set(testResourceID, "someoneelse", time.Duration(testTTL)*time.Second)
+	}
+
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+	clock.AutoAdvance(true)
+	redlock.SetClock(clock)
+
+	return redlock, clock
+}
+
+func TestNewRand(t *testing.T) {
+	a, b, c := NewRand(1), NewRand(1), NewRand(2)
+
+	same, other := 0, 0
+	for i := 0; i < 100; i++ {
+		x := a.Int63n(1000)
+		if x == b.Int63n(1000) {
+			same++
+		}
+		if x == c.Int63n(1000) {
+			other++
+		}
+	}
+
+	assert.Equal(t, 100, same, "equal seeds should produce the same sequence")
+	assert.Less(t, other, 10, "different seeds should produce different sequences")
+}
+
+func TestNewRedlock_Seed(t *testing.T) {
+	a, b := NewRedlock(), NewRedlock()
+
+	same := 0
+	for i := 0; i < 100; i++ {
+		if a.rand.Int63n(1000) == b.rand.Int63n(1000) {
+			same++
+		}
+	}
+
+	assert.Less(t, same, 10, "redlock instances should not share their jitter")
+}
+
+func TestRedlock_RetryTiming(t *testing.T) {
+	redlock, clock := newHeldTestRedlock(t)
+	redlock.SetRetryCount(5)
+	redlock.SetRand(NewRand(42))
+
+	start := time.Now()
+	_, err := redlock.Lock(testResourceID, testLockID, testTTL)
+	assert.Error(t, err, "redlock should return an error")
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the test clock should not sleep")
+
+	rnd := NewRand(42)
+	expected := make([]time.Duration, 4)
+	for i := range expected {
+		expected[i] = time.Duration(rnd.Int63n(int64(DefaultRetryDelay * time.Millisecond)))
+	}
+	assert.Equal(t, expected, clock.Waits(), "a seeded rand should reproduce the jitter of every retry")
+}
+
+func TestRedlock_RetryTimingDeadline(t *testing.T) {
+	redlock, clock := newHeldTestRedlock(t)
+	redlock.SetRetryCount(1000)
+	redlock.SetRetryStrategy(DeadlineRetry(FixedRetry(30*time.Millisecond), 100*time.Millisecond))
+
+	_, err := redlock.Lock(testResourceID, testLockID, testTTL)
+	assert.Error(t, err, "redlock should return an error")
+
+	ms := time.Millisecond
+	assert.Equal(t, []time.Duration{30 * ms, 30 * ms, 30 * ms, 10 * ms}, clock.Waits(), "retrying should stop at the deadline")
+}
+
+func TestRedlock_CheckRetryTiming(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	clock := redlocktest.NewClock(time.Unix(0, 0))
+	clock.AutoAdvance(true)
+	redlock.SetClock(clock)
+	redlock.SetRetryCount(3)
+	redlock.SetRetryStrategy(ExponentialRetry(10*time.Millisecond, time.Second))
+	redlock.SetRand(NewRand(7))
+
+	_, err = redlock.Check(testResourceID)
+	assert.Error(t, err, "redlock should return an error")
+
+	rnd := NewRand(7)
+	assert.Equal(t, []time.Duration{
+		time.Duration(rnd.Int63n(int64(10 * time.Millisecond))),
+		time.Duration(rnd.Int63n(int64(20 * time.Millisecond))),
+	}, clock.Waits(), "check should retry with the strategy and rand of the redlock")
+}
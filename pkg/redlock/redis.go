@@ -1,9 +1,35 @@
 package redlock
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
 	"github.com/go-redis/redis"
 )
 
+// URL schemes of the redis node kinds understood by NewRedisNode, the rediss variants connect with TLS
+const (
+	SchemeRedis       = "redis"
+	SchemeRedisTLS    = "rediss"
+	SchemeSentinel    = "redis+sentinel"
+	SchemeSentinelTLS = "rediss+sentinel"
+	SchemeCluster     = "redis+cluster"
+	SchemeClusterTLS  = "rediss+cluster"
+)
+
+// RedisNodeOptions holds the connection settings of a redis node which can not be expressed in its URL
+type RedisNodeOptions struct {
+	// TLSConfig is used for rediss URLs, it defaults to the system roots and is rejected for plain URLs
+	TLSConfig *tls.Config
+	// Username is the ACL user to authenticate as, it requires redis 6 or later
+	Username string
+	// Password replaces the password of the URL
+	Password string
+}
+
 // NewRedisClient return a pointer to a new redis client
 func NewRedisClient(addr string) (*redis.Client, error) {
 	opts, err := redis.ParseURL(addr)
@@ -30,3 +56,168 @@ func NewRedisClientPool(addr []string) ([]*redis.Client, error) {
 
 	return pool, nil
 }
+
+// NewRedisNode returns a client for a single redlock node described by addr:
+//
+//	redis[s]://[:password@]host:port[/db]                                  standalone instance
+//	redis[s]+sentinel://[:password@]host:port[,host:port...]/master[/db]   master of a sentinel group
+//	redis[s]+cluster://[:password@]host:port[,host:port...]                redis cluster
+//
+// Sentinel and cluster nodes replicate asynchronously, a lock acquired on a master can be lost
+// when it fails over before the key reached a replica. Such a node counts as one redlock member.
+func NewRedisNode(addr string) (redis.Cmdable, error) {
+	return NewRedisNodeWithOptions(addr, nil)
+}
+
+// NewRedisNodeWithOptions returns a client for the redlock node addr like NewRedisNode, opts may be nil
+func NewRedisNodeWithOptions(addr string, opts *RedisNodeOptions) (redis.Cmdable, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	o := RedisNodeOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Password == "" {
+		o.Password, _ = u.User.Password()
+	}
+
+	var tlsConfig *tls.Config
+	if strings.HasPrefix(u.Scheme, SchemeRedisTLS) {
+		tlsConfig = &tls.Config{}
+		if o.TLSConfig != nil {
+			tlsConfig = o.TLSConfig.Clone()
+		}
+	} else if o.TLSConfig != nil {
+		return nil, fmt.Errorf("redis TLS settings need a rediss URL: %s", u.Redacted())
+	}
+
+	switch u.Scheme {
+	case SchemeRedis, SchemeRedisTLS:
+		return newStandaloneClient(addr, tlsConfig, o)
+	case SchemeSentinel, SchemeSentinelTLS:
+		return newSentinelClient(u, tlsConfig, o)
+	case SchemeCluster, SchemeClusterTLS:
+		return newClusterClient(u, tlsConfig, o)
+	}
+
+	return nil, fmt.Errorf("invalid redis URL scheme: %s", u.Scheme)
+}
+
+// NewRedisNodePool returns a client for every redlock node in addr
+func NewRedisNodePool(addr []string) ([]redis.Cmdable, error) {
+	pool := make([]redis.Cmdable, len(addr))
+
+	for i, a := range addr {
+		c, err := NewRedisNode(a)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool[i] = c
+	}
+
+	return pool, nil
+}
+
+func newStandaloneClient(addr string, tlsConfig *tls.Config, o RedisNodeOptions) (*redis.Client, error) {
+	opts, err := redis.ParseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = opts.TLSConfig.ServerName
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	opts.Password, opts.DB, opts.OnConnect = authenticate(o, opts.DB)
+
+	return redis.NewClient(opts), nil
+}
+
+func newSentinelClient(u *url.URL, tlsConfig *tls.Config, o RedisNodeOptions) (*redis.Client, error) {
+	addrs, err := hosts(u)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if parts[0] == "" || len(parts) > 2 {
+		return nil, fmt.Errorf("invalid sentinel URL path, expected /master[/db]: %s", u.Path)
+	}
+
+	db := 0
+	if len(parts) == 2 {
+		if db, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid redis database number: %q", parts[1])
+		}
+	}
+
+	password, db, onConnect := authenticate(o, db)
+
+	return redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    parts[0],
+		SentinelAddrs: addrs,
+		OnConnect:     onConnect,
+		Password:      password,
+		DB:            db,
+		TLSConfig:     tlsConfig,
+	}), nil
+}
+
+func newClusterClient(u *url.URL, tlsConfig *tls.Config, o RedisNodeOptions) (*redis.ClusterClient, error) {
+	addrs, err := hosts(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		return nil, fmt.Errorf("redis cluster does not support databases: %s", u.Path)
+	}
+
+	password, _, onConnect := authenticate(o, 0)
+
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:     addrs,
+		OnConnect: onConnect,
+		Password:  password,
+		TLSConfig: tlsConfig,
+	}), nil
+}
+
+// hosts returns the comma separated seed addresses of u
+func hosts(u *url.URL) ([]string, error) {
+	addrs := strings.Split(u.Host, ",")
+
+	for _, a := range addrs {
+		if a == "" {
+			return nil, fmt.Errorf("invalid redis URL host: %q", u.Host)
+		}
+	}
+
+	return addrs, nil
+}
+
+// authenticate returns the password, database and connect hook of a client. The redis client only
+// sends AUTH with a password, so ACL users log in and select the database in the connect hook instead.
+func authenticate(o RedisNodeOptions, db int) (string, int, func(*redis.Conn) error) {
+	if o.Username == "" {
+		return o.Password, db, nil
+	}
+
+	return "", 0, func(cn *redis.Conn) error {
+		if err := cn.Do("auth", o.Username, o.Password).Err(); err != nil {
+			return err
+		}
+		if db > 0 {
+			return cn.Select(db).Err()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,207 @@
+package redlock
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	deleteIfOwnerScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+	extendIfOwnerScript = `if redis.call("get", KEYS[1]) == ARGV[1] then
+	if tonumber(ARGV[2]) > 0 then return redis.call("pexpire", KEYS[1], ARGV[2]) end
+	return redis.call("persist", KEYS[1]) + 1
+else return 0 end`
+
+	// extendByIfOwnerScript returns the new pttl, its negation if it would exceed the max or 0 if the key is not held
+	extendByIfOwnerScript = `if redis.call("get", KEYS[1]) ~= ARGV[1] then return 0 end
+local ttl = redis.call("pttl", KEYS[1])
+if ttl < 0 then return 0 end
+ttl = ttl + tonumber(ARGV[2])
+if tonumber(ARGV[3]) > 0 and ttl > tonumber(ARGV[3]) then return -ttl end
+redis.call("pexpire", KEYS[1], ttl)
+return ttl`
+
+	setIfExistsKeepTTLScript = `local ttl = redis.call("pttl", KEYS[1])
+if ttl == -2 then return 0 end
+if ttl > 0 then redis.call("set", KEYS[1], ARGV[1], "PX", ttl) else redis.call("set", KEYS[1], ARGV[1]) end
+return 1`
+
+	// getScript returns the value and pttl of a key in one step, so the key can not expire in between
+	getScript = `local value = redis.call("get", KEYS[1])
+if not value then return false end
+return {value, redis.call("pttl", KEYS[1])}`
+
+	// getStringScript returns the value and pttl of a string key, GET fails for other types which are not locks
+	getStringScript = `local value = redis.pcall("get", KEYS[1])
+if type(value) ~= "string" then return false end
+return {value, redis.call("pttl", KEYS[1])}`
+
+	// scanCount is the number of keys requested per SCAN call
+	scanCount = 100
+)
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// RedisStore is a Store backed by a single redis node
+type RedisStore struct {
+	client redis.Cmdable
+}
+
+// NewRedisStore returns a pointer to a RedisStore using client
+func NewRedisStore(client redis.Cmdable) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// SetIfAbsent sets key with SET NX
+func (s *RedisStore) SetIfAbsent(key string, value string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(key, value, ttl).Result()
+}
+
+// SetIfExists sets key with SET XX, keeping the remaining ttl if ttl is 0
+func (s *RedisStore) SetIfExists(key string, value string, ttl time.Duration) (bool, error) {
+	if ttl > 0 {
+		return s.client.SetXX(key, value, ttl).Result()
+	}
+
+	return evalBool(s.client.Eval(setIfExistsKeepTTLScript, []string{key}, value))
+}
+
+// DeleteIfOwner atomically compares the value of key and deletes it
+func (s *RedisStore) DeleteIfOwner(key string, value string) (bool, error) {
+	return evalBool(s.client.Eval(deleteIfOwnerScript, []string{key}, value))
+}
+
+// ExtendIfOwner atomically compares the value of key and resets its ttl
+func (s *RedisStore) ExtendIfOwner(key string, value string, ttl time.Duration) (bool, error) {
+	return evalBool(s.client.Eval(extendIfOwnerScript, []string{key}, value, int64(ttl/time.Millisecond)))
+}
+
+// ExtendByIfOwner atomically compares the value of key and adds delta to its ttl if it stays within max
+func (s *RedisStore) ExtendByIfOwner(key string, value string, delta time.Duration, max time.Duration) (time.Duration, bool, error) {
+	n, err := s.client.Eval(extendByIfOwnerScript, []string{key}, value, int64(delta/time.Millisecond), int64(max/time.Millisecond)).Int64()
+
+	if err != nil {
+		return 0, false, err
+	}
+	if n < 0 {
+		return time.Duration(-n) * time.Millisecond, false, nil
+	}
+
+	return time.Duration(n) * time.Millisecond, n > 0, nil
+}
+
+// Get returns the value and remaining ttl of key, read atomically by a script
+func (s *RedisStore) Get(key string) (string, time.Duration, bool, error) {
+	res, err := s.client.Eval(getScript, []string{key}).Result()
+
+	if err == redis.Nil {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return "", 0, false, nil
+	}
+
+	value, _ := values[0].(string)
+	pttl, _ := values[1].(int64)
+
+	var ttl time.Duration
+	if pttl > 0 {
+		ttl = time.Duration(pttl) * time.Millisecond
+	}
+
+	return value, ttl, true, nil
+}
+
+// Delete deletes key
+func (s *RedisStore) Delete(key string) error {
+	return s.client.Del(key).Err()
+}
+
+// List scans for the string keys starting with prefix. Keys of a cluster are scanned on every master.
+func (s *RedisStore) List(prefix string) ([]Entry, error) {
+	match := globEscaper.Replace(prefix) + "*"
+	var keys []string
+
+	scan := func(c redis.Cmdable) error {
+		it := c.Scan(0, match, scanCount).Iterator()
+		for it.Next() {
+			keys = append(keys, it.Val())
+		}
+		return it.Err()
+	}
+
+	var err error
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		var mu sync.Mutex
+		err = cluster.ForEachMaster(func(c *redis.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return scan(c)
+		})
+	} else {
+		err = scan(s.client)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(keys))
+
+	for _, key := range keys {
+		res, err := s.client.Eval(getStringScript, []string{key}).Result()
+
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values, ok := res.([]interface{})
+		if !ok || len(values) != 2 {
+			continue
+		}
+
+		value, _ := values[0].(string)
+		pttl, _ := values[1].(int64)
+
+		// the key expired between the scan and the script
+		if pttl == -2 {
+			continue
+		}
+
+		var ttl time.Duration
+		if pttl > 0 {
+			ttl = time.Duration(pttl) * time.Millisecond
+		}
+
+		entries = append(entries, Entry{Key: key, Value: value, TTL: ttl})
+	}
+
+	return entries, nil
+}
+
+// Ping checks that the redis node answers
+func (s *RedisStore) Ping() error {
+	return s.client.Ping().Err()
+}
+
+func evalBool(cmd *redis.Cmd) (bool, error) {
+	n, err := cmd.Int64()
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,108 @@
+package redlock
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewRedisNode(t *testing.T) {
+	node, err := NewRedisNode("redis://:secret@localhost:6379/1")
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, node, "redis URLs should create standalone clients")
+
+	node, err = NewRedisNode("redis+sentinel://:secret@s1:26379,s2:26379/mymaster/2")
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, node, "sentinel URLs should create failover clients")
+
+	node, err = NewRedisNode("redis+cluster://c1:7000,c2:7001")
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.ClusterClient{}, node, "cluster URLs should create cluster clients")
+
+	_, err = NewRedisNode("redis+sentinel://s1:26379")
+	assert.Error(t, err, "sentinel URLs need a master name")
+
+	_, err = NewRedisNode("redis+sentinel://s1:26379/mymaster/x")
+	assert.Error(t, err, "sentinel URLs need a numeric database")
+
+	_, err = NewRedisNode("redis+cluster://c1:7000/1")
+	assert.Error(t, err, "cluster URLs do not support databases")
+
+	_, err = NewRedisNode("memcached://localhost")
+	assert.Error(t, err, "unknown schemes should be rejected")
+}
+
+func TestNewRedisNodeWithOptions(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not start miniredis: %s", err.Error()))
+	}
+	defer s.Close()
+	s.RequireAuth("secret")
+
+	node, err := NewRedisNodeWithOptions("redis://:wrong@"+s.Addr(), &RedisNodeOptions{Password: "secret"})
+	assert.NoError(t, err)
+	assert.NoError(t, node.Ping().Err(), "the password of the options should replace the one of the URL")
+
+	node, err = NewRedisNodeWithOptions("rediss://localhost:6380/3", &RedisNodeOptions{Username: "locker", Password: "secret"})
+	assert.NoError(t, err)
+	opts := node.(*redis.Client).Options()
+	assert.NotNil(t, opts.TLSConfig, "rediss URLs should use TLS")
+	assert.Equal(t, "localhost", opts.TLSConfig.ServerName, "the server name should default to the host")
+	assert.Empty(t, opts.Password, "ACL users should authenticate in the connect hook")
+	assert.Equal(t, 0, opts.DB, "ACL users should select the database in the connect hook")
+	assert.NotNil(t, opts.OnConnect)
+
+	node, err = NewRedisNodeWithOptions("rediss+cluster://c1:7000", nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, node.(*redis.ClusterClient).Options().TLSConfig, "rediss cluster URLs should use TLS")
+
+	_, err = NewRedisNodeWithOptions("redis://localhost:6379", &RedisNodeOptions{TLSConfig: &tls.Config{}})
+	assert.Error(t, err, "TLS settings should not be ignored for plain URLs")
+}
+
+func TestRedisStore_List(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not start miniredis: %s", err.Error()))
+	}
+	defer s.Close()
+
+	client, err := NewRedisClient("redis://" + s.Addr())
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redis client: %s", err.Error()))
+	}
+	store := NewRedisStore(client)
+
+	store.SetIfAbsent("invoice/1", testLockID, time.Minute)
+	store.SetIfAbsent("invoice/*", testLockID, 0)
+	store.SetIfAbsent("invoice-1", testLockID, time.Minute)
+	s.HSet("invoice/hash", "field", "value")
+
+	entries, err := store.List("invoice/")
+	assert.NoError(t, err, "list should succeed")
+	assert.Len(t, entries, 2, "only string keys with the prefix should be listed")
+
+	entries, err = store.List("invoice/*")
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{{Key: "invoice/*", Value: testLockID}}, entries, "glob characters in the prefix should be escaped")
+}
+
+func TestRedisStore_ExtendByIfOwner(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not start miniredis: %s", err.Error()))
+	}
+	defer s.Close()
+
+	client, err := NewRedisClient("redis://" + s.Addr())
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redis client: %s", err.Error()))
+	}
+
+	testExtendByIfOwner(t, NewRedisStore(client))
+}
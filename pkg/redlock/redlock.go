@@ -1,10 +1,11 @@
 package redlock
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
-	"math/rand"
+	"sort"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -21,14 +22,21 @@ const (
 	ClockDriftFactor = 0.01
 )
 
+// errRetriesExhausted is returned by retry if no attempt succeeded
+var errRetriesExhausted = errors.New("retries exhausted")
+
 // Redlock holds the redis lock
 type Redlock struct {
-	retryCount  int
-	retryDelay  int
-	driftFactor float64
-
-	clients []redis.Cmdable
-	quorum  int
+	retryCount    int
+	retryDelay    int
+	retryStrategy RetryStrategy
+	driftFactor   float64
+	rand          Rand
+	clock         Clock
+	ttlPolicies   []TTLPolicy
+
+	stores []Store
+	quorum int
 }
 
 // Lock describes a structure holding all relevant lock info.
@@ -49,32 +57,43 @@ func NewRedlock() *Redlock {
 		retryCount:  DefaultRetryCount,
 		retryDelay:  DefaultRetryDelay,
 		driftFactor: ClockDriftFactor,
+		rand:        NewRand(NewSeed()),
+		clock:       SystemClock(),
 		quorum:      1, // int(math.Floor(float64(1/2)) + 1),
-		clients:     nil,
+		stores:      nil,
 	}
 }
 
+// AddStore adds a store (node) to the redlock manager
+func (r *Redlock) AddStore(store Store) error {
+	if store == nil {
+		return errors.New("store is nil")
+	}
+
+	r.stores = append(r.stores, store)
+	r.quorum = int(math.Floor(float64(len(r.stores)/2)) + 1)
+
+	return nil
+}
+
 // AddRedisClient adds a client to the redlock manager
 func (r *Redlock) AddRedisClient(client redis.Cmdable) error {
 	if client == nil {
 		return errors.New("client is nil")
 	}
 
-	r.clients = append(r.clients, client)
-	r.quorum = int(math.Floor(float64(len(r.clients)/2)) + 1)
-
-	return nil
+	return r.AddStore(NewRedisStore(client))
 }
 
 // AddRedisClientPool adds a pool of redis clients to the redlock manager
 func (r *Redlock) AddRedisClientPool(pool []*redis.Client) {
 	for _, c := range pool {
 		if c != nil {
-			r.clients = append(r.clients, c)
+			r.stores = append(r.stores, NewRedisStore(c))
 		}
 	}
 
-	r.quorum = int(math.Floor(float64(len(r.clients)/2)) + 1)
+	r.quorum = int(math.Floor(float64(len(r.stores)/2)) + 1)
 }
 
 // SetRetryCount sets acquire lock retry count
@@ -93,6 +112,68 @@ func (r *Redlock) SetRetryDelay(delay int) {
 	r.retryDelay = delay
 }
 
+// SetRetryStrategy sets how long to wait between attempts, nil restores the random delay of SetRetryDelay
+func (r *Redlock) SetRetryStrategy(s RetryStrategy) {
+	r.retryStrategy = s
+}
+
+// SetRand sets the source of the retry jitter, e.g. NewRand with a fixed seed for reproducible tests
+func (r *Redlock) SetRand(rnd Rand) {
+	if rnd == nil {
+		return
+	}
+	r.rand = rnd
+}
+
+// SetClock sets the clock the retries and the validity of locks are timed with
+func (r *Redlock) SetClock(c Clock) {
+	if c == nil {
+		return
+	}
+	r.clock = c
+}
+
+// RetryStrategy returns the retry strategy used unless a request overrides it
+func (r *Redlock) RetryStrategy() RetryStrategy {
+	if r.retryStrategy != nil {
+		return r.retryStrategy
+	}
+
+	return UniformRetry(time.Duration(r.retryDelay) * time.Millisecond)
+}
+
+// retry calls attempt until it succeeds, the given number of attempts were made, s stops retrying or ctx is done
+func (r *Redlock) retry(ctx context.Context, s RetryStrategy, attempts int, attempt func() bool) error {
+	var delay time.Duration
+	start := r.clock.Now()
+
+	for i := 0; i < attempts; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if attempt() {
+			return nil
+		}
+		if i == attempts-1 {
+			break
+		}
+
+		d, ok := s.Delay(i+1, delay, r.clock.Now().Sub(start), r.rand)
+		if !ok {
+			break
+		}
+		delay = d
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.clock.After(delay):
+		}
+	}
+
+	return errRetriesExhausted
+}
+
 // SetDriftFactor sets aquisition lock drift factor in milliseconds
 func (r *Redlock) SetDriftFactor(fac float64) {
 	if fac <= 0 {
@@ -101,102 +182,253 @@ func (r *Redlock) SetDriftFactor(fac float64) {
 	r.driftFactor = fac
 }
 
-func lockInstance(client redis.Cmdable, resource string, val string, ttl int, c chan bool) {
-	if client == nil {
+// lockedInstance is the result of acquiring a lock on a single store
+type lockedInstance struct {
+	store Store
+	// ok is set if the lock and its lease were written, held if the lock already existed and is not ours to release
+	ok, held bool
+}
+
+func lockInstance(store Store, resource string, val string, ttl int, lease time.Duration, c chan lockedInstance) {
+	if store == nil {
+		c <- lockedInstance{store: store}
+		return
+	}
+	ok, err := store.SetIfAbsent(resource, val, time.Duration(ttl)*time.Second)
+	if err == nil && ok {
+		err = recordLease(store, resource, val, lease)
+	}
+	c <- lockedInstance{store: store, ok: err == nil && ok, held: err == nil && !ok}
+}
+
+func unlockInstance(store Store, resource string, lockID string, c chan bool) {
+	if store == nil {
 		c <- false
 		return
 	}
-	if check := client.Exists(resource); check.Val() == int64(1) {
+	ok, err := store.DeleteIfOwner(resource, lockID)
+	if err == nil && ok {
+		_, _ = store.DeleteIfOwner(leaseKey(resource), lockID)
+	}
+	c <- err == nil && ok
+}
+
+func refreshInstance(store Store, resource string, lockID string, ttl time.Duration, c chan bool) {
+	if store == nil {
 		c <- false
+		return
 	}
-	reply := client.Set(resource, val, time.Duration(ttl)*time.Second)
-	if reply.Err() != nil || reply.Val() != "OK" {
+	ok, err := store.ExtendIfOwner(resource, lockID, ttl)
+	c <- err == nil && ok
+}
+
+func checkLockInstance(store Store, resource string, c chan *Lock) {
+	if store == nil {
+		c <- nil
+		return
+	}
+	id, ttl, ok, err := store.Get(resource)
+	if err != nil || !ok {
+		c <- nil
+		return
+	}
+	c <- &Lock{resource, id, int(seconds(ttl))}
+}
+
+func listInstance(store Store, prefix string, c chan []Entry) {
+	l, ok := store.(Lister)
+	if !ok {
+		c <- nil
+		return
+	}
+	entries, err := l.List(prefix)
+	if err != nil {
+		c <- nil
+		return
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+	c <- entries
+}
+
+func forceUnlockInstance(store Store, resource string, c chan bool) {
+	if store == nil {
 		c <- false
 		return
 	}
-	c <- true
+	c <- store.Delete(resource) == nil && store.Delete(leaseKey(resource)) == nil
 }
 
-func unlockInstance(client redis.Cmdable, resource string, lockID string, c chan bool) {
-	if client == nil {
+func takeOverInstance(store Store, resource string, lockID string, ttl int, c chan bool) {
+	if store == nil {
 		c <- false
+		return
 	}
-	if check := client.Get(resource); check.Val() == lockID {
-		reply := client.Del(resource)
-		if reply.Val() != int64(1) || reply.Err() != nil {
-			c <- false
-		}
-		c <- true
+	ok, err := store.SetIfExists(resource, lockID, time.Duration(ttl)*time.Second)
+	if err == nil && ok {
+		err = store.Delete(leaseKey(resource))
 	}
+	c <- err == nil && ok
+}
 
-	c <- false
+// seconds rounds d to whole seconds, so a lock with a ttl of 1s reports 1 rather than 0
+func seconds(d time.Duration) int64 {
+	return int64(d.Round(time.Second) / time.Second)
 }
 
-func refreshInstance(client redis.Cmdable, resource string, lockID string, ttl int, c chan bool) {
-	if client == nil {
-		c <- false
+// validity returns how long a lock with the given ttl is still valid
+// after acquiring it took elapsed, accounting for the clock drift between nodes
+func (r *Redlock) validity(expiry time.Duration, elapsed time.Duration) time.Duration {
+	drift := time.Duration(float64(expiry)*r.driftFactor) + 2*time.Millisecond
+
+	return expiry - elapsed - drift
+}
+
+// lockOnce makes a single attempt to acquire the lock on a quorum of nodes, recording its lease if lease is set.
+// On failure the lock is released again on all nodes which did not hold it before.
+func (r *Redlock) lockOnce(resource string, lockID string, ttl int, lease time.Duration) (time.Duration, bool) {
+	c := make(chan lockedInstance, len(r.stores))
+	success := 0
+	start := r.clock.Now()
+
+	for _, store := range r.stores {
+		go lockInstance(store, resource, lockID, ttl, lease, c)
 	}
-	if check := client.Get(resource); check.Val() == lockID {
-		reply := client.Set(resource, lockID, time.Duration(ttl)*time.Second)
-		if reply.Val() != "OK" || reply.Err() != nil {
-			c <- false
+
+	// every node which did not report the lock as held may have written it, e.g. if its lease write failed
+	var written []Store
+	for j := 0; j < len(r.stores); j++ {
+		l := <-c
+		if l.ok {
+			success++
+		}
+		if !l.held {
+			written = append(written, l.store)
 		}
-		c <- true
 	}
-	c <- false
-}
 
-func checkLockInstance(client redis.Cmdable, resource string, c chan *Lock) {
-	if client == nil {
-		c <- nil
+	validityTime := r.validity(time.Duration(ttl)*time.Second, r.clock.Now().Sub(start))
+	if success >= r.quorum && ttl == 0 {
+		// the lock never expires
+		return 0, true
 	}
-	if check := client.Exists(resource); check.Val() == int64(0) {
-		c <- nil
+	if success >= r.quorum && validityTime > 0 {
+		return validityTime, true
 	}
 
-	id := client.Get(resource).Val()
-	ttl := client.TTL(resource).Val()
-	c <- &Lock{resource, id, int(ttl.Seconds())}
+	unlockStores(written, resource, lockID)
+
+	return 0, false
+}
+
+// unlockAll releases the lock on every node without waiting for the results
+func (r *Redlock) unlockAll(resource string, lockID string) {
+	unlockStores(r.stores, resource, lockID)
+}
+
+// unlockStores releases the lock on the given nodes without waiting for the results
+func unlockStores(stores []Store, resource string, lockID string) {
+	c := make(chan bool, len(stores))
+
+	for _, store := range stores {
+		go unlockInstance(store, resource, lockID, c)
+	}
 }
 
-// Lock acquires a distribute lock
+// Lock acquires a distribute lock and returns its validity time rounded to whole seconds
 func (r *Redlock) Lock(resource string, lockID string, ttl int) (int64, error) {
-	for i := 0; i < r.retryCount; i++ {
-		c := make(chan bool, len(r.clients))
-		success := 0
-		start := time.Now()
+	return r.LockContext(context.Background(), resource, lockID, ttl)
+}
+
+// LockContext acquires a distributed lock like Lock but stops retrying once ctx is done
+func (r *Redlock) LockContext(ctx context.Context, resource string, lockID string, ttl int) (int64, error) {
+	return r.LockContextWithOptions(ctx, resource, lockID, ttl, nil)
+}
+
+// LockOptions override the retry settings of a Redlock for a single acquire, zero values keep the defaults
+type LockOptions struct {
+	// RetryStrategy replaces the retry strategy of the Redlock
+	RetryStrategy RetryStrategy
+	// TryOnce makes a single attempt without retrying
+	TryOnce bool
+	// MaxRetries limits the retries after the first attempt, it can not exceed the retry count of the Redlock
+	MaxRetries int
+	// MaxWait stops retrying once it passed since the first attempt
+	MaxWait time.Duration
+	// NoExpiry acquires a lock which never expires, the ttl is ignored. The TTLPolicy has to allow it.
+	NoExpiry bool
+	// MaxLeaseAge caps the time the lock can be kept by refreshing, measured from its acquisition.
+	// It can not exceed the max ttl of the TTLPolicy.
+	MaxLeaseAge time.Duration
+}
+
+// LockContextWithOptions acquires a distributed lock like LockContext, retrying as set in o
+func (r *Redlock) LockContextWithOptions(ctx context.Context, resource string, lockID string, ttl int, o *LockOptions) (int64, error) {
+	if err := ValidateResource(resource); err != nil {
+		return 0, err
+	}
 
-		for _, cli := range r.clients {
-			go lockInstance(cli, resource, lockID, ttl, c)
+	ttl, err := r.ResolveTTL(resource, ttl, o != nil && o.NoExpiry)
+	if err != nil {
+		return 0, err
+	}
+
+	s, attempts := r.RetryStrategy(), r.retryCount
+	var lease time.Duration
+
+	if o != nil {
+		if o.MaxLeaseAge < 0 || (o.MaxLeaseAge > 0 && (ttl == 0 || time.Duration(ttl)*time.Second > o.MaxLeaseAge)) {
+			return 0, fmt.Errorf("%w :: resource %s :: the ttl has to be within the max lease age of %s", ErrInvalidTTL, resource, o.MaxLeaseAge)
 		}
-		for j := 0; j < len(r.clients); j++ {
-			if <-c {
-				success++
-			}
+		if p := r.TTLPolicy(resource); p.Max > 0 && o.MaxLeaseAge > p.Max {
+			return 0, fmt.Errorf("%w :: resource %s :: max lease age %s exceeds the maximum ttl of %s", ErrInvalidTTL, resource, o.MaxLeaseAge, p.Max)
 		}
-
-		drift := int(float64(ttl)*r.driftFactor) + 2
-		costTime := time.Since(start).Nanoseconds() / 1e6
-		validityTime := int64(ttl) - costTime - int64(drift)
-		if success >= r.quorum && validityTime > 0 {
-			return validityTime, nil
+		lease = o.MaxLeaseAge
+		if o.RetryStrategy != nil {
+			s = o.RetryStrategy
+		}
+		if o.MaxRetries > 0 && o.MaxRetries+1 < attempts {
+			attempts = o.MaxRetries + 1
+		}
+		if o.TryOnce {
+			attempts = 1
 		}
-		// Wait a random delay before to retry
-		time.Sleep(time.Duration(rand.Intn(r.retryDelay)) * time.Millisecond)
+		if o.MaxWait > 0 {
+			s = DeadlineRetry(s, o.MaxWait)
+		}
+	}
+
+	var validityTime time.Duration
+	err = r.retry(ctx, s, attempts, func() bool {
+		var ok bool
+		validityTime, ok = r.lockOnce(resource, lockID, ttl, lease)
+		return ok
+	})
+
+	if err == errRetriesExhausted {
+		return 0, fmt.Errorf("failed to aquire lock :: resource %s :: lock id %s :: %w", resource, lockID, ErrNotAcquired)
+	}
+	if err != nil {
+		return 0, err
 	}
 
-	return 0, fmt.Errorf("failed to aquire lock :: resource %s :: lock id %s", resource, lockID)
+	return seconds(validityTime), nil
 }
 
 // Unlock releases an acquired lock
 func (r *Redlock) Unlock(resource string, lockID string) error {
-	c := make(chan bool, len(r.clients))
+	if err := ValidateResource(resource); err != nil {
+		return err
+	}
+
+	c := make(chan bool, len(r.stores))
 	success := 0
 
-	for _, cli := range r.clients {
-		go unlockInstance(cli, resource, lockID, c)
+	for _, store := range r.stores {
+		go unlockInstance(store, resource, lockID, c)
 	}
-	for i := 0; i < len(r.clients); i++ {
+	for i := 0; i < len(r.stores); i++ {
 		if <-c {
 			success++
 		}
@@ -206,57 +438,233 @@ func (r *Redlock) Unlock(resource string, lockID string) error {
 		return nil
 	}
 
-	return fmt.Errorf("failed to unlock :: resource %s :: lock id %s", resource, lockID)
+	return fmt.Errorf("failed to unlock :: resource %s :: lock id %s :: %w", resource, lockID, ErrNotHeld)
+}
+
+// refreshOnce makes a single attempt to refresh the lock on a quorum of nodes, it never removes the expiry of the lock
+func (r *Redlock) refreshOnce(resource string, lockID string, ttl time.Duration) (time.Duration, bool) {
+	if ttl <= 0 {
+		return 0, false
+	}
+
+	c := make(chan bool, len(r.stores))
+	success := 0
+	start := r.clock.Now()
+
+	for _, store := range r.stores {
+		go refreshInstance(store, resource, lockID, ttl, c)
+	}
+	for j := 0; j < len(r.stores); j++ {
+		if <-c {
+			success++
+		}
+	}
+
+	validityTime := r.validity(ttl, r.clock.Now().Sub(start))
+	if success >= r.quorum && validityTime > 0 {
+		return validityTime, true
+	}
+
+	return 0, false
 }
 
-// Refresh checks if the lock exists & refreshes the ttl
+// Refresh checks if the lock exists & refreshes the ttl, it returns the new validity time in seconds
 func (r *Redlock) Refresh(resource string, lockID string, ttl int) (int64, error) {
-	for i := 0; i < r.retryCount; i++ {
-		c := make(chan bool, len(r.clients))
-		success := 0
-		start := time.Now()
+	return r.RefreshWithOptions(resource, lockID, ttl, nil)
+}
 
-		for _, cli := range r.clients {
-			go refreshInstance(cli, resource, lockID, ttl, c)
+// RefreshWithOptions refreshes the lock like Refresh, computing the new ttl as selected by o.
+// Refreshes keeping the lock beyond the max lease age recorded on acquisition fail with ErrLeaseExceeded.
+func (r *Redlock) RefreshWithOptions(resource string, lockID string, ttl int, o *RefreshOptions) (int64, error) {
+	if err := ValidateResource(resource); err != nil {
+		return 0, err
+	}
+
+	mode := RefreshReset
+	if o != nil {
+		mode = o.Mode
+	}
+
+	if mode == RefreshReset {
+		resolved, err := r.ResolveTTL(resource, ttl, false)
+		if err != nil {
+			return 0, err
 		}
-		for j := 0; j < len(r.clients); j++ {
-			if <-c {
-				success++
-			}
+		ttl = resolved
+	}
+
+	var validityTime time.Duration
+	var refreshErr error
+	extended := mode != RefreshExtend
+	err := r.retry(context.Background(), r.RetryStrategy(), r.retryCount, func() bool {
+		h, ok := r.hold(resource, lockID)
+		if !ok {
+			return false
+		}
+
+		// adding the delta is not idempotent, a retry would extend the nodes which succeeded before once more
+		if mode == RefreshExtend {
+			validityTime, extended, refreshErr = r.extendOnce(resource, lockID, h, time.Duration(ttl)*time.Second)
+			return true
 		}
 
-		drift := int(float64(ttl)*r.driftFactor) + 2
-		costTime := time.Since(start).Nanoseconds() / 1e6
-		validityTime := int64(ttl) - costTime - int64(drift)
-		if success >= r.quorum && validityTime > 0 {
-			return validityTime, nil
+		var expiry time.Duration
+		if expiry, refreshErr = r.refreshTTL(resource, h, time.Duration(ttl)*time.Second, mode); refreshErr != nil {
+			return true
 		}
-		// Wait a random delay before to retry
-		time.Sleep(time.Duration(rand.Intn(r.retryDelay)) * time.Millisecond)
+
+		validityTime, ok = r.refreshOnce(resource, lockID, expiry)
+		return ok
+	})
+
+	if refreshErr != nil {
+		return 0, refreshErr
+	}
+	if err != nil || !extended {
+		return 0, fmt.Errorf("failed to refresh lock :: resource %s :: lock id %s :: %w", resource, lockID, ErrNotHeld)
 	}
 
-	return 0, fmt.Errorf("failed to refresh lock :: resource %s :: lock id %s", resource, lockID)
+	return seconds(validityTime), nil
 }
 
 // Check checks if the lock exists & returns the lock data
 func (r *Redlock) Check(resource string) (*Lock, error) {
-	for i := 0; i < r.retryCount; i++ {
-		c := make(chan *Lock, len(r.clients))
+	if err := ValidateResource(resource); err != nil {
+		return nil, err
+	}
+
+	var lock *Lock
+	err := r.retry(context.Background(), r.RetryStrategy(), r.retryCount, func() bool {
+		c := make(chan *Lock, len(r.stores))
 
-		for _, cli := range r.clients {
-			go checkLockInstance(cli, resource, c)
+		for _, store := range r.stores {
+			go checkLockInstance(store, resource, c)
+		}
+		for j := 0; j < len(r.stores); j++ {
+			if lock = <-c; lock != nil {
+				return true
+			}
 		}
-		for j := 0; j < len(r.clients); j++ {
-			l := <-c
 
-			if l != nil {
-				return l, nil
+		return false
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to check lock :: resource %s :: %w", resource, ErrNotFound)
+	}
+
+	return lock, nil
+}
+
+// ForceUnlock removes a lock regardless of its owner
+func (r *Redlock) ForceUnlock(resource string) error {
+	if err := ValidateResource(resource); err != nil {
+		return err
+	}
+
+	c := make(chan bool, len(r.stores))
+	success := 0
+
+	for _, store := range r.stores {
+		go forceUnlockInstance(store, resource, c)
+	}
+	for i := 0; i < len(r.stores); i++ {
+		if <-c {
+			success++
+		}
+	}
+
+	if success >= r.quorum {
+		return nil
+	}
+
+	return fmt.Errorf("failed to force unlock :: resource %s", resource)
+}
+
+// TakeOver transfers an existing lock to a new lock id regardless of its owner.
+// If ttl is 0 the remaining expiry of the lock is kept.
+func (r *Redlock) TakeOver(resource string, lockID string, ttl int) error {
+	if err := ValidateResource(resource); err != nil {
+		return err
+	}
+
+	if ttl != 0 {
+		if _, err := r.ResolveTTL(resource, ttl, false); err != nil {
+			return err
+		}
+	}
+
+	c := make(chan bool, len(r.stores))
+	success := 0
+
+	for _, store := range r.stores {
+		go takeOverInstance(store, resource, lockID, ttl, c)
+	}
+	for i := 0; i < len(r.stores); i++ {
+		if <-c {
+			success++
+		}
+	}
+
+	if success >= r.quorum {
+		return nil
+	}
+
+	return fmt.Errorf("failed to take over lock :: resource %s :: lock id %s :: %w", resource, lockID, ErrNotFound)
+}
+
+// List returns the locks on resources starting with prefix which are held by the same lock id on a quorum
+// of stores, sorted by resource. The ttl of a lock is the lowest remaining ttl on these stores.
+// Listing fails if fewer than a quorum of stores can be listed.
+func (r *Redlock) List(prefix string) ([]Lock, error) {
+	c := make(chan []Entry, len(r.stores))
+	listed := 0
+
+	for _, store := range r.stores {
+		go listInstance(store, prefix, c)
+	}
+
+	type holder struct {
+		resource string
+		id       string
+	}
+	votes := make(map[holder]int)
+	ttls := make(map[holder]time.Duration)
+
+	for i := 0; i < len(r.stores); i++ {
+		entries := <-c
+		if entries == nil {
+			continue
+		}
+		listed++
+
+		for _, e := range entries {
+			if isLeaseKey(e.Key) {
+				continue
+			}
+			h := holder{e.Key, e.Value}
+			if ttl, ok := ttls[h]; !ok || e.TTL < ttl {
+				ttls[h] = e.TTL
 			}
+			votes[h]++
 		}
+	}
+
+	if listed < r.quorum {
+		return nil, fmt.Errorf("failed to list locks :: prefix %s :: listed %d of %d nodes", prefix, listed, len(r.stores))
+	}
 
-		// Wait a random delay before to retry
-		time.Sleep(time.Duration(rand.Intn(r.retryDelay)) * time.Millisecond)
+	locks := []Lock{}
+
+	for h, n := range votes {
+		if n >= r.quorum {
+			locks = append(locks, Lock{h.resource, h.id, int(seconds(ttls[h]))})
+		}
 	}
 
-	return nil, fmt.Errorf("failed to check lock :: resource %s", resource)
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].Resource < locks[j].Resource
+	})
+
+	return locks, nil
 }
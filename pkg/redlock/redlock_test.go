@@ -1,11 +1,13 @@
 package redlock
 
 import (
+	"context"
 	"fmt"
 	"github.com/alicebob/miniredis"
 	"github.com/elliotchance/redismock"
 	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"testing"
 	"time"
 )
@@ -39,6 +41,15 @@ func newTestRedlock() (*Redlock, error) {
 	return manager, nil
 }
 
+// testClients returns the mocked redis clients behind the stores of a test redlock instance
+func testClients(r *Redlock) []*redismock.ClientMock {
+	clients := make([]*redismock.ClientMock, len(r.stores))
+	for i, s := range r.stores {
+		clients[i] = s.(*RedisStore).client.(*redismock.ClientMock)
+	}
+	return clients
+}
+
 func TestRedlock_Lock(t *testing.T) {
 	redlock, err := newTestRedlock()
 	if err != nil {
@@ -48,18 +59,38 @@ func TestRedlock_Lock(t *testing.T) {
 	assert.LessOrEqual(t, int(ttl), testTTL, fmt.Sprintf("returned ttl (%d) should be less than or equal %d", ttl, testTTL))
 }
 
+func TestRedlock_ShortTTL(t *testing.T) {
+	redlock, _, _ := newClockRedlock(0)
+
+	ttl, err := redlock.Lock(testResourceID, testLockID, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), ttl, "the validity of a 1s lock should not be reported as 0")
+
+	ttl, err = redlock.Refresh(testResourceID, testLockID, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), ttl)
+
+	lock, err := redlock.Check(testResourceID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, lock.TTL)
+
+	ttl, err = redlock.Refresh(testResourceID, testLockID, 30)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), ttl, "a 30s lock should not report 29")
+}
+
 func TestRedlock_LockFailQuorum(t *testing.T) {
 	redlock, err := newTestRedlock()
 	if err != nil {
 		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
 	}
 	// Mocking responses for the second and third client
-	redlock.clients[0].(*redismock.ClientMock).
-		On("Set", testResourceID, testLockID, time.Duration(testTTL)*time.Second).
-		Return(redis.NewStatusResult("0", nil))
-	redlock.clients[1].(*redismock.ClientMock).
-		On("Set", testResourceID, testLockID, time.Duration(testTTL)*time.Second).
-		Return(redis.NewStatusResult("0", nil))
+	testClients(redlock)[0].
+		On("SetNX", testResourceID, testLockID, time.Duration(testTTL)*time.Second).
+		Return(redis.NewBoolResult(false, nil))
+	testClients(redlock)[1].
+		On("SetNX", testResourceID, testLockID, time.Duration(testTTL)*time.Second).
+		Return(redis.NewBoolResult(false, nil))
 
 	ttl, err := redlock.Lock(testResourceID, testLockID, testTTL)
 
@@ -73,7 +104,7 @@ func TestRedlock_LockFailAlreadyExists(t *testing.T) {
 		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
 	}
 	// Setting the lock values beforehand
-	for _, client := range redlock.clients {
+	for _, client := range testClients(redlock) {
 		client.Set(testResourceID, testLockID, time.Duration(testTTL)*time.Second)
 	}
 	ttl, err := redlock.Lock(testResourceID, testLockID, testTTL)
@@ -81,12 +112,31 @@ func TestRedlock_LockFailAlreadyExists(t *testing.T) {
 	assert.Error(t, err, "redlock should return an error")
 }
 
+func TestRedlock_LockContextCanceled(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	for _, client := range testClients(redlock) {
+		client.Set(testResourceID, "someoneelse", time.Duration(testTTL)*time.Second)
+	}
+	redlock.SetRetryCount(1000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = redlock.LockContext(ctx, testResourceID, testLockID, testTTL)
+	assert.Equal(t, context.DeadlineExceeded, err, "retries should stop once the context is done")
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
 func TestRedlock_Unlock(t *testing.T) {
 	redlock, err := newTestRedlock()
 	if err != nil {
 		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
 	}
-	for _, client := range redlock.clients {
+	for _, client := range testClients(redlock) {
 		client.Set(testResourceID, testLockID, time.Duration(testTTL)*time.Second)
 	}
 
@@ -109,16 +159,16 @@ func TestRedlock_UnlockFailQuorum(t *testing.T) {
 	if err != nil {
 		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
 	}
-	for _, client := range redlock.clients {
+	for _, client := range testClients(redlock) {
 		client.Set(testResourceID, testLockID, time.Duration(testTTL)*time.Second)
 	}
 	// Mocking responses for the second and third client
-	redlock.clients[1].(*redismock.ClientMock).
-		On("Del", []string{testResourceID}).
-		Return(redis.NewIntCmd("0", nil))
-	redlock.clients[2].(*redismock.ClientMock).
-		On("Del", []string{testResourceID}).
-		Return(redis.NewIntCmd("0", nil))
+	testClients(redlock)[1].
+		On("Eval", deleteIfOwnerScript, []string{testResourceID}, []interface{}{testLockID}).
+		Return(redis.NewCmdResult(int64(0), nil))
+	testClients(redlock)[2].
+		On("Eval", deleteIfOwnerScript, []string{testResourceID}, []interface{}{testLockID}).
+		Return(redis.NewCmdResult(int64(0), nil))
 
 	err = redlock.Unlock(testResourceID, testLockID)
 
@@ -130,7 +180,7 @@ func TestRedlock_Refresh(t *testing.T) {
 	if err != nil {
 		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
 	}
-	for _, client := range redlock.clients {
+	for _, client := range testClients(redlock) {
 		client.Set(testResourceID, testLockID, time.Duration(testTTL)*time.Second)
 	}
 
@@ -155,15 +205,20 @@ func TestRedlock_RefreshFailQuorum(t *testing.T) {
 		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
 	}
 
-	redlock.clients[0].Set(testResourceID, testLockID, time.Duration(testTTL)*time.Second)
-
-	// Mocking responses for the second and third client
-	redlock.clients[1].(*redismock.ClientMock).
-		On("Set", testResourceID, testLockID, time.Duration(testTTL)*time.Second).
-		Return(redis.NewStatusResult("0", nil))
-	redlock.clients[2].(*redismock.ClientMock).
-		On("Set", testResourceID, testLockID, time.Duration(testTTL)*time.Second).
-		Return(redis.NewStatusResult("0", nil))
+	testClients(redlock)[0].Set(testResourceID, testLockID, time.Duration(testTTL)*time.Second)
+
+	// Mocking responses for the second and third client, which hold the lock but fail to extend it
+	for _, client := range testClients(redlock)[1:] {
+		client.
+			On("Eval", getScript, []string{testResourceID}, mock.Anything).
+			Return(redis.NewCmdResult([]interface{}{testLockID, int64(testTTL * 1000)}, nil))
+		client.
+			On("Eval", getScript, []string{leaseKey(testResourceID)}, mock.Anything).
+			Return(redis.NewCmdResult(nil, redis.Nil))
+		client.
+			On("Eval", extendIfOwnerScript, []string{testResourceID}, mock.Anything).
+			Return(redis.NewCmdResult(int64(0), nil))
+	}
 
 	ttl, err := redlock.Refresh(testResourceID, testLockID, testTTL)
 	assert.Equal(t, 0, int(ttl), "refresh ttl should be 0")
@@ -203,3 +258,48 @@ func TestRedlock_CheckLockError(t *testing.T) {
 	_, err = redlock.Check(testResourceID)
 	assert.Error(t, err, "lock does not exist - check should return an error")
 }
+
+func TestRedlock_ForceUnlock(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	for _, client := range testClients(redlock) {
+		client.Set(testResourceID, "someoneelse", time.Duration(testTTL)*time.Second)
+	}
+
+	err = redlock.ForceUnlock(testResourceID)
+	assert.NoError(t, err, "force unlock should not return an error")
+
+	for _, client := range testClients(redlock) {
+		assert.Equal(t, int64(0), client.Exists(testResourceID).Val(), "lock should be removed from every node")
+	}
+}
+
+func TestRedlock_TakeOver(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	for _, client := range testClients(redlock) {
+		client.Set(testResourceID, "someoneelse", time.Duration(testTTL)*time.Second)
+	}
+
+	err = redlock.TakeOver(testResourceID, testLockID, 0)
+	assert.NoError(t, err, "take over should not return an error")
+
+	l, err := redlock.Check(testResourceID)
+	assert.NoError(t, err, "check should not return an error")
+	assert.Equal(t, testLockID, l.ID, "lock should belong to the new owner")
+	assert.LessOrEqual(t, l.TTL, testTTL, "remaining ttl should be kept")
+}
+
+func TestRedlock_TakeOverFailNotExists(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+
+	err = redlock.TakeOver(testResourceID, testLockID, testTTL)
+	assert.Error(t, err, "take over of a missing lock should return an error")
+}
@@ -0,0 +1,119 @@
+// Package redlocktest provides helpers for testing code built on pkg/redlock without real sleeps
+package redlocktest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// Clock is a fake redlock.Clock whose time only moves when it is advanced.
+// With AutoAdvance every wait moves the time forward immediately, so retry loops run without blocking.
+//
+//	clock := redlocktest.NewClock(time.Unix(0, 0))
+//	rl.SetClock(clock)
+type Clock struct {
+	mu          sync.Mutex
+	now         time.Time
+	autoAdvance bool
+	waiters     []waiter
+	waits       []time.Duration
+	changed     chan struct{}
+}
+
+// NewClock returns a pointer to a Clock set to now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now, changed: make(chan struct{})}
+}
+
+// Now returns the time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After returns a channel receiving the time once the clock was advanced by d
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.waits = append(c.waits, d)
+
+	if c.autoAdvance {
+		c.now = c.now.Add(d)
+	}
+	if d <= 0 || c.autoAdvance {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), c: ch})
+	c.notify()
+
+	return ch
+}
+
+// Advance moves the time forward by d and fires every wait which is due
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = pending
+	c.notify()
+}
+
+// AutoAdvance sets whether waits move the time forward immediately instead of blocking until Advance
+func (c *Clock) AutoAdvance(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.autoAdvance = on
+}
+
+// Waits returns the durations of all waits started on the clock in order
+func (c *Clock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]time.Duration(nil), c.waits...)
+}
+
+// BlockUntil returns once n waits are pending, e.g. before advancing past a background timer
+func (c *Clock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		pending, changed := len(c.waiters), c.changed
+		c.mu.Unlock()
+
+		if pending >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// notify wakes up BlockUntil, the caller has to hold c.mu
+func (c *Clock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
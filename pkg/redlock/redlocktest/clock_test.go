@@ -0,0 +1,47 @@
+package redlocktest_test
+
+import (
+	"github.com/stoex/go-lock/pkg/redlock"
+	"github.com/stoex/go-lock/pkg/redlock/redlocktest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var _ redlock.Clock = (*redlocktest.Clock)(nil)
+
+func TestClock_Advance(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := redlocktest.NewClock(start)
+
+	short, long := c.After(time.Second), c.After(2*time.Second)
+
+	c.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Second), <-short, "due waits should fire")
+	assert.Len(t, long, 0, "pending waits should not fire")
+
+	c.Advance(time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-long)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, c.Waits())
+}
+
+func TestClock_AutoAdvance(t *testing.T) {
+	c := redlocktest.NewClock(time.Unix(0, 0))
+	c.AutoAdvance(true)
+
+	<-c.After(time.Minute)
+	assert.Equal(t, time.Unix(60, 0), c.Now(), "waits should move the time forward")
+}
+
+func TestClock_BlockUntil(t *testing.T) {
+	c := redlocktest.NewClock(time.Unix(0, 0))
+	fired := make(chan time.Time)
+
+	go func() {
+		fired <- <-c.After(time.Second)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Second)
+	assert.Equal(t, time.Unix(1, 0), <-fired)
+}
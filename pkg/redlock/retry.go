@@ -0,0 +1,97 @@
+package redlock
+
+import "time"
+
+// RetryStrategy decides how long to wait before the next attempt to acquire a lock
+type RetryStrategy interface {
+	// Delay returns the wait before retry n (starting at 1), given the previous wait and the time
+	// elapsed since the first attempt. Jitter is drawn from rnd. Returning false stops retrying.
+	Delay(n int, prev time.Duration, elapsed time.Duration, rnd Rand) (time.Duration, bool)
+}
+
+// RetryFunc adapts a function to a RetryStrategy
+type RetryFunc func(n int, prev time.Duration, elapsed time.Duration, rnd Rand) (time.Duration, bool)
+
+// Delay calls f
+func (f RetryFunc) Delay(n int, prev time.Duration, elapsed time.Duration, rnd Rand) (time.Duration, bool) {
+	return f(n, prev, elapsed, rnd)
+}
+
+// randomDelay returns a random duration in [0, max)
+func randomDelay(rnd Rand, max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(rnd.Int63n(int64(max)))
+}
+
+// UniformRetry waits a random delay in [0, max) before every retry, it is the default of a Redlock
+func UniformRetry(max time.Duration) RetryStrategy {
+	return RetryFunc(func(_ int, _ time.Duration, _ time.Duration, rnd Rand) (time.Duration, bool) {
+		return randomDelay(rnd, max), true
+	})
+}
+
+// FixedRetry waits the same delay before every retry
+func FixedRetry(delay time.Duration) RetryStrategy {
+	return RetryFunc(func(int, time.Duration, time.Duration, Rand) (time.Duration, bool) {
+		return delay, true
+	})
+}
+
+// ExponentialRetry waits a random delay in [0, min(max, base * 2^(n-1))) before retry n ("full jitter")
+func ExponentialRetry(base time.Duration, max time.Duration) RetryStrategy {
+	return RetryFunc(func(n int, _ time.Duration, _ time.Duration, rnd Rand) (time.Duration, bool) {
+		return randomDelay(rnd, backoff(base, max, n)), true
+	})
+}
+
+// backoff returns base * 2^(n-1) capped at max
+func backoff(base time.Duration, max time.Duration, n int) time.Duration {
+	d := base
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max || d <= 0 {
+		return max
+	}
+
+	return d
+}
+
+// DecorrelatedJitterRetry waits a random delay in [base, 3 * previous delay), capped at max. The delay grows
+// like ExponentialRetry but depends on the previous one instead of the attempt, which spreads competing
+// clients further apart
+func DecorrelatedJitterRetry(base time.Duration, max time.Duration) RetryStrategy {
+	return RetryFunc(func(_ int, prev time.Duration, _ time.Duration, rnd Rand) (time.Duration, bool) {
+		if prev < base {
+			prev = base
+		}
+
+		d := base + randomDelay(rnd, 3*prev-base)
+		if d > max {
+			return max, true
+		}
+
+		return d, true
+	})
+}
+
+// DeadlineRetry waits like s but stops retrying once deadline passed since the first attempt.
+// The last delay is shortened so the final attempt is made at the deadline.
+func DeadlineRetry(s RetryStrategy, deadline time.Duration) RetryStrategy {
+	return RetryFunc(func(n int, prev time.Duration, elapsed time.Duration, rnd Rand) (time.Duration, bool) {
+		remaining := deadline - elapsed
+		if remaining <= 0 {
+			return 0, false
+		}
+
+		d, ok := s.Delay(n, prev, elapsed, rnd)
+		if d > remaining {
+			d = remaining
+		}
+
+		return d, ok
+	})
+}
@@ -0,0 +1,152 @@
+package redlock
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const testSamples = 10000
+
+// sampleDelays returns the delays of s for retry n after a wait of prev
+func sampleDelays(s RetryStrategy, n int, prev time.Duration) []time.Duration {
+	rnd := NewRand(1)
+	delays := make([]time.Duration, testSamples)
+	for i := range delays {
+		delays[i], _ = s.Delay(n, prev, 0, rnd)
+	}
+	return delays
+}
+
+// assertDistribution checks all delays lie in [min, max) and their mean is within 5% of mean
+func assertDistribution(t *testing.T, delays []time.Duration, min time.Duration, max time.Duration, mean time.Duration) {
+	var sum time.Duration
+	for _, d := range delays {
+		if d < min || d >= max {
+			t.Fatalf("delay %s out of [%s, %s)", d, min, max)
+		}
+		sum += d
+	}
+
+	avg := sum / time.Duration(len(delays))
+	assert.InDelta(t, float64(mean), float64(avg), float64(mean)*0.05, fmt.Sprintf("mean delay %s should be about %s", avg, mean))
+}
+
+func TestUniformRetry(t *testing.T) {
+	delays := sampleDelays(UniformRetry(200*time.Millisecond), 5, 0)
+	assertDistribution(t, delays, 0, 200*time.Millisecond, 100*time.Millisecond)
+}
+
+func TestFixedRetry(t *testing.T) {
+	for n := 1; n < 10; n++ {
+		d, ok := FixedRetry(50*time.Millisecond).Delay(n, 0, time.Hour, nil)
+		assert.True(t, ok, "fixed retries should never stop")
+		assert.Equal(t, 50*time.Millisecond, d)
+	}
+}
+
+func TestExponentialRetry(t *testing.T) {
+	s := ExponentialRetry(10*time.Millisecond, time.Second)
+
+	assertDistribution(t, sampleDelays(s, 1, 0), 0, 10*time.Millisecond, 5*time.Millisecond)
+	assertDistribution(t, sampleDelays(s, 4, 0), 0, 80*time.Millisecond, 40*time.Millisecond)
+	// 10ms * 2^9 exceeds the cap
+	assertDistribution(t, sampleDelays(s, 10, 0), 0, time.Second, 500*time.Millisecond)
+	assertDistribution(t, sampleDelays(s, 1000, 0), 0, time.Second, 500*time.Millisecond)
+}
+
+func TestDecorrelatedJitterRetry(t *testing.T) {
+	s := DecorrelatedJitterRetry(10*time.Millisecond, time.Second)
+
+	assertDistribution(t, sampleDelays(s, 1, 0), 10*time.Millisecond, 30*time.Millisecond, 20*time.Millisecond)
+	assertDistribution(t, sampleDelays(s, 2, 100*time.Millisecond), 10*time.Millisecond, 300*time.Millisecond, 155*time.Millisecond)
+
+	// with a previous delay of 500ms about a third of the delays in [10ms, 1.5s) exceed the cap
+	delays := sampleDelays(s, 3, 500*time.Millisecond)
+	capped := 0
+	for _, d := range delays {
+		assert.LessOrEqual(t, int64(d), int64(time.Second))
+		if d == time.Second {
+			capped++
+		}
+	}
+	assert.InDelta(t, 0.34, float64(capped)/testSamples, 0.03, "delays above the cap should be capped")
+}
+
+func TestDeadlineRetry(t *testing.T) {
+	s := DeadlineRetry(ExponentialRetry(10*time.Millisecond, time.Second), 300*time.Millisecond)
+
+	for i := 0; i < 1000; i++ {
+		var elapsed, prev time.Duration
+		rnd := NewRand(int64(i))
+		for n := 1; ; n++ {
+			d, ok := s.Delay(n, prev, elapsed, rnd)
+			if !ok {
+				break
+			}
+			elapsed += d
+			prev = d
+		}
+		assert.Equal(t, 300*time.Millisecond, elapsed, "the last retry should happen at the deadline")
+	}
+
+	d, ok := DeadlineRetry(FixedRetry(100*time.Millisecond), time.Second).Delay(1, 0, 950*time.Millisecond, nil)
+	assert.True(t, ok)
+	assert.Equal(t, 50*time.Millisecond, d, "the last delay should end at the deadline")
+
+	_, ok = DeadlineRetry(FixedRetry(100*time.Millisecond), time.Second).Delay(5, 0, time.Second, nil)
+	assert.False(t, ok, "retrying should stop at the deadline")
+}
+
+func TestRedlock_LockContextWithOptions(t *testing.T) {
+	redlock, err := newTestRedlock()
+	if err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+	for _, client := range testClients(redlock) {
+		client.Set(testResourceID, "someoneelse", time.Duration(testTTL)*time.Second)
+	}
+	redlock.SetRetryCount(1000)
+	redlock.SetRetryStrategy(FixedRetry(time.Hour))
+
+	start := time.Now()
+	_, err = redlock.LockContextWithOptions(context.Background(), testResourceID, testLockID, testTTL, &LockOptions{
+		RetryStrategy: DeadlineRetry(FixedRetry(10*time.Millisecond), 100*time.Millisecond),
+	})
+	elapsed := time.Since(start)
+
+	assert.Error(t, err, "redlock should return an error")
+	assert.GreaterOrEqual(t, int64(elapsed), int64(100*time.Millisecond), "retries should continue until the deadline")
+	assert.Less(t, int64(elapsed), int64(time.Second), "the strategy of the request should replace the one of the redlock")
+}
+
+func TestRedlock_LockOptionsAttempts(t *testing.T) {
+	ms := time.Millisecond
+
+	for name, c := range map[string]struct {
+		opts  *LockOptions
+		waits int
+	}{
+		"defaults":             {opts: nil, waits: 4},
+		"try once":             {opts: &LockOptions{TryOnce: true, MaxRetries: 3}, waits: 0},
+		"max retries":          {opts: &LockOptions{MaxRetries: 2}, waits: 2},
+		"max retries above":    {opts: &LockOptions{MaxRetries: 50}, waits: 4},
+		"max wait":             {opts: &LockOptions{MaxWait: 25 * ms}, waits: 3},
+		"max wait and retries": {opts: &LockOptions{MaxWait: 25 * ms, MaxRetries: 1}, waits: 1},
+	} {
+		redlock, clock := newHeldTestRedlock(t)
+		redlock.SetRetryCount(5)
+		redlock.SetRetryStrategy(FixedRetry(10 * ms))
+
+		_, err := redlock.LockContextWithOptions(context.Background(), testResourceID, testLockID, testTTL, c.opts)
+		assert.Error(t, err, name)
+		assert.Len(t, clock.Waits(), c.waits, name)
+
+		// the options of a request should not change the defaults
+		_, err = redlock.Lock(testResourceID, testLockID, testTTL)
+		assert.Error(t, err, name)
+		assert.Len(t, clock.Waits(), c.waits+4, name)
+	}
+}
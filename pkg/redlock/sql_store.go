@@ -0,0 +1,247 @@
+package redlock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSQLTable is the table locks are stored in
+	DefaultSQLTable = "go_lock_locks"
+
+	// DefaultSQLTimeout is the time a single sql statement may take
+	DefaultSQLTimeout = 5 * time.Second
+
+	// DefaultSQLCleanupInterval is how often expired rows are deleted
+	DefaultSQLCleanupInterval = time.Minute
+)
+
+// Dialect holds the database specific parts of the SQLStore queries
+type Dialect struct {
+	// Now is an expression returning the current time of the database in unix milliseconds
+	Now string
+}
+
+var (
+	// PostgresDialect is the dialect for PostgreSQL
+	PostgresDialect = Dialect{Now: "CAST(extract(epoch from clock_timestamp()) * 1000 AS BIGINT)"}
+
+	// SQLiteDialect is the dialect for SQLite, mainly useful for tests
+	SQLiteDialect = Dialect{Now: "CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)"}
+)
+
+// SQLStore is a Store keeping locks as rows of a table with an expiry timestamp.
+// Expiry is always computed from the database clock, so all go-lock servers share one time source.
+// Expired rows are deleted in the background while acquiring locks, at most once per cleanup interval.
+type SQLStore struct {
+	db      *sql.DB
+	table   string
+	now     string
+	timeout time.Duration
+
+	mu              sync.Mutex
+	cleanupInterval time.Duration
+	lastCleanup     time.Time
+}
+
+// NewSQLStore returns a pointer to a SQLStore using db with the given dialect
+func NewSQLStore(db *sql.DB, dialect Dialect) *SQLStore {
+	return &SQLStore{db: db, table: DefaultSQLTable, now: dialect.Now, timeout: DefaultSQLTimeout, cleanupInterval: DefaultSQLCleanupInterval}
+}
+
+// SetCleanupInterval sets how often expired rows are deleted, 0 disables the cleanup
+func (s *SQLStore) SetCleanupInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cleanupInterval = d
+}
+
+// CreateTable creates the lock table if it does not exist yet
+func (s *SQLStore) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	resource TEXT PRIMARY KEY,
+	lock_id TEXT NOT NULL,
+	expires_at BIGINT
+)`, s.table))
+
+	return err
+}
+
+// alive is the condition matching rows which did not expire yet
+func (s *SQLStore) alive() string {
+	return fmt.Sprintf("(%s.expires_at IS NULL OR %s.expires_at > %s)", s.table, s.table, s.now)
+}
+
+// expiresAt is the expiry of a row given the ttl in milliseconds as parameter n, 0 does not expire
+func (s *SQLStore) expiresAt(n int, keep string) string {
+	return fmt.Sprintf("CASE WHEN CAST($%d AS BIGINT) > 0 THEN %s + CAST($%d AS BIGINT) ELSE %s END", n, s.now, n, keep)
+}
+
+// exec runs query and reports whether it affected any row
+func (s *SQLStore) exec(query string, args ...interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// SetIfAbsent inserts a row for key, replacing an expired one
+func (s *SQLStore) SetIfAbsent(key string, value string, ttl time.Duration) (bool, error) {
+	s.cleanup()
+
+	return s.exec(fmt.Sprintf(`INSERT INTO %s (resource, lock_id, expires_at) VALUES ($1, $2, %s)
+ON CONFLICT (resource) DO UPDATE SET lock_id = excluded.lock_id, expires_at = excluded.expires_at
+WHERE NOT %s`, s.table, s.expiresAt(3, "NULL"), s.alive()), key, value, ttl.Milliseconds())
+}
+
+// SetIfExists replaces the lock id of a live row, keeping its expiry if ttl is 0
+func (s *SQLStore) SetIfExists(key string, value string, ttl time.Duration) (bool, error) {
+	return s.exec(fmt.Sprintf(`UPDATE %s SET lock_id = $2, expires_at = %s WHERE resource = $1 AND %s`,
+		s.table, s.expiresAt(3, "expires_at"), s.alive()), key, value, ttl.Milliseconds())
+}
+
+// DeleteIfOwner deletes the live row of key if it holds value
+func (s *SQLStore) DeleteIfOwner(key string, value string) (bool, error) {
+	return s.exec(fmt.Sprintf(`DELETE FROM %s WHERE resource = $1 AND lock_id = $2 AND %s`, s.table, s.alive()), key, value)
+}
+
+// ExtendIfOwner resets the expiry of the live row of key if it holds value
+func (s *SQLStore) ExtendIfOwner(key string, value string, ttl time.Duration) (bool, error) {
+	return s.exec(fmt.Sprintf(`UPDATE %s SET expires_at = %s WHERE resource = $1 AND lock_id = $2 AND %s`,
+		s.table, s.expiresAt(3, "NULL"), s.alive()), key, value, ttl.Milliseconds())
+}
+
+// ExtendByIfOwner adds delta to the expiry of the live row of key if it holds value and the new ttl is within max.
+// The update is a single statement, the new ttl is read afterwards.
+func (s *SQLStore) ExtendByIfOwner(key string, value string, delta time.Duration, max time.Duration) (time.Duration, bool, error) {
+	ok, err := s.exec(fmt.Sprintf(`UPDATE %s SET expires_at = expires_at + CAST($3 AS BIGINT)
+WHERE resource = $1 AND lock_id = $2 AND expires_at IS NOT NULL AND %s
+AND (CAST($4 AS BIGINT) = 0 OR expires_at + CAST($3 AS BIGINT) - %s <= CAST($4 AS BIGINT))`,
+		s.table, s.alive(), s.now), key, value, delta.Milliseconds(), max.Milliseconds())
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	id, ttl, held, err := s.Get(key)
+
+	if err != nil || !held || id != value || ttl <= 0 {
+		return 0, false, err
+	}
+	if !ok {
+		return ttl + delta, false, nil
+	}
+
+	return ttl, true, nil
+}
+
+// Get returns the lock id and remaining ttl of the live row of key
+func (s *SQLStore) Get(key string) (string, time.Duration, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var value string
+	var remaining sql.NullInt64
+
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT lock_id, expires_at - %s FROM %s WHERE resource = $1 AND %s`,
+		s.now, s.table, s.alive()), key).Scan(&value, &remaining)
+
+	if err == sql.ErrNoRows {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	return value, time.Duration(remaining.Int64) * time.Millisecond, true, nil
+}
+
+// List returns the lock id and remaining ttl of every live row whose key starts with prefix
+func (s *SQLStore) List(prefix string) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT resource, lock_id, expires_at - %s FROM %s WHERE resource LIKE $1 ESCAPE '\' AND %s`,
+		s.now, s.table, s.alive()), pattern)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+
+	for rows.Next() {
+		var e Entry
+		var remaining sql.NullInt64
+
+		if err := rows.Scan(&e.Key, &e.Value, &remaining); err != nil {
+			return nil, err
+		}
+
+		e.TTL = time.Duration(remaining.Int64) * time.Millisecond
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// Delete deletes the row of key
+func (s *SQLStore) Delete(key string) error {
+	_, err := s.exec(fmt.Sprintf(`DELETE FROM %s WHERE resource = $1`, s.table), key)
+
+	return err
+}
+
+// DeleteExpired deletes every expired row and returns how many were deleted
+func (s *SQLStore) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE NOT %s`, s.table, s.alive()))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// cleanup deletes the expired rows in the background once the cleanup interval passed since the last cleanup
+func (s *SQLStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cleanupInterval <= 0 || time.Since(s.lastCleanup) < s.cleanupInterval {
+		return
+	}
+	s.lastCleanup = time.Now()
+
+	go func() { _, _ = s.DeleteExpired() }()
+}
+
+// Ping checks that the database answers
+func (s *SQLStore) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	return s.db.PingContext(ctx)
+}
@@ -0,0 +1,197 @@
+package redlock
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	_ "modernc.org/sqlite"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSQLStore returns a store backed by an in-memory sqlite database standing in for postgres
+func newTestSQLStore(t *testing.T) *SQLStore {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open database: %s", err.Error())
+	}
+	// every connection of an in-memory database is a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store := NewSQLStore(db, SQLiteDialect)
+	if err := store.CreateTable(); err != nil {
+		t.Fatalf("could not create table: %s", err.Error())
+	}
+	return store
+}
+
+// newTestPostgresStore returns a store using a table of its own in the postgres database given by POSTGRES_TEST_DSN,
+// the test is skipped without one. The table is dropped on cleanup.
+func newTestPostgresStore(t *testing.T) *SQLStore {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err.Error())
+	}
+
+	store := NewSQLStore(db, PostgresDialect)
+	store.table = "go_lock_test_" + strings.ToLower(strings.ReplaceAll(t.Name(), "/", "_"))
+	if err := store.CreateTable(); err != nil {
+		db.Close()
+		t.Fatalf("could not create table: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP TABLE " + store.table)
+		db.Close()
+	})
+	return store
+}
+
+// testSQLStores runs test against sqlite and, if POSTGRES_TEST_DSN is set, against postgres
+func testSQLStores(t *testing.T, test func(t *testing.T, s *SQLStore)) {
+	t.Run("sqlite", func(t *testing.T) { test(t, newTestSQLStore(t)) })
+	t.Run("postgres", func(t *testing.T) { test(t, newTestPostgresStore(t)) })
+}
+
+// countRows returns the number of rows in the table of s, expired or not
+func countRows(t *testing.T, s *SQLStore) int {
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM " + s.table).Scan(&n); err != nil {
+		t.Fatalf("could not count rows: %s", err.Error())
+	}
+	return n
+}
+
+func TestSQLStore_Redlock(t *testing.T) {
+	testSQLStores(t, testSQLStoreRedlock)
+}
+
+func testSQLStoreRedlock(t *testing.T, s *SQLStore) {
+	redlock := NewRedlock()
+	redlock.SetRetryCount(2)
+	if err := redlock.AddStore(s); err != nil {
+		t.Fatal(fmt.Sprintf("could not create redlock instance: %s", err.Error()))
+	}
+
+	_, err := redlock.Lock(testResourceID, testLockID, testTTL)
+	assert.NoError(t, err, "lock should succeed")
+
+	_, err = redlock.Lock(testResourceID, "someoneelse", testTTL)
+	assert.Error(t, err, "a held lock should not be acquired twice")
+
+	_, err = redlock.Refresh(testResourceID, testLockID, testTTL)
+	assert.NoError(t, err, "refresh should succeed")
+
+	_, err = redlock.Refresh(testResourceID, "someoneelse", testTTL)
+	assert.Error(t, err, "refresh of a foreign lock should fail")
+
+	l, err := redlock.Check(testResourceID)
+	assert.NoError(t, err, "check should succeed")
+	assert.Equal(t, testLockID, l.ID)
+	assert.LessOrEqual(t, l.TTL, testTTL)
+
+	assert.Error(t, redlock.Unlock(testResourceID, "someoneelse"), "unlock of a foreign lock should fail")
+	assert.NoError(t, redlock.Unlock(testResourceID, testLockID), "unlock should succeed")
+
+	_, err = redlock.Lock(testResourceID, "someoneelse", testTTL)
+	assert.NoError(t, err, "a released lock should be acquired")
+
+	assert.NoError(t, redlock.TakeOver(testResourceID, testLockID, 0), "take over should succeed")
+	l, err = redlock.Check(testResourceID)
+	assert.NoError(t, err, "check should succeed")
+	assert.Equal(t, testLockID, l.ID, "take over should replace the lock id")
+
+	assert.NoError(t, redlock.ForceUnlock(testResourceID), "force unlock should succeed")
+	_, err = redlock.Lock(testResourceID, "someoneelse", testTTL)
+	assert.NoError(t, err, "a force released lock should be acquired")
+}
+
+func TestSQLStore_Expiry(t *testing.T) {
+	testSQLStores(t, testSQLStoreExpiry)
+}
+
+func testSQLStoreExpiry(t *testing.T, store *SQLStore) {
+	ok, err := store.SetIfAbsent(testResourceID, testLockID, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok, "absent key should be set")
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, _, ok, err = store.Get(testResourceID)
+	assert.NoError(t, err)
+	assert.False(t, ok, "expired key should not be returned")
+
+	ok, err = store.ExtendIfOwner(testResourceID, testLockID, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok, "expired key should not be extended")
+
+	ok, err = store.SetIfAbsent(testResourceID, "someoneelse", 0)
+	assert.NoError(t, err)
+	assert.True(t, ok, "expired key should be replaced")
+
+	value, ttl, ok, err := store.Get(testResourceID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "someoneelse", value)
+	assert.Equal(t, time.Duration(0), ttl, "a ttl of 0 should not expire")
+}
+
+func TestSQLStore_List(t *testing.T) {
+	testSQLStores(t, testSQLStoreList)
+}
+
+func testSQLStoreList(t *testing.T, s *SQLStore) {
+	s.SetIfAbsent("invoice/1", testLockID, time.Minute)
+	s.SetIfAbsent("invoice_1", testLockID, time.Minute)
+	s.SetIfAbsent("invoice/2", testLockID, 0)
+	s.SetIfAbsent("invoice/3", testLockID, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	entries, err := s.List("invoice/")
+	assert.NoError(t, err, "list should succeed")
+	assert.Len(t, entries, 2, "expired rows and rows only matching as LIKE pattern should not be listed")
+
+	for _, e := range entries {
+		if e.Key == "invoice/2" {
+			assert.Equal(t, time.Duration(0), e.TTL, "rows without expiry should have no ttl")
+		}
+	}
+}
+
+func TestSQLStore_ExtendByIfOwner(t *testing.T) {
+	testSQLStores(t, func(t *testing.T, s *SQLStore) { testExtendByIfOwner(t, s) })
+}
+
+func TestSQLStore_DeleteExpired(t *testing.T) {
+	testSQLStores(t, testSQLStoreDeleteExpired)
+}
+
+func testSQLStoreDeleteExpired(t *testing.T, s *SQLStore) {
+	s.SetCleanupInterval(0)
+
+	s.SetIfAbsent("invoice/1", testLockID, time.Millisecond)
+	s.SetIfAbsent("invoice/2", testLockID, time.Minute)
+	s.SetIfAbsent("invoice/3", testLockID, 0)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 3, countRows(t, s), "expired rows should be kept without cleanup")
+
+	n, err := s.DeleteExpired()
+	assert.NoError(t, err, "deleting expired rows should succeed")
+	assert.Equal(t, int64(1), n, "only expired rows should be deleted")
+	assert.Equal(t, 2, countRows(t, s))
+
+	s.SetIfAbsent("invoice/4", testLockID, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	s.SetCleanupInterval(time.Millisecond)
+	s.SetIfAbsent("invoice/5", testLockID, time.Minute)
+	assert.Eventually(t, func() bool { return countRows(t, s) == 3 }, time.Second, 10*time.Millisecond,
+		"acquiring should delete expired rows once the cleanup interval passed")
+}
@@ -0,0 +1,50 @@
+package redlock
+
+import (
+	"time"
+)
+
+// Store is a single node holding locks. The Redlock algorithm acquires a lock on a quorum of stores.
+// A ttl of 0 means the key does not expire.
+type Store interface {
+	// SetIfAbsent sets key to value if the key does not exist yet
+	SetIfAbsent(key string, value string, ttl time.Duration) (bool, error)
+	// SetIfExists replaces the value of an existing key. If ttl is 0 the remaining ttl is kept.
+	SetIfExists(key string, value string, ttl time.Duration) (bool, error)
+	// DeleteIfOwner deletes key if it holds value
+	DeleteIfOwner(key string, value string) (bool, error)
+	// ExtendIfOwner resets the ttl of key if it holds value
+	ExtendIfOwner(key string, value string, ttl time.Duration) (bool, error)
+	// Get returns the value and remaining ttl of key, ok is false if the key does not exist
+	Get(key string) (value string, ttl time.Duration, ok bool, err error)
+	// Delete deletes key regardless of its value
+	Delete(key string) error
+}
+
+// Extender is implemented by stores which can extend the ttl of a key by a delta in a single atomic step,
+// so concurrent extends of the same lock all count. Redlock reads the ttl and resets it on other stores.
+type Extender interface {
+	// ExtendByIfOwner adds delta to the remaining ttl of key if it holds value and expires. If the new ttl
+	// would exceed max (0 does not bound) nothing is changed and ok is false, ttl is the rejected ttl then.
+	// If key is not held by value or does not expire, ok is false and ttl is 0.
+	ExtendByIfOwner(key string, value string, delta time.Duration, max time.Duration) (ttl time.Duration, ok bool, err error)
+}
+
+// Pinger is implemented by stores which can check that their node is reachable
+type Pinger interface {
+	Ping() error
+}
+
+// Entry is a key held by a single store
+type Entry struct {
+	Key   string
+	Value string
+	// TTL is the remaining time until the key expires, 0 if it does not expire
+	TTL time.Duration
+}
+
+// Lister is implemented by stores which can enumerate their keys
+type Lister interface {
+	// List returns every unexpired key starting with prefix
+	List(prefix string) ([]Entry, error)
+}
@@ -0,0 +1,80 @@
+package redlock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidTTL is returned if a ttl is missing or outside the bounds of its TTLPolicy
+	ErrInvalidTTL = errors.New("invalid ttl")
+
+	// ErrNoExpiry is returned for locks which would never expire if their TTLPolicy does not allow it
+	ErrNoExpiry = errors.New("locks without expiry are not allowed")
+)
+
+// TTLPolicy bounds the ttl of locks on resources starting with Prefix, zero durations do not bound
+type TTLPolicy struct {
+	// Prefix selects the resources, the longest matching prefix applies. The empty prefix matches every resource.
+	Prefix string
+	// Min is the lowest ttl of a lock
+	Min time.Duration
+	// Max is the highest ttl of a lock
+	Max time.Duration
+	// Default is the ttl of locks acquired or refreshed with a ttl of 0. Without a default the ttl is required.
+	Default time.Duration
+	// AllowNoExpiry permits locks which never expire, they are requested with LockOptions.NoExpiry
+	AllowNoExpiry bool
+}
+
+// SetTTLPolicies replaces the ttl policies, resources matching no policy only require a positive ttl
+func (r *Redlock) SetTTLPolicies(policies ...TTLPolicy) {
+	p := append([]TTLPolicy(nil), policies...)
+	sort.SliceStable(p, func(i, j int) bool { return len(p[i].Prefix) > len(p[j].Prefix) })
+
+	r.ttlPolicies = p
+}
+
+// TTLPolicy returns the policy with the longest prefix of resource
+func (r *Redlock) TTLPolicy(resource string) TTLPolicy {
+	for _, p := range r.ttlPolicies {
+		if strings.HasPrefix(resource, p.Prefix) {
+			return p
+		}
+	}
+
+	return TTLPolicy{}
+}
+
+// ResolveTTL returns the ttl in seconds a lock on resource is created with. A ttl of 0 is replaced by the
+// default of the policy, noExpiry asks for a lock which never expires and resolves to 0 if the policy allows it.
+func (r *Redlock) ResolveTTL(resource string, ttl int, noExpiry bool) (int, error) {
+	p := r.TTLPolicy(resource)
+
+	if noExpiry {
+		if !p.AllowNoExpiry {
+			return 0, fmt.Errorf("%w :: resource %s", ErrNoExpiry, resource)
+		}
+		return 0, nil
+	}
+
+	if ttl == 0 && p.Default > 0 {
+		ttl = int((p.Default + time.Second - 1) / time.Second)
+	}
+
+	d := time.Duration(ttl) * time.Second
+
+	switch {
+	case ttl <= 0:
+		return 0, fmt.Errorf("%w :: resource %s :: ttl is required", ErrInvalidTTL, resource)
+	case p.Min > 0 && d < p.Min:
+		return 0, fmt.Errorf("%w :: resource %s :: ttl %s is below the minimum of %s", ErrInvalidTTL, resource, d, p.Min)
+	case p.Max > 0 && d > p.Max:
+		return 0, fmt.Errorf("%w :: resource %s :: ttl %s exceeds the maximum of %s", ErrInvalidTTL, resource, d, p.Max)
+	}
+
+	return ttl, nil
+}
@@ -0,0 +1,97 @@
+package redlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTTLTestRedlock() (*Redlock, *MemoryStore) {
+	store := NewMemoryStore()
+
+	manager := NewRedlock()
+	manager.SetRetryCount(1)
+	_ = manager.AddStore(store)
+	manager.SetTTLPolicies(
+		TTLPolicy{Min: 5 * time.Second, Max: time.Minute, Default: 30 * time.Second},
+		TTLPolicy{Prefix: "batch/", Max: time.Hour},
+		TTLPolicy{Prefix: "batch/pinned/", AllowNoExpiry: true},
+	)
+
+	return manager, store
+}
+
+func TestRedlock_ResolveTTL(t *testing.T) {
+	redlock, _ := newTTLTestRedlock()
+
+	for name, c := range map[string]struct {
+		resource string
+		ttl      int
+		noExpiry bool
+		expected int
+		err      error
+	}{
+		"within bounds":            {resource: "invoice/1", ttl: 10, expected: 10},
+		"default":                  {resource: "invoice/1", ttl: 0, expected: 30},
+		"below min":                {resource: "invoice/1", ttl: 1, err: ErrInvalidTTL},
+		"above max":                {resource: "invoice/1", ttl: 61, err: ErrInvalidTTL},
+		"negative":                 {resource: "invoice/1", ttl: -1, err: ErrInvalidTTL},
+		"no expiry denied":         {resource: "invoice/1", noExpiry: true, err: ErrNoExpiry},
+		"prefix max":               {resource: "batch/1", ttl: 3600, expected: 3600},
+		"prefix without default":   {resource: "batch/1", ttl: 0, err: ErrInvalidTTL},
+		"longest prefix":           {resource: "batch/pinned/1", noExpiry: true, expected: 0},
+		"longest prefix ttl":       {resource: "batch/pinned/1", ttl: 7200, expected: 7200},
+		"longest prefix requires":  {resource: "batch/pinned/1", ttl: 0, err: ErrInvalidTTL},
+		"prefix not matching path": {resource: "batchx/1", ttl: 3600, err: ErrInvalidTTL},
+	} {
+		ttl, err := redlock.ResolveTTL(c.resource, c.ttl, c.noExpiry)
+		if c.err != nil {
+			assert.True(t, errors.Is(err, c.err), fmt.Sprintf("%s: expected %v, got %v", name, c.err, err))
+			continue
+		}
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.expected, ttl, name)
+	}
+
+	assert.Equal(t, TTLPolicy{}, NewRedlock().TTLPolicy("invoice/1"), "redlocks without policies should not bound the ttl")
+}
+
+func TestRedlock_LockTTLPolicy(t *testing.T) {
+	redlock, store := newTTLTestRedlock()
+	ctx := context.Background()
+
+	ttl, err := redlock.Lock("invoice/1", testLockID, 0)
+	assert.NoError(t, err)
+	assert.InDelta(t, 30, ttl, 1, "the default ttl should apply")
+
+	_, remaining, _, _ := store.Get("invoice/1")
+	assert.InDelta(t, float64(30*time.Second), float64(remaining), float64(time.Second))
+
+	_, err = redlock.Lock("invoice/2", testLockID, 3600)
+	assert.True(t, errors.Is(err, ErrInvalidTTL), "ttls above the max should be rejected")
+	_, _, ok, _ := store.Get("invoice/2")
+	assert.False(t, ok, "rejected locks should not be written")
+
+	_, err = redlock.LockContextWithOptions(ctx, "invoice/3", testLockID, 0, &LockOptions{NoExpiry: true})
+	assert.True(t, errors.Is(err, ErrNoExpiry), "locks without expiry should need an explicit policy")
+
+	ttl, err = redlock.LockContextWithOptions(ctx, "batch/pinned/1", testLockID, 0, &LockOptions{NoExpiry: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), ttl, "locks without expiry should have no ttl")
+	_, remaining, ok, _ = store.Get("batch/pinned/1")
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), remaining)
+
+	_, err = redlock.Refresh("invoice/1", testLockID, 3600)
+	assert.True(t, errors.Is(err, ErrInvalidTTL), "refreshes should be bounded")
+
+	err = redlock.TakeOver("invoice/1", "other", 3600)
+	assert.True(t, errors.Is(err, ErrInvalidTTL), "take overs should be bounded")
+	assert.NoError(t, redlock.TakeOver("invoice/1", "other", 0), "take overs keeping the expiry should not be bounded")
+
+	m := redlock.NewMutex("invoice/4", &MutexOptions{TTL: time.Hour})
+	assert.True(t, errors.Is(m.TryLock(ctx), ErrInvalidTTL), "mutexes should be bounded")
+}
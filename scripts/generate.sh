@@ -1,3 +1,4 @@
 #!/usr/bin/env bash
 mkdir -p internal/generated
-protoc -I ./ lock.proto --go_out=plugins=grpc:.
\ No newline at end of file
+# lock.proto is the unversioned API served for existing clients, lock/v1 is the current one
+protoc -I ./ lock.proto lock/v1/lock.proto --go_out=plugins=grpc:.